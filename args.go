@@ -0,0 +1,274 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/as/log"
+)
+
+// reconnectArgs are inserted before http(s) inputs when AUTORECONNECT=1
+func reconnectArgs() []string {
+	return []string{
+		"-reconnect", "1",
+		"-reconnect_streamed", "1",
+		"-reconnect_delay_max", reconnectmax,
+	}
+}
+
+func isHTTP(s string) bool {
+	return strings.HasPrefix(s, "http://") || strings.HasPrefix(s, "https://")
+}
+
+func hasFlag(args []string, flag string) bool {
+	for _, a := range args {
+		if a == flag {
+			return true
+		}
+	}
+	return false
+}
+
+// flagValue returns the value following the last occurrence of flag
+func flagValue(args []string, flag string) (v string) {
+	for i := 1; i < len(args); i++ {
+		if args[i-1] == flag {
+			v = args[i]
+		}
+	}
+	return v
+}
+
+// injectReconnect returns a copy of args with the reconnect options inserted
+// immediately before each http(s) input, leaving out the ones it already
+// sets. The options of an input are everything between the prior input and
+// its -i.
+func injectReconnect(args []string) []string {
+	out := make([]string, 0, len(args))
+	group := 0
+	for i := 0; i < len(args); i++ {
+		if args[i] == "-i" && i+1 < len(args) {
+			var inj []string
+			if isHTTP(args[i+1]) {
+				opts := reconnectArgs()
+				for j := 0; j+1 < len(opts); j += 2 {
+					if !hasFlag(out[group:], opts[j]) {
+						inj = append(inj, opts[j], opts[j+1])
+					}
+				}
+			}
+			if len(inj) > 0 {
+				log.Info.Add("topic", "transcode", "action", "rewrite", "input", redact(args[i+1]), "inject", strings.Join(inj, " ")).Printf("autoreconnect")
+				out = append(out, inj...)
+			}
+			out = append(out, args[i], args[i+1])
+			i++
+			group = len(out)
+			continue
+		}
+		out = append(out, args[i])
+	}
+	return out
+}
+
+// readsStdin reports whether any input reads from the wrapper's stdin
+func readsStdin(args []string) bool {
+	for i := 1; i < len(args); i++ {
+		if args[i-1] != "-i" {
+			continue
+		}
+		switch args[i] {
+		case "-", "pipe:", "pipe:0", "/dev/stdin":
+			return true
+		}
+	}
+	return false
+}
+
+// injectNostdin prepends -nostdin unless an input is reading from stdin.
+// The NOSTDIN env var forces the decision either way.
+func injectNostdin(args []string) []string {
+	if hasFlag(args, "-nostdin") {
+		return args
+	}
+	inject, reason := !readsStdin(args), "auto"
+	switch nostdin {
+	case "0":
+		inject, reason = false, "env"
+	case "1":
+		inject, reason = true, "env"
+	}
+	log.Info.Add("topic", "transcode", "action", "rewrite", "nostdin", inject, "reason", reason).Printf("stdin detection")
+	if !inject {
+		return args
+	}
+	return append([]string{"-nostdin"}, args...)
+}
+
+// injectStatsPeriod prepends -stats_period so ffmpeg prints its status line
+// once per LOGFREQ instead of twice a second. The option exists since 4.4.
+func injectStatsPeriod(args []string, v Version) []string {
+	if hasFlag(args, "-stats_period") || !v.AtLeast(4, 4) {
+		return args
+	}
+	period := fmt.Sprintf("%g", logFreq.Seconds())
+	log.Info.Add("topic", "transcode", "action", "rewrite", "stats_period", period, "ffmpeg_version", v.Raw).Printf("match stats period to log frequency")
+	return append([]string{"-stats_period", period}, args...)
+}
+
+// usesGPU reports whether the command asks for gpu hardware
+func usesGPU(args []string) bool {
+	for _, a := range args {
+		if hastext(a, "cuda", "nvenc", "cuvid", "_npp", "vaapi", "_amf", "qsv") {
+			return true
+		}
+	}
+	return false
+}
+
+// countOutputs returns the number of outputs of the first pass. A tee
+// muxer output is one output, it's encoded once.
+func countOutputs(args []string) int {
+	return len(parseArgv(splitPasses(args)[0]).Outputs)
+}
+
+// detectOutputs sets the fps/speed multiplier from the command line.
+// The banner's Output lines correct it later, see outputCount.
+func detectOutputs(args []string) {
+	n := countOutputs(args)
+	if n == 0 {
+		return
+	}
+	targetOutputs = n
+	log.Info.Add("topic", "transcode", "action", "outputs", "outputs", n, "source", "argv").Printf("detected %d outputs, set OUTPUTS to override", n)
+}
+
+// prepareArgs applies every rewrite to argv (including argv[0]) before
+// ffmpeg starts. It returns the final argv and the rewrites that changed it.
+func prepareArgs(argv []string) (_ []string, fired []string) {
+	step := func(name string, fn func(args []string) []string) {
+		before := append([]string{}, argv[1:]...)
+		after := fn(argv[1:])
+		if strings.Join(before, "\x00") != strings.Join(after, "\x00") {
+			fired = append(fired, name)
+		}
+		argv = append(argv[:1:1], after...)
+	}
+	if autoreconnect {
+		step("reconnect", injectReconnect)
+	}
+	step("nostdin", injectNostdin)
+	step("gpuselect", selectGPU)
+	step("stats_period", func(args []string) []string { return injectStatsPeriod(args, ffversion) })
+	// last, so a retry's args start with the prefix
+	step("prefix", injectPrefix)
+
+	rules = loadRules()
+	fixups = loadFixups()
+	step("rules", func(args []string) []string {
+		rewrite(args, "")
+		return args
+	})
+	if autoprobe {
+		autoProbe(argv[1:])
+	}
+	if os.Getenv("OUTPUTS") == "" {
+		detectOutputs(argv[1:])
+	}
+
+	// NOTE(as): HWFRAMES1: For GPU featuresets, scan for hwframes on the command line and keep track of it
+	// because this value might be too small or too large for some media. In our case, assume its always too small
+	// and grow it with retry, see hwframes.go and HWFRAMES2
+	for i := 1; i < len(argv); i++ {
+		if argv[i-1] == "-extra_hw_frames" {
+			hwframes, _ = strconv.Atoi(argv[i])
+			log.Info.Add("topic", "gpu", "action", "bootstrap", "extra_hw_frames", hwframes).Printf("detected -extra_hw_frames arg")
+		}
+	}
+	return argv, fired
+}
+
+// boolFlags are the ffmpeg options that don't take a value
+var boolFlags = map[string]bool{
+	"-y": true, "-n": true, "-nostdin": true, "-stdin": true, "-hide_banner": true, "-re": true,
+	"-an": true, "-vn": true, "-sn": true, "-dn": true, "-shortest": true, "-stats": true, "-nostats": true,
+	"-copyts": true, "-start_at_zero": true, "-benchmark": true, "-benchmark_all": true, "-xerror": true,
+	"-ignore_unknown": true, "-copy_unknown": true, "-autorotate": true, "-noautorotate": true,
+	"-accurate_seek": true, "-noaccurate_seek": true, "-report": true, "-dump": true, "-hex": true,
+	"-debug_ts": true, "-vstats": true, "-qphist": true, "-bitexact": true, "-fix_sub_duration": true,
+	"-seek_timestamp": true, "-h": true, "-version": true, "-formats": true, "-codecs": true, "-encoders": true,
+	"-decoders": true, "-filters": true, "-hwaccels": true,
+}
+
+// Argv is an ffmpeg command split into its inputs and outputs
+type Argv struct {
+	Inputs  []string
+	Outputs []string
+	Formats map[string]string // -f given for an input or output, by path
+	Missing string            // an option at the end without its value
+}
+
+// parseArgv splits args, a single ffmpeg command, into inputs and outputs.
+// Options not in boolFlags are assumed to take a value.
+func parseArgv(args []string) (a Argv) {
+	a.Formats = map[string]string{}
+	format := ""
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if len(arg) < 2 || arg[0] != '-' {
+			a.Outputs = append(a.Outputs, arg)
+			a.Formats[arg], format = format, ""
+			continue
+		}
+		if boolFlags[arg] {
+			continue
+		}
+		if i+1 == len(args) {
+			a.Missing = arg
+			break
+		}
+		i++
+		switch arg {
+		case "-i":
+			a.Inputs = append(a.Inputs, args[i])
+			a.Formats[args[i]], format = format, ""
+		case "-f":
+			format = args[i]
+		}
+	}
+	return a
+}
+
+var protoRE = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9+.-]+:`)
+
+// isLocal returns true if path names a regular local file, not stdio,
+// a protocol URL, a device or a muxer pseudo-output
+func isLocal(path, format string) bool {
+	switch {
+	case path == "-", path == "/dev/null", path == "NUL", strings.HasPrefix(path, "/dev/"):
+		return false
+	case protoRE.MatchString(path):
+		return false
+	}
+	switch format {
+	case "lavfi", "null", "tee", "concat", "v4l2", "alsa", "pulse", "x11grab", "dshow", "gdigrab", "avfoundation", "decklink":
+		return false
+	}
+	return true
+}
+
+// localOutputs returns the output files of every pass
+func localOutputs(args []string) (files []string) {
+	for _, pass := range splitPasses(args) {
+		a := parseArgv(pass)
+		for _, out := range a.Outputs {
+			if isLocal(out, a.Formats[out]) && !hasFlag(files, out) {
+				files = append(files, out)
+			}
+		}
+	}
+	return files
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/as/log"
+)
+
+func TestCountOutputs(t *testing.T) {
+	for _, tt := range []struct {
+		args string
+		want int
+	}{
+		{"-i in.mp4 out.mp4", 1},
+		{"-y -hide_banner -i in.mp4 -c:v libx264 -b:v 4M out.mp4", 1},
+		{"-i in.mp4 -map 0 -s 1920x1080 a.mp4 -map 0 -s 1280x720 b.mp4 -map 0 -s 640x360 c.mp4", 3},
+		{"-i in.mp4 -f tee [f=mp4]a.mp4|[f=flv]rtmp://live/x", 1},
+		{"-i in.mp4 -an -vn -f null -", 1},
+		{"-i in.mp4 -pass 1 -f null /dev/null -- -i in.mp4 -pass 2 a.mp4 b.mp4", 1},
+		{"-i a.mp4 -i b.mp4 -filter_complex hstack out.mp4", 1},
+		{"-i in.mp4", 0},
+		{"-i in.mp4 -c:v", 0},
+	} {
+		if got := countOutputs(strings.Fields(tt.args)); got != tt.want {
+			t.Errorf("countOutputs(%s) = %d, want %d", tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestDetectOutputs(t *testing.T) {
+	defer func(n int) { targetOutputs = n }(targetOutputs)
+	buf := new(bytes.Buffer)
+	defer log.SetOutput(log.SetOutput(buf))
+
+	targetOutputs = 0
+	detectOutputs(strings.Fields("-i in.mp4 a.mp4 b.mp4"))
+	if targetOutputs != 2 || !strings.Contains(buf.String(), `"source":"argv"`) {
+		t.Errorf("targetOutputs = %d, logged %s; want 2 from argv", targetOutputs, buf)
+	}
+	targetOutputs = 0
+	detectOutputs(strings.Fields("-i in.mp4"))
+	if targetOutputs != 0 {
+		t.Errorf("no outputs set targetOutputs to %d", targetOutputs)
+	}
+}
+
+func TestInjectReconnect(t *testing.T) {
+	defer func(v string) { reconnectmax = v }(reconnectmax)
+	reconnectmax = "30"
+	defer log.SetOutput(log.SetOutput(new(bytes.Buffer)))
+	all := "-reconnect 1 -reconnect_streamed 1 -reconnect_delay_max 30"
+	for _, tt := range []struct{ args, want string }{
+		{"-i in.mp4 out.mp4", "-i in.mp4 out.mp4"},
+		{"-i pipe:0 out.mp4", "-i pipe:0 out.mp4"},
+		{"-i - out.mp4", "-i - out.mp4"},
+		{"-i http://a/in.mp4 out.mp4", all + " -i http://a/in.mp4 out.mp4"},
+		{"-i https://a/in.mp4 out.mp4", all + " -i https://a/in.mp4 out.mp4"},
+		{"-reconnect 1 -reconnect_streamed 1 -reconnect_delay_max 5 -i http://a/in.mp4 out.mp4", "-reconnect 1 -reconnect_streamed 1 -reconnect_delay_max 5 -i http://a/in.mp4 out.mp4"},
+		{"-reconnect 0 -i http://a/in.mp4 out.mp4", "-reconnect 0 -reconnect_streamed 1 -reconnect_delay_max 30 -i http://a/in.mp4 out.mp4"},
+		{"-reconnect_delay_max 5 -i http://a/in.mp4 out.mp4", "-reconnect_delay_max 5 -reconnect 1 -reconnect_streamed 1 -i http://a/in.mp4 out.mp4"},
+		{"-reconnect 1 -i http://a/a.mp4 -i http://a/b.mp4 out.mp4", "-reconnect 1 -reconnect_streamed 1 -reconnect_delay_max 30 -i http://a/a.mp4 " + all + " -i http://a/b.mp4 out.mp4"},
+		{"-i in.mp4 -i https://a/b.mp4 -i pipe:0 out.mp4", "-i in.mp4 " + all + " -i https://a/b.mp4 -i pipe:0 out.mp4"},
+	} {
+		if got := strings.Join(injectReconnect(strings.Fields(tt.args)), " "); got != tt.want {
+			t.Errorf("injectReconnect(%s)\n\t= %s\n\twant %s", tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestInjectNostdin(t *testing.T) {
+	defer func(v string) { nostdin = v }(nostdin)
+	defer log.SetOutput(log.SetOutput(new(bytes.Buffer)))
+	for _, tt := range []struct{ env, args, want string }{
+		{"", "-i in.mp4 out.mp4", "-nostdin -i in.mp4 out.mp4"},
+		{"", "-i http://a/in.mp4 out.mp4", "-nostdin -i http://a/in.mp4 out.mp4"},
+		{"", "-i - out.mp4", "-i - out.mp4"},
+		{"", "-i pipe:0 out.mp4", "-i pipe:0 out.mp4"},
+		{"", "-i in.mp4 -i /dev/stdin out.mp4", "-i in.mp4 -i /dev/stdin out.mp4"},
+		{"", "-nostdin -i in.mp4 out.mp4", "-nostdin -i in.mp4 out.mp4"},
+		{"1", "-i pipe: out.mp4", "-nostdin -i pipe: out.mp4"},
+		{"0", "-i in.mp4 out.mp4", "-i in.mp4 out.mp4"},
+		{"0", "-nostdin -i in.mp4 out.mp4", "-nostdin -i in.mp4 out.mp4"},
+	} {
+		nostdin = tt.env
+		if got := strings.Join(injectNostdin(strings.Fields(tt.args)), " "); got != tt.want {
+			t.Errorf("NOSTDIN=%s injectNostdin(%s) = %s, want %s", tt.env, tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestInjectStatsPeriod(t *testing.T) {
+	defer func(d time.Duration) { logFreq = d }(logFreq)
+	logFreq = 3 * time.Second
+	defer log.SetOutput(log.SetOutput(new(bytes.Buffer)))
+	v44, v43 := Version{Raw: "4.4", Major: 4, Minor: 4}, Version{Raw: "4.3.1", Major: 4, Minor: 3, Patch: 1}
+	for _, tt := range []struct {
+		v          Version
+		args, want string
+	}{
+		{v44, "-i in.mp4 out.mp4", "-stats_period 3 -i in.mp4 out.mp4"},
+		{v44, "-i http://a/in.mp4 out.mp4", "-stats_period 3 -i http://a/in.mp4 out.mp4"},
+		{v44, "-i pipe:0 -f mpegts pipe:1", "-stats_period 3 -i pipe:0 -f mpegts pipe:1"},
+		{v44, "-stats_period 1 -i in.mp4 out.mp4", "-stats_period 1 -i in.mp4 out.mp4"},
+		{v43, "-i in.mp4 out.mp4", "-i in.mp4 out.mp4"},
+		{Version{Raw: "N-110000-gabc123"}, "-i in.mp4 out.mp4", "-stats_period 3 -i in.mp4 out.mp4"},
+		{Version{}, "-i in.mp4 out.mp4", "-i in.mp4 out.mp4"},
+	} {
+		if got := strings.Join(injectStatsPeriod(strings.Fields(tt.args), tt.v), " "); got != tt.want {
+			t.Errorf("%s: injectStatsPeriod(%s) = %s, want %s", tt.v.Raw, tt.args, got, tt.want)
+		}
+	}
+}
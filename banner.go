@@ -0,0 +1,91 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/as/ffmpeg-json/ffmpegjson"
+	"github.com/as/log"
+)
+
+var (
+	sectionRE = regexp.MustCompile(`^(Input|Output) #(\d+)`)
+	streamRE  = regexp.MustCompile(`^\s*Stream #(\d+:\d+)\S*: (Video|Audio|Subtitle|Data|Attachment): (\w+)(.*)`)
+	mapRE     = regexp.MustCompile(`^\s*Stream #(\d+:\d+) -> #(\d+:\d+) \((.*)\)`)
+	resRE     = regexp.MustCompile(`\b(\d{2,5})x(\d{2,5})\b`)
+	fpsRE     = regexp.MustCompile(`([\d.]+k?) fps`)
+	durRE     = regexp.MustCompile(`^\s*Duration: (\d+:\d+:[\d.]+)`)
+	pixfmtRE  = regexp.MustCompile(`, (yuv\w*|yuvj\w*|nv12|nv21|nv16|p010\w*|p016\w*|rgb\w*|bgr\w*|argb|abgr|rgba|bgra|gbrp\w*|gray\w*|cuda|vaapi|qsv|d3d11|videotoolbox_vld)\b`)
+)
+
+// Banner logs the stream list and stream mapping from the ffmpeg banner as
+// structured topic=media lines. Lines it doesn't recognize are skipped, and
+// it stops looking once the first status line arrives.
+type Banner struct {
+	section string
+	mapping []string
+	done    bool
+}
+
+func (b *Banner) Scan(line string) {
+	if b.done {
+		return
+	}
+	if ffmpegjson.IsStatusLine(line) {
+		b.flush()
+		b.done = true
+		return
+	}
+	if m := sectionRE.FindStringSubmatch(line); m != nil {
+		b.section = strings.ToLower(m[1])
+		return
+	}
+	if m := mapRE.FindStringSubmatch(line); m != nil {
+		b.mapping = append(b.mapping, m[1]+" -> "+m[2]+" ("+m[3]+")")
+		return
+	}
+	if m := streamRE.FindStringSubmatch(line); m != nil {
+		b.stream(m[1], strings.ToLower(m[2]), m[3], m[4])
+		return
+	}
+	if m := durRE.FindStringSubmatch(line); m != nil && b.section == "input" {
+		noteInputDuration(Time(m[1]).Duration())
+		return
+	}
+	if !strings.HasPrefix(line, "  ") {
+		b.flush()
+	}
+}
+
+func (b *Banner) stream(index, kind, codec, rest string) {
+	kv := []any{"topic", "media", "action", "stream", "direction", b.section, "index", index, "type", kind, "codec", codec}
+	if m := resRE.FindStringSubmatch(rest); m != nil && kind == "video" {
+		kv = append(kv, "resolution", m[0])
+	}
+	if m := fpsRE.FindStringSubmatch(rest); m != nil {
+		kv = append(kv, "fps", m[1])
+		if kind == "video" && b.section == "output" {
+			noteOutputFPS(m[1])
+		}
+	}
+	if m := pixfmtRE.FindStringSubmatch(rest); m != nil && kind == "video" {
+		kv = append(kv, "pix_fmt", m[1])
+	}
+	log.Info.Add(kv...).Printf("%s stream %s", b.section, index)
+}
+
+// flush logs the stream mapping block once it ends
+func (b *Banner) flush() {
+	if len(b.mapping) == 0 {
+		return
+	}
+	decoders, encoders := []string{}, []string{}
+	for _, m := range b.mapping {
+		if dec, enc, ok := strings.Cut(m[strings.Index(m, "(")+1:len(m)-1], " -> "); ok {
+			decoders = append(decoders, dec)
+			encoders = append(encoders, enc)
+		}
+	}
+	log.Info.Add("topic", "media", "action", "mapping", "mapping", b.mapping, "decoders", decoders, "encoders", encoders).Printf("stream mapping")
+	b.mapping = nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"time"
+
+	"github.com/as/log"
+)
+
+var (
+	// minBitrate and maxBitrate, in bits per second, warn when the
+	// smoothed output bitrate leaves the band. Rates may end in k, M
+	// or G, like ffmpeg's -b:v
+	minBitrate = envRate("MINBITRATE")
+	maxBitrate = envRate("MAXBITRATE")
+
+	// bitrateWarmup is how long after the first frame the band isn't
+	// checked, while rate control settles. default=30s
+	bitrateWarmup = envDur("BITRATE_WARMUP")
+
+	// bitrateAction=kill stops the job when the bitrate leaves the band
+	bitrateAction = os.Getenv("BITRATE_ACTION")
+)
+
+func init() {
+	if bitrateWarmup <= 0 {
+		bitrateWarmup = 30 * time.Second
+	}
+}
+
+// envRate parses a bitrate like 800k or 5M from the environment
+func envRate(name string) float64 {
+	v := strings.TrimSuffix(os.Getenv(name), "bps")
+	if v == "" {
+		return 0
+	}
+	n, ok := siNumber(v, 1000)
+	if !ok || n < 0 {
+		log.Warn.Add("topic", "env", "action", "parse", "env", name, "value", v).Printf("invalid bitrate, ignoring")
+		return 0
+	}
+	return n
+}
+
+// BitrateBand smooths the output bitrate and checks it against
+// MINBITRATE and MAXBITRATE
+type BitrateBand struct {
+	ema    float64
+	start  time.Time
+	warned time.Time
+}
+
+// Check returns low or high when the smoothed bitrate is out of the
+// band, at most once a minute. N/A samples are ignored.
+func (b *BitrateBand) Check(now time.Time, s State) string {
+	if minBitrate == 0 && maxBitrate == 0 || s.Bitrate < 0 || s.Frame == 0 && s.Size == 0 {
+		return ""
+	}
+	if b.start.IsZero() {
+		b.start, b.ema = now, float64(s.Bitrate)
+	}
+	b.ema = 0.7*b.ema + 0.3*float64(s.Bitrate)
+	if now.Sub(b.start) < bitrateWarmup || now.Sub(b.warned) < time.Minute {
+		return ""
+	}
+	switch {
+	case minBitrate > 0 && b.ema < minBitrate:
+		b.warned = now
+		return "low"
+	case maxBitrate > 0 && b.ema > maxBitrate:
+		b.warned = now
+		return "high"
+	}
+	return ""
+}
+
+func (b *BitrateBand) Fields() []any {
+	return []any{"bps_smoothed", int64(b.ema), "min", avail(minBitrate > 0, int64(minBitrate)), "max", avail(maxBitrate > 0, int64(maxBitrate))}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/as/log"
+)
+
+// checksumAlg is md5 or sha256. When set, the output files are hashed
+// after a successful run and listed in the summary
+var checksumAlg = os.Getenv("CHECKSUM")
+
+var hashes = map[string]func() hash.Hash{"md5": md5.New, "sha256": sha256.New}
+
+// checksumOutputs hashes the output files of the final pass. Outputs that
+// aren't files are listed without a hash. SIGINT or SIGTERM stops hashing
+// and the remaining files are listed without one.
+func checksumOutputs(args []string) (files []map[string]any) {
+	newHash, ok := hashes[checksumAlg]
+	if !ok {
+		log.Warn.Add("topic", "checksum", "action", "checksum", "checksum", checksumAlg).Printf("unknown CHECKSUM, skipping")
+		return nil
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	passes := splitPasses(args)
+	a := parseArgv(passes[len(passes)-1])
+	for _, out := range a.Outputs {
+		f := map[string]any{"path": out}
+		files = append(files, f)
+		if !isLocal(out, a.Formats[out]) || !isFile(out) {
+			continue
+		}
+		n, sum, err := hashFile(ctx, out, newHash())
+		f["bytes"] = n
+		if err != nil {
+			log.Warn.Add("topic", "checksum", "action", "checksum", "file", out, "err", err).Printf("checksum failed")
+			continue
+		}
+		f[checksumAlg] = sum
+	}
+	return files
+}
+
+// hashFile streams path through h, logging progress every LOGFREQ
+func hashFile(ctx context.Context, path string, h hash.Hash) (n int64, sum string, err error) {
+	fd, err := os.Open(path)
+	if err != nil {
+		return 0, "", err
+	}
+	defer fd.Close()
+	fi, err := fd.Stat()
+	if err != nil {
+		return 0, "", err
+	}
+	buf := make([]byte, 1<<20)
+	last := time.Now()
+	for {
+		if err = ctx.Err(); err != nil {
+			return n, "", err
+		}
+		m, rerr := fd.Read(buf)
+		h.Write(buf[:m])
+		n += int64(m)
+		if rerr == io.EOF {
+			break
+		}
+		if rerr != nil {
+			return n, "", rerr
+		}
+		if time.Since(last) >= logFreq {
+			last = time.Now()
+			log.Info.Add("topic", "checksum", "action", "checksum", "file", path, "bytes", n, "total_bytes", fi.Size(),
+				"progress", round100(float64(n)*100/float64(fi.Size())),
+			).Printf("hashing")
+		}
+	}
+	return n, hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"os"
+	"strings"
+)
+
+// Exit statuses for the failure classes, after exitBadArg
+const (
+	exitOutput   = 3 // an output couldn't be opened or initialized
+	exitEncoder  = 4 // ffmpeg couldn't pick an encoder for an output
+	exitDisk     = 5 // the output filesystem is full
+	exitBadInput = 6 // an input doesn't exist or can't be read, don't retry
+)
+
+// FailureClass names a known fatal message for the summary's error_class
+// and gives it a wrapper exit status. Zero keeps ffmpeg's exit status.
+// Permanent failures are never retried in place. Match, if set, decides
+// instead of Text.
+type FailureClass struct {
+	Class     string
+	Exit      int
+	Permanent bool
+	Text      []string
+	Match     func(line string) bool
+}
+
+// failureClasses are in priority order. The generic message ffmpeg
+// prints last loses to the specific one that caused it.
+var failureClasses = []FailureClass{
+	{Class: "disk_full", Exit: exitDisk, Permanent: true, Text: []string{"No space left on device", "Error writing trailer"}},
+	{Class: "bad_input", Exit: exitBadInput, Permanent: true, Match: func(line string) bool { return badInput(line) != "" }},
+	{Class: "write_header", Exit: exitOutput, Text: []string{"Could not write header for output file"}},
+	{Class: "output_stream", Exit: exitOutput, Text: []string{"Error initializing output stream"}},
+	{Class: "encoder_selection", Exit: exitEncoder, Text: []string{"Automatic encoder selection failed"}},
+	{Class: "conversion_failed", Text: []string{"Conversion failed!"}},
+}
+
+// classifyLine returns the index in failureClasses that matches line, or -1
+func classifyLine(line string) int {
+	for i, c := range failureClasses {
+		if c.Match != nil && c.Match(line) || c.Match == nil && hastext(line, c.Text...) {
+			return i
+		}
+	}
+	return -1
+}
+
+// badInput returns the input named by a "path: No such file or directory"
+// style line, or the empty string if it's not about one of our inputs.
+// ffmpeg prints the same for outputs, so the path has to match an -i.
+func badInput(line string) string {
+	if !hastext(line, "No such file or directory", "Permission denied", "Protocol not found") {
+		return ""
+	}
+	for _, pass := range splitPasses(os.Args[1:]) {
+		for _, in := range parseArgv(pass).Inputs {
+			// the line was redacted on the way in, see Redactor
+			if strings.HasPrefix(line, redact(in)+": ") {
+				return in
+			}
+		}
+	}
+	return ""
+}
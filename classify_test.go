@@ -0,0 +1,41 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/as/log"
+)
+
+func TestClassify(t *testing.T) {
+	defer func(a []string) { os.Args = a }(os.Args)
+	os.Args = []string{"ffmpeg-json", "-i", "missing.mp4", "-c:v", "libx264", "out/x.mp4"}
+	defer log.SetOutput(log.SetOutput(new(bytes.Buffer)))
+
+	for _, tt := range []struct {
+		name  string
+		lines []string
+		class string
+		exit  int
+		perm  bool
+	}{
+		{"conversion", []string{"Conversion failed!"}, "conversion_failed", 0, false},
+		{"missing stream", []string{"[out#0/mp4 @ 0x1] Error initializing output stream 0:0 -- ", "Conversion failed!"}, "output_stream", exitOutput, false},
+		{"header", []string{"Could not write header for output file #0 (incorrect codec parameters ?): Invalid argument", "Conversion failed!"}, "write_header", exitOutput, false},
+		{"encoder", []string{"Automatic encoder selection failed for output stream #0:1.", "Conversion failed!"}, "encoder_selection", exitEncoder, false},
+		{"disk", []string{"Conversion failed!", "av_interleaved_write_frame(): No space left on device"}, "disk_full", exitDisk, true},
+		{"input", []string{"missing.mp4: No such file or directory"}, "bad_input", exitBadInput, true},
+		{"output dir", []string{"out/x.mp4: No such file or directory"}, "", 0, false},
+		{"nothing", []string{"frame=1 fps=0 size=1kB time=00:00:00.04 bitrate=1.0kbits/s speed=1x"}, "", 0, false},
+	} {
+		d := &Detected{}
+		for _, line := range tt.lines {
+			d.Scan(line)
+		}
+		c, _, ok := d.Failure()
+		if c.Class != tt.class || c.Exit != tt.exit || c.Permanent != tt.perm || ok != (tt.class != "") {
+			t.Errorf("%s: Failure = %+v, %v, want %s exit %d permanent %v", tt.name, c, ok, tt.class, tt.exit, tt.perm)
+		}
+	}
+}
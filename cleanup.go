@@ -0,0 +1,74 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/as/log"
+)
+
+// cleanOnFail deletes (delete) or renames to .partial (rename) the
+// outputs written by a failed run. Files that existed before the
+// first attempt are never touched.
+var cleanOnFail = os.Getenv("CLEAN_ON_FAIL")
+
+// preexistingEnv carries the files that existed before the first
+// attempt across retries, which see the earlier attempt's partial output
+const preexistingEnv = "FFJSON_PREEXISTING"
+
+// setupCleanup records which outputs already exist and registers the
+// cleanup to run at exit if the job failed
+func setupCleanup(args []string) {
+	switch cleanOnFail {
+	case "":
+		return
+	case "delete", "rename":
+	default:
+		log.Warn.Add("topic", "env", "action", "clean_on_fail", "clean_on_fail", cleanOnFail).Printf("unknown CLEAN_ON_FAIL, outputs will be left alone")
+		return
+	}
+	var keep []string
+	if v, ok := os.LookupEnv(preexistingEnv); ok {
+		keep = filepath.SplitList(v)
+	} else {
+		for _, out := range localOutputs(args) {
+			if fi, err := os.Stat(out); err == nil {
+				keep = append(keep, out)
+				log.Info.Add("topic", "cleanup", "action", "preexisting", "file", out, "bytes", fi.Size()).Printf("output exists, it won't be cleaned up")
+			}
+		}
+		os.Setenv(preexistingEnv, strings.Join(keep, string(os.PathListSeparator)))
+	}
+	segments.Lock()
+	segments.opened = map[string]bool{}
+	segments.Unlock()
+	atExit(func() {
+		if outcome != "failed" {
+			return
+		}
+		var opened []string
+		segments.Lock()
+		for f := range segments.opened {
+			opened = append(opened, f)
+		}
+		segments.Unlock()
+		sort.Strings(opened)
+		files := append(localOutputs(args), opened...)
+		for _, f := range files {
+			if hasFlag(keep, f) || templateRE.MatchString(f) || !isFile(f) {
+				continue
+			}
+			ln := log.Warn.Add("topic", "cleanup", "action", cleanOnFail, "file", f)
+			var err error
+			if cleanOnFail == "delete" {
+				err = os.Remove(f)
+			} else {
+				err = os.Rename(f, f+".partial")
+			}
+			ln.Add("err", err).Printf("cleaned up partial output")
+			keep = append(keep, f)
+		}
+	})
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/as/log"
+)
+
+func TestCleanOnFail(t *testing.T) {
+	defer func(c, o string) { cleanOnFail, outcome = c, o }(cleanOnFail, outcome)
+	defer resetSegments()
+	defer log.SetOutput(log.SetOutput(new(bytes.Buffer)))
+	t.Setenv(preexistingEnv, "")
+	os.Unsetenv(preexistingEnv)
+
+	dir := t.TempDir()
+	path := func(name string) string { return filepath.Join(dir, name) }
+	write := func(names ...string) {
+		for _, f := range names {
+			if err := os.WriteFile(path(f), nil, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	write("out.m3u8", "other.ts")
+	resetSegments()
+	cleanOnFail, outcome = "delete", "failed"
+	setupCleanup([]string{"ffmpeg", "-i", "in.mp4", "-f", "hls", path("out.m3u8")})
+	write("seg0.ts", "seg1.ts")
+	for _, f := range []string{"out.m3u8", "seg0.ts", "seg1.ts", "seg1.ts"} {
+		noteSegment(fmt.Sprintf("[hls @ 0x1] Opening '%s' for writing", path(f)))
+	}
+	runExitHooks()
+
+	for f, want := range map[string]bool{"out.m3u8": true, "other.ts": true, "seg0.ts": false, "seg1.ts": false} {
+		if got := isFile(path(f)); got != want {
+			t.Errorf("%s exists %v after cleanup, want %v", f, got, want)
+		}
+	}
+}
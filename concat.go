@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/as/log"
+)
+
+var (
+	// concatJobs is how many concat list entries are probed at once.
+	// default=4
+	concatJobs, _ = strconv.Atoi(os.Getenv("CONCAT_PROBE_JOBS"))
+
+	// concatTimeout caps the time spent probing a concat list. When
+	// it runs out the target duration is left unset. default=30s
+	concatTimeout = envDur("CONCAT_PROBE_TIMEOUT")
+)
+
+// concatStats counts the concat list entries probed for the summary
+var concatStats struct {
+	sync.Mutex
+	entries, probed, skipped int
+}
+
+// ConcatEntry is a file in a concat demuxer list. Times are in seconds,
+// and negative when not given.
+type ConcatEntry struct {
+	File                   string
+	Inpoint, Outpoint, Dur float64
+}
+
+// concatList returns the first input read with -f concat, or ""
+func concatList(args []string) string {
+	a := parseArgv(args)
+	for _, in := range a.Inputs {
+		if a.Formats[in] == "concat" && isLocal(in, "") {
+			return in
+		}
+	}
+	return ""
+}
+
+// parseConcat reads a concat list. Relative paths are relative to the
+// list, the way the demuxer resolves them.
+func parseConcat(list string) (entries []ConcatEntry, err error) {
+	fd, err := os.Open(list)
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+	sc := bufio.NewScanner(fd)
+	for sc.Scan() {
+		line := trim(sc.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		key, val, _ := strings.Cut(line, " ")
+		val = trim(val)
+		if key == "file" {
+			file := unquoteConcat(val)
+			if !filepath.IsAbs(file) && !protoRE.MatchString(file) {
+				file = filepath.Join(filepath.Dir(list), file)
+			}
+			entries = append(entries, ConcatEntry{File: file, Inpoint: -1, Outpoint: -1, Dur: -1})
+			continue
+		}
+		if len(entries) == 0 {
+			continue
+		}
+		e := &entries[len(entries)-1]
+		dur, err := stringDur(val)
+		switch {
+		case err != nil:
+		case key == "inpoint":
+			e.Inpoint = dur.Seconds()
+		case key == "outpoint":
+			e.Outpoint = dur.Seconds()
+		case key == "duration":
+			e.Dur = dur.Seconds()
+		}
+	}
+	return entries, sc.Err()
+}
+
+// unquoteConcat undoes the demuxer's quoting: 'single quotes' and
+// backslash escapes
+func unquoteConcat(v string) string {
+	var b strings.Builder
+	quoted := false
+	for i := 0; i < len(v); i++ {
+		switch c := v[i]; {
+		case c == '\'':
+			quoted = !quoted
+		case c == '\\' && !quoted && i+1 < len(v):
+			i++
+			b.WriteByte(v[i])
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// length returns how much of the entry is played. Entries without an
+// outpoint or duration need the file's duration, probed.
+func (e ConcatEntry) length(probed float64) float64 {
+	in := e.Inpoint
+	if in < 0 {
+		in = 0
+	}
+	switch {
+	case e.Outpoint >= 0:
+		return e.Outpoint - in
+	case e.Dur >= 0:
+		return e.Dur - in
+	}
+	return probed - in
+}
+
+// concatDuration sums the played length of every entry in the list.
+// It returns false if any entry couldn't be probed in time.
+func concatDuration(list string) (float64, bool) {
+	entries, err := parseConcat(list)
+	if err != nil {
+		log.Warn.Add("topic", "probe", "action", "concat", "input", redact(list), "err", err).Printf("cant read concat list")
+		return 0, false
+	}
+	jobs, timeout := concatJobs, concatTimeout
+	if jobs <= 0 {
+		jobs = 4
+	}
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	lengths := make([]float64, len(entries))
+	ok := true
+	var wg sync.WaitGroup
+	sem := make(chan bool, jobs)
+	for i, e := range entries {
+		if e.Outpoint >= 0 || e.Dur >= 0 {
+			lengths[i] = e.length(0)
+			continue
+		}
+		wg.Add(1)
+		go func(i int, e ConcatEntry) {
+			defer wg.Done()
+			select {
+			case sem <- true:
+				defer func() { <-sem }()
+			case <-ctx.Done():
+			}
+			m, err := probeMedia(ctx, e.File)
+			concatStats.Lock()
+			defer concatStats.Unlock()
+			if err != nil || m.Duration <= 0 {
+				ok = false
+				concatStats.skipped++
+				return
+			}
+			concatStats.probed++
+			lengths[i] = e.length(m.Duration)
+		}(i, e)
+	}
+	wg.Wait()
+
+	total := 0.0
+	for _, n := range lengths {
+		total += n
+	}
+	concatStats.Lock()
+	concatStats.entries = len(entries)
+	concatStats.Unlock()
+	return total, ok && total > 0
+}
+
+func concatFields() []any {
+	concatStats.Lock()
+	defer concatStats.Unlock()
+	if concatStats.entries == 0 {
+		return nil
+	}
+	return []any{"concat_entries", concatStats.entries, "concat_probed", concatStats.probed, "concat_skipped", concatStats.skipped}
+}
@@ -0,0 +1,8 @@
+package main
+
+import "syscall"
+
+// setDeathSig kills ffmpeg if we're killed before we can stop it
+func setDeathSig(attr *syscall.SysProcAttr) {
+	attr.Pdeathsig = syscall.SIGKILL
+}
@@ -0,0 +1,8 @@
+//go:build !linux && !windows && !plan9
+
+package main
+
+import "syscall"
+
+// setDeathSig does nothing, only linux has a parent death signal
+func setDeathSig(attr *syscall.SysProcAttr) {}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"os"
+	"strconv"
+	"time"
+)
+
+var (
+	// decryptErrors is how many decode errors in the first decryptWindow
+	// of an encrypted input mean the key is wrong. default=10
+	decryptErrors, _ = strconv.Atoi(os.Getenv("DECRYPT_ERRORS"))
+
+	// decryptWindow is how long after start the decode errors of an
+	// encrypted input are counted. default=10s
+	decryptWindow = envDur("DECRYPT_WINDOW")
+)
+
+// exitDecrypt is the exit status when an encrypted input can't be
+// decrypted. It's permanent, like exitBadInput
+const exitDecrypt = 7
+
+func init() {
+	if decryptErrors <= 0 {
+		decryptErrors = 10
+	}
+	if decryptWindow <= 0 {
+		decryptWindow = 10 * time.Second
+	}
+}
+
+// encrypted reports whether the command passes a decryption key
+func encrypted(args []string) bool {
+	return hasFlag(args, "-decryption_key") || hasFlag(args, "-cenc_decryption_key")
+}
+
+// badKey reports whether an encrypted input is failing to decode early
+// on, which is what a wrong key looks like. With a wrong key ffmpeg
+// decodes garbage, may well exit zero, and produce a full length output.
+func badKey(args []string, det *Detected, elapsed time.Duration) (int, bool) {
+	if elapsed > decryptWindow || !encrypted(args) && !det.Encrypted() {
+		return 0, false
+	}
+	n := det.Counts()["decode"]
+	return n, n >= decryptErrors
+}
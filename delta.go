@@ -0,0 +1,30 @@
+package main
+
+import "time"
+
+// Delta is the change between consecutive status lines, so dashboards
+// don't have to difference the cumulative counters themselves
+type Delta struct {
+	last  State
+	at    time.Time
+	reset bool
+}
+
+// NewDelta starts counting at start. After a retry the first line
+// is reported as a reset, since the counters started over.
+func NewDelta(start time.Time) *Delta {
+	return &Delta{at: start, reset: retry > 0}
+}
+
+// Fields returns the change since the previous call and remembers s.
+// A counter that went backwards reports zeros and reset=true instead
+// of a negative delta.
+func (d *Delta) Fields(s State, now time.Time) []any {
+	reset := d.reset || s.Frame < d.last.Frame || s.Size < d.last.Size
+	df, db, dt := s.Frame-d.last.Frame, s.Size-d.last.Size, now.Sub(d.at).Seconds()
+	if reset {
+		df, db, dt = 0, 0, 0
+	}
+	d.last, d.at, d.reset = s, now, false
+	return []any{"delta_frames", df, "delta_bytes", db, "delta_time", round100(dt), "reset", avail(reset, true)}
+}
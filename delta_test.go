@@ -0,0 +1,39 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDelta(t *testing.T) {
+	start := time.Unix(1000, 0)
+	d := NewDelta(start)
+	for _, tt := range []struct {
+		s    State
+		at   time.Duration // since start
+		want []any
+	}{
+		{State{Frame: 75, Size: 1000}, 3 * time.Second, []any{"delta_frames", 75, "delta_bytes", int64(1000), "delta_time", 3.0, "reset", nil}},
+		{State{Frame: 150, Size: 3000}, 6 * time.Second, []any{"delta_frames", 75, "delta_bytes", int64(2000), "delta_time", 3.0, "reset", nil}},
+		{State{Frame: 150, Size: 3000}, 9 * time.Second, []any{"delta_frames", 0, "delta_bytes", int64(0), "delta_time", 3.0, "reset", nil}},
+		{State{Frame: 10, Size: 100}, 12 * time.Second, []any{"delta_frames", 0, "delta_bytes", int64(0), "delta_time", 0.0, "reset", true}}, // the next pass
+		{State{Frame: 60, Size: 600}, 13500 * time.Millisecond, []any{"delta_frames", 50, "delta_bytes", int64(500), "delta_time", 1.5, "reset", nil}},
+	} {
+		if got := d.Fields(tt.s, start.Add(tt.at)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("at %s: Fields = %v, want %v", tt.at, got, tt.want)
+		}
+	}
+}
+
+func TestDeltaRetry(t *testing.T) {
+	defer func(r int) { retry = r }(retry)
+	retry = 1
+	d := NewDelta(time.Now())
+	if got := d.Fields(State{Frame: 500}, time.Now()); got[7] != true || got[1] != 0 {
+		t.Errorf("first line after a retry: %v, want a reset", got)
+	}
+	if got := d.Fields(State{Frame: 600}, time.Now()); got[7] != nil || got[1] != 100 {
+		t.Errorf("second line after a retry: %v", got)
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/as/log"
+)
+
+// maxErrors bounds the error lines kept for the summary
+const maxErrors = 20
+
+// Detected records the known error conditions seen in ffmpeg's output.
+// The retry logic in main reads the flags once watchState has finished.
+type Detected struct {
+	FilterBug bool // see fixups.go
+	HWFrames  bool // extra_hw_frames too small, see HWFRAMES3
+	VRAM      bool
+	Decode    bool
+	Session   bool // nvenc session limit, see session.go
+	Transient bool // driver hiccup that usually passes, see cudaJob
+	QSV       bool // intel quick sync session or device busy, retryable
+	QSVFatal  bool // quick sync can't do what was asked, not retryable
+
+	mu     sync.Mutex
+	class  int // index+1 in failureClasses, see Failure
+	cline  string
+	crypt  bool // an encrypted hls input, see badKey
+	counts map[string]int
+	first  map[string]string
+	errors []string
+}
+
+// Error records line if it matches one of the errCk patterns. Lines
+// are deduplicated and only the first maxErrors are kept.
+func (d *Detected) Error(line string) {
+	for _, ck := range errCk {
+		if !ck.MatchString(line) {
+			continue
+		}
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		if len(d.errors) >= maxErrors || hasFlag(d.errors, line) {
+			return
+		}
+		d.errors = append(d.errors, line)
+		return
+	}
+}
+
+// Errors returns the error lines recorded so far
+func (d *Detected) Errors() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]string{}, d.errors...)
+}
+
+// Any returns true if a condition that main knows how to retry was detected
+func (d *Detected) Any() bool {
+	return d.FilterBug || d.VRAM || d.HWFrames || d.Session || d.QSV && !d.QSVFatal
+}
+
+// Scan checks line for known error conditions and logs a topic=error
+// event the first time each category is seen
+func (d *Detected) Scan(line string) {
+	if i := classifyLine(line); i >= 0 {
+		d.mu.Lock()
+		if d.class == 0 || i+1 < d.class {
+			d.class, d.cline = i+1, line
+		}
+		d.mu.Unlock()
+	}
+	if hastext(line, "EXT-X-KEY", "'crypto+", "'crypto:") {
+		d.mu.Lock()
+		d.crypt = true
+		d.mu.Unlock()
+	}
+	// NOTE(as): HWFRAMES3
+	// Self-explanitory string check. That's it.
+	switch {
+	case hastext(line, "Impossible to convert between the formats supported by the filter"):
+		d.FilterBug = true
+		d.detected("filter", line)
+	case hastext(line, "No decoder surfaces left"):
+		d.HWFrames = true
+		d.detected("hwframes", line)
+	case sessionLimit(line):
+		d.Session = true
+		d.detected("session_limit", line)
+	case gpuOOM(line):
+		d.VRAM = true
+		d.detected("gpu_oom", line)
+	case hastext(line, "Generic error in an external library", "Failed setup for format cuda"):
+		d.Transient = true
+		d.detected("transient", line)
+	case qsvError(line) && hastext(line, "unsupported", "UNSUPPORTED", "not supported"):
+		d.QSVFatal = true
+		d.detected("qsv_unsupported", line)
+	case qsvError(line):
+		d.QSV = true
+		d.detected("qsv", line)
+	case hastext(line, "Invalid data found when processing input", "error while decoding", "corrupt decoded frame"):
+		d.Decode = true
+		d.detected("decode", line)
+	}
+}
+
+func (d *Detected) detected(category, line string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.counts == nil {
+		d.counts, d.first = map[string]int{}, map[string]string{}
+	}
+	d.counts[category]++
+	if d.counts[category] > 1 {
+		return
+	}
+	d.first[category] = line
+	log.Error.Add("topic", "error", "action", "detected", "category", category, "line", line).Printf("detected %s error", category)
+}
+
+// Line returns the first line seen in category
+func (d *Detected) Line(category string) string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.first[category]
+}
+
+// Failure returns the highest priority failure class seen and the
+// line it was seen on
+func (d *Detected) Failure() (c FailureClass, line string, ok bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.class == 0 {
+		return c, "", false
+	}
+	return failureClasses[d.class-1], d.cline, true
+}
+
+// Encrypted reports whether an input was seen to be encrypted hls
+func (d *Detected) Encrypted() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.crypt
+}
+
+// Counts returns the number of lines seen in each error category
+func (d *Detected) Counts() map[string]int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	c := make(map[string]int, len(d.counts))
+	for k, v := range d.counts {
+		c[k] = v
+	}
+	return c
+}
+
+// qsvError reports whether line is an intel quick sync (mfx) error
+func qsvError(line string) bool {
+	if hastext(line, "Error initializing the MFX", "MFX session", "full surface pool", "MFX_ERR_") {
+		return true
+	}
+	return hastext(line, "qsv", "QSV") && hastext(line, "device failed", "Error", "error")
+}
+
+// cudaJob reports whether the command uses nvidia's libraries. The
+// transient errors are only retryable then: libx265 prints the same
+// "Generic error in an external library" when it aborts for good.
+func cudaJob(args []string) bool {
+	for _, a := range args {
+		if hastext(a, "cuda", "nvenc", "cuvid") {
+			return true
+		}
+	}
+	return false
+}
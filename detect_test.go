@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDetectedErrors(t *testing.T) {
+	d := &Detected{}
+	for _, line := range []string{
+		"frame=1 fps=0 size=1kB time=00:00:00.04 bitrate=1.0kbits/s speed=1x",
+		"in.mp4: No such file or directory",
+		"in.mp4: No such file or directory",
+		"[h264 @ 0x1] error while decoding MB 1 2", // matched only by hastext
+		"Error opening output files: Invalid argument",
+		"Conversion failed!",
+	} {
+		d.Error(line)
+	}
+	want := []string{"in.mp4: No such file or directory", "Error opening output files: Invalid argument", "Conversion failed!"}
+	if got := d.Errors(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Errors = %q, want %q", got, want)
+	}
+
+	d = &Detected{}
+	for i := 0; i < 3*maxErrors; i++ {
+		d.Error(fmt.Sprintf("Error while filtering: %d", i))
+	}
+	if got := d.Errors(); len(got) != maxErrors || got[0] != "Error while filtering: 0" {
+		t.Errorf("kept %d errors starting with %q, want the first %d", len(got), got[0], maxErrors)
+	}
+	d.Errors()[0] = "changed"
+	if d.Errors()[0] == "changed" {
+		t.Errorf("Errors returned its own slice")
+	}
+}
+
+// TestLoopDetectedErrors runs a job that exits 0 with errors on stderr
+// through watchState and the Loop, and checks they reach the log
+func TestLoopDetectedErrors(t *testing.T) {
+	defer func(v bool) { tolerate = v }(tolerate)
+	defer resetOutputs()
+	stderr := strings.Join([]string{
+		"Input #0, mov,mp4,m4a,3gp,3g2,mj2, from 'in.mp4':",
+		"  Duration: 00:00:01.00, start: 0.000000, bitrate: 800 kb/s",
+		"Output #0, mp4, to 'out.mp4':",
+		"frame=   25 fps=25 q=28.0 size=     100kB time=00:00:01.00 bitrate= 800.0kbits/s speed=1x",
+		"in2.mp4: No such file or directory",
+		"Error opening output files: Invalid argument",
+		"Conversion failed!",
+	}, "\n") + "\n"
+	want := []any{"in2.mp4: No such file or directory", "Error opening output files: Invalid argument", "Conversion failed!"}
+
+	for _, tt := range []struct {
+		tolerate bool
+		level    string // of the line with the errors
+		event    string // the last event
+		exit     any
+	}{
+		{true, "warn", "summary/done", nil},
+		{false, "fatal", "summary/failed", fatalExit{}},
+	} {
+		tolerate = tt.tolerate
+		l, ev := testLoop(t)
+		l.fd2.WriteString(stderr)
+		statc := make(chan State, 16)
+		watchState(strings.NewReader(stderr), statc, l.det)
+		var states []State
+		for s := range statc {
+			states = append(states, s)
+		}
+		if exit := runLoop(t, l, nil, 0, states...); exit != tt.exit {
+			t.Fatalf("tolerate=%v: Run exited with %v, want %v", tt.tolerate, exit, tt.exit)
+		}
+		if last, _ := ev.last(); last != tt.event {
+			t.Errorf("tolerate=%v: events %q, want %s last", tt.tolerate, ev.list, tt.event)
+		}
+		var errs any
+		for _, line := range ev.lines {
+			if line["level"] == tt.level && line["errors"] != nil {
+				errs = line["errors"]
+			}
+		}
+		if !reflect.DeepEqual(errs, want) {
+			t.Errorf("tolerate=%v: %s line logged errors %v, want %q", tt.tolerate, tt.level, errs, want)
+		}
+	}
+}
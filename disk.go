@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/as/log"
+)
+
+var (
+	// minFree is the least free space, in bytes, the output filesystem
+	// must have before starting and while running. Sizes may end in
+	// K, M, G or T. When the run drops below it, ffmpeg is stopped
+	// gracefully so the partial output is finalized
+	minFree = envBytes("MINFREE")
+
+	// lowFree warns when free space on an output filesystem drops below
+	// it during the run. default=1G
+	lowFree = envBytes("LOWFREE")
+)
+
+// diskFS reports free space. Tests can replace fsys to simulate
+// a filling disk.
+type diskFS interface {
+	Free(dir string) (uint64, error)
+	Total(dir string) (uint64, error)
+}
+
+var fsys diskFS = statFS{}
+
+// envBytes parses a byte count like 512M or 10G from the environment
+func envBytes(name string) uint64 {
+	v := os.Getenv(name)
+	if v == "" {
+		return 0
+	}
+	n, ok := siNumber(v, 1024)
+	if !ok || n < 0 {
+		log.Warn.Add("topic", "env", "action", "parse", "env", name, "value", v).Printf("invalid size, ignoring")
+		return 0
+	}
+	return uint64(n)
+}
+
+// siNumber parses a number with an optional K, M, G or T suffix in
+// the given base, the way ffmpeg parses -b:v 5M (base 1000)
+func siNumber(v string, base float64) (float64, bool) {
+	mult := 1.0
+	v = strings.TrimSuffix(strings.TrimSuffix(v, "B"), "i")
+	if n := len(v); n > 0 {
+		switch v[n-1] {
+		case 'k', 'K':
+			mult = base
+		case 'm', 'M':
+			mult = base * base
+		case 'g', 'G':
+			mult = base * base * base
+		case 't', 'T':
+			mult = base * base * base * base
+		}
+		if mult != 1 {
+			v = v[:n-1]
+		}
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	return f * mult, err == nil
+}
+
+// outputDirs returns the directories of the local output files
+func outputDirs(args []string) (dirs []string) {
+	for _, out := range localOutputs(args) {
+		if dir := filepath.Dir(out); !hasFlag(dirs, dir) {
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs
+}
+
+// estimateBytes estimates the output size from the requested bitrates
+// and the target duration, or returns zero if either is unknown
+func estimateBytes(args []string, dur float64) uint64 {
+	bps := 0.0
+	for i := 1; i < len(args); i++ {
+		flag := args[i-1]
+		if flag == "-b" || strings.HasPrefix(flag, "-b:") {
+			if n, ok := siNumber(args[i], 1000); ok {
+				bps += n
+			}
+		}
+	}
+	return uint64(bps / 8 * dur)
+}
+
+// checkDisk fails before ffmpeg starts if an output filesystem has less
+// than MINFREE or the estimated output size free
+func checkDisk(args []string) {
+	need := estimateBytes(args, targetDur.Seconds()) * 11 / 10
+	if need < minFree {
+		need = minFree
+	}
+	if need == 0 {
+		return
+	}
+	for _, dir := range outputDirs(args) {
+		free, err := fsys.Free(dir)
+		if err != nil {
+			log.Warn.Add("topic", "disk", "action", "statfs", "dir", dir, "err", err).Printf("cant check free space")
+			continue
+		}
+		if free < need {
+			fatal(log.Fatal.Add("topic", "disk", "action", "nospace", "dir", dir, "free_bytes", free, "need_bytes", need), "not enough free space in %s", dir)
+		}
+	}
+}
+
+// diskFields reports free and total space on each output filesystem
+func diskFields(args []string) (disks []map[string]any) {
+	for _, dir := range outputDirs(args) {
+		free, err := fsys.Free(dir)
+		total, _ := fsys.Total(dir)
+		if err != nil {
+			continue
+		}
+		disks = append(disks, map[string]any{"dir": dir, "free_bytes": free, "total_bytes": total})
+	}
+	return disks
+}
+
+// DiskMonitor checks free space on the output filesystems on every tick
+type DiskMonitor struct {
+	dirs   []string
+	warned map[string]bool
+}
+
+func NewDiskMonitor(args []string) *DiskMonitor {
+	if lowFree == 0 {
+		lowFree = 1 << 30
+	}
+	return &DiskMonitor{dirs: outputDirs(args), warned: map[string]bool{}}
+}
+
+// Check warns once per directory when free space drops below LOWFREE and
+// returns an error when it drops below MINFREE
+func (d *DiskMonitor) Check() error {
+	for _, dir := range d.dirs {
+		free, err := fsys.Free(dir)
+		if err != nil {
+			continue
+		}
+		if minFree > 0 && free < minFree {
+			return fmt.Errorf("%s: %d bytes free, below MINFREE=%d", dir, free, minFree)
+		}
+		if free < lowFree && !d.warned[dir] {
+			d.warned[dir] = true
+			log.Warn.Add("topic", "disk", "action", "lowspace", "dir", dir, "free_bytes", free, "threshold", lowFree).Printf("low disk space in %s", dir)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,13 @@
+package main
+
+import "errors"
+
+type statFS struct{}
+
+func (statFS) Free(dir string) (uint64, error) {
+	return 0, errors.New("free space not supported on this platform")
+}
+
+func (statFS) Total(dir string) (uint64, error) {
+	return 0, errors.New("free space not supported on this platform")
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/as/log"
+)
+
+// shrinkingFS loses step bytes of free space on every Free call
+type shrinkingFS struct {
+	free, total, step uint64
+}
+
+func (f *shrinkingFS) Free(dir string) (uint64, error) {
+	free := f.free
+	if f.free > f.step {
+		f.free -= f.step
+	} else {
+		f.free = 0
+	}
+	return free, nil
+}
+
+func (f *shrinkingFS) Total(dir string) (uint64, error) { return f.total, nil }
+
+func setFS(t *testing.T, fs diskFS, min, low uint64) {
+	restore(t, &fsys)
+	restore(t, &minFree, &lowFree)
+	fsys, minFree, lowFree = fs, min, low
+}
+
+func TestSINumber(t *testing.T) {
+	for _, tt := range []struct {
+		v    string
+		base float64
+		want float64
+		ok   bool
+	}{
+		{"512", 1024, 512, true},
+		{"10G", 1024, 10 << 30, true},
+		{"1.5M", 1024, 3 << 19, true},
+		{"2GiB", 1024, 2 << 30, true},
+		{"1T", 1024, 1 << 40, true},
+		{"5M", 1000, 5e6, true},
+		{"128k", 1000, 128e3, true},
+		{"lots", 1024, 0, false},
+	} {
+		got, ok := siNumber(tt.v, tt.base)
+		if ok != tt.ok || ok && got != tt.want {
+			t.Errorf("siNumber(%q, %v) = %v, %v, want %v, %v", tt.v, tt.base, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestEstimateBytes(t *testing.T) {
+	args := []string{"-i", "in.mp4", "-b:v", "4M", "-b:a", "128k", "out.mp4"}
+	if got := estimateBytes(args, 10); got != (4e6+128e3)/8*10 {
+		t.Errorf("estimateBytes = %d", got)
+	}
+	if got := estimateBytes(args, 0); got != 0 {
+		t.Errorf("estimateBytes without a duration = %d, want 0", got)
+	}
+}
+
+// TestDiskMonitor fills the disk under a running job: one warning at
+// LOWFREE, then an error at MINFREE
+func TestDiskMonitor(t *testing.T) {
+	buf := new(bytes.Buffer)
+	defer log.SetOutput(log.SetOutput(buf))
+	dir := t.TempDir()
+	setFS(t, &shrinkingFS{free: 10 << 20, total: 100 << 20, step: 1 << 20}, 2<<20, 5<<20)
+
+	d := NewDiskMonitor([]string{"-i", "in.mp4", dir + "/out.mp4"})
+	ticks := 0
+	var err error
+	for err == nil && ticks < 100 {
+		ticks++
+		err = d.Check()
+	}
+	// free goes 10M, 9M, ... and is under 2M on the tick that sees 1M
+	if err == nil || ticks != 10 {
+		t.Fatalf("Check failed after %d ticks with %v, want 10 and an error", ticks, err)
+	}
+	if n := strings.Count(buf.String(), `"lowspace"`); n != 1 {
+		t.Errorf("warned %d times, want once:\n%s", n, buf)
+	}
+}
+
+func TestCheckDisk(t *testing.T) {
+	dir := t.TempDir()
+	args := []string{"-i", "in.mp4", dir + "/out.mp4"}
+
+	setFS(t, &shrinkingFS{free: 10 << 20}, 5<<20, 0)
+	checkDisk(args) // enough space
+
+	buf := new(bytes.Buffer)
+	defer log.SetOutput(log.SetOutput(buf))
+	setFS(t, &shrinkingFS{free: 1 << 20}, 5<<20, 0)
+	defer func() {
+		if e := recover(); e != (fatalExit{}) {
+			t.Fatalf("checkDisk with 1M free: recovered %v, want fatalExit", e)
+		}
+		if !strings.Contains(buf.String(), `"nospace"`) {
+			t.Errorf("logged %s, want a nospace line", buf)
+		}
+	}()
+	checkDisk(args)
+}
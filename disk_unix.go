@@ -0,0 +1,23 @@
+//go:build !windows && !plan9
+
+package main
+
+import "syscall"
+
+type statFS struct{}
+
+func (statFS) Free(dir string) (uint64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return 0, err
+	}
+	return uint64(st.Bavail) * uint64(st.Bsize), nil
+}
+
+func (statFS) Total(dir string) (uint64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return 0, err
+	}
+	return uint64(st.Blocks) * uint64(st.Bsize), nil
+}
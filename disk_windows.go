@@ -0,0 +1,31 @@
+package main
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+var getDiskFreeSpaceEx = syscall.NewLazyDLL("kernel32.dll").NewProc("GetDiskFreeSpaceExW")
+
+type statFS struct{}
+
+func (statFS) Free(dir string) (uint64, error) {
+	avail, _, err := diskSpace(dir)
+	return avail, err
+}
+
+func (statFS) Total(dir string) (uint64, error) {
+	_, total, err := diskSpace(dir)
+	return total, err
+}
+
+func diskSpace(dir string) (avail, total uint64, err error) {
+	p, err := syscall.UTF16PtrFromString(dir)
+	if err != nil {
+		return 0, 0, err
+	}
+	if r, _, err := getDiskFreeSpaceEx.Call(uintptr(unsafe.Pointer(p)), uintptr(unsafe.Pointer(&avail)), uintptr(unsafe.Pointer(&total)), 0); r == 0 {
+		return 0, 0, err
+	}
+	return avail, total, nil
+}
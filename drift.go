@@ -0,0 +1,151 @@
+package main
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/as/log"
+)
+
+// maxDrift warns when the output timestamp and the frame count keep
+// disagreeing by more than this, a sign of audio/video drift. default=1s
+var maxDrift = envDur("MAXDRIFT")
+
+func init() {
+	if maxDrift <= 0 {
+		maxDrift = time.Second
+	}
+}
+
+// bannerFPS is the first output video stream's frame rate from the banner
+var bannerFPS struct {
+	sync.Mutex
+	fps float64
+}
+
+func noteOutputFPS(v string) {
+	f, err := strconv.ParseFloat(strings.TrimSuffix(v, "k"), 64)
+	if err != nil || f <= 0 {
+		return
+	}
+	if strings.HasSuffix(v, "k") {
+		f *= 1000
+	}
+	bannerFPS.Lock()
+	if bannerFPS.fps == 0 {
+		bannerFPS.fps = f
+	}
+	bannerFPS.Unlock()
+}
+
+var (
+	// filterFPSRE finds the rate set by the fps and minterpolate filters
+	filterFPSRE = regexp.MustCompile(`\b(?:fps|minterpolate)=(?:fps=)?([\d.]+(?:/[\d.]+)?)`)
+
+	// vfrRE finds filters that change the frame count in ways a rate
+	// can't describe
+	vfrRE = regexp.MustCompile(`\b(select|setpts|mpdecimate|decimate|framestep|tblend|interlace|tinterlace)=`)
+)
+
+// expectedFPS returns the output frame rate frames are counted at. It's
+// false for variable frame rate outputs, where drift isn't meaningful.
+func expectedFPS(args []string) (float64, bool) {
+	if v := flagValue(args, "-vsync") + flagValue(args, "-fps_mode"); hastext(v, "vfr", "passthrough", "drop", "0", "2") {
+		return 0, false
+	}
+	graph := flagValue(args, "-vf") + flagValue(args, "-filter:v") + flagValue(args, "-filter_complex")
+	if vfrRE.MatchString(graph) {
+		return 0, false
+	}
+	if m := filterFPSRE.FindStringSubmatch(graph); m != nil {
+		return rational(m[1])
+	}
+	for _, flag := range []string{"-r", "-r:v"} {
+		if v := flagValue(args, flag); v != "" {
+			return rational(v)
+		}
+	}
+	bannerFPS.Lock()
+	defer bannerFPS.Unlock()
+	return bannerFPS.fps, bannerFPS.fps > 0
+}
+
+// rational parses 30, 29.97 or 30000/1001
+func rational(v string) (float64, bool) {
+	num, den, _ := strings.Cut(v, "/")
+	n, err := strconv.ParseFloat(num, 64)
+	d := 1.0
+	if err == nil && den != "" {
+		d, err = strconv.ParseFloat(den, 64)
+	}
+	if err != nil || n <= 0 || d <= 0 {
+		return 0, false
+	}
+	return n / d, true
+}
+
+// Drift compares the output timestamp with the frame count over the
+// expected frame rate. The offset at the first sample is the baseline,
+// so a stream that starts late doesn't count as drift.
+type Drift struct {
+	args    []string
+	base    time.Duration
+	last    State
+	drift   time.Duration
+	ok      bool
+	history []int64
+	over    int
+	warned  time.Time
+}
+
+func NewDrift(args []string) *Drift {
+	return &Drift{args: args}
+}
+
+// Check updates the drift from s and warns when it has been over
+// maxDrift and growing for three checks
+func (d *Drift) Check(s State) {
+	fps, ok := expectedFPS(d.args)
+	if !ok {
+		d.ok = false
+		return
+	}
+	if s.Frame == 0 || s.Frame == d.last.Frame {
+		return
+	}
+	offset := s.Time.Duration() - time.Duration(float64(s.Frame)/fps*float64(time.Second))
+	if s.Frame < d.last.Frame || !d.ok {
+		d.base, d.history, d.over = offset, nil, 0
+	}
+	d.last, d.ok = s, true
+	prev := d.drift
+	d.drift = offset - d.base
+	if d.history = append(d.history, d.drift.Milliseconds()); len(d.history) > 10 {
+		d.history = d.history[1:]
+	}
+	if abs(d.drift) > maxDrift && abs(d.drift) >= abs(prev) {
+		d.over++
+	} else {
+		d.over = 0
+	}
+	if d.over >= 3 && time.Since(d.warned) > time.Minute {
+		d.warned = time.Now()
+		log.Warn.Add("topic", "status", "action", "alert", "subject", "drift", "details", "output timestamps drifting from the frame count",
+			"drift_ms", d.drift.Milliseconds(), "max_drift", maxDrift.Seconds(), "fps_expected", round100(fps), "drift_history", d.history,
+		).Printf("a/v drift %s", d.drift)
+	}
+}
+
+func (d *Drift) Fields() []any {
+	return []any{"drift_ms", avail(d.ok, d.drift.Milliseconds())}
+}
+
+func abs(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"os"
+	"sync"
+)
+
+// exitStatus is the wrapper's exit status when it exits via fatal
+var exitStatus = 1
+
+// logLine is a line from as/log, which doesn't export the type
+type logLine interface {
+	Printf(f string, v ...interface{})
+}
+
+// fatalExit is the panic value of fatal, which exitTrap turns into an
+// exit with exitStatus
+type fatalExit struct{}
+
+// fatal logs ln, normally a log.Fatal line, and exits through exitTrap.
+// log.Fatal lines panic after they're printed. That panic is replaced
+// with fatalExit, so the exit path doesn't depend on how as/log unwinds.
+func fatal(ln logLine, f string, v ...interface{}) {
+	defer func() {
+		recover()
+		panic(fatalExit{})
+	}()
+	ln.Printf(f, v...)
+}
+
+// setExitStatus maps ffmpeg's exit code or signal onto the wrapper's
+// exit status, using the shell convention of 128+n for signal n
+func setExitStatus(code, sig int) {
+	switch {
+	case sig > 0:
+		exitStatus = 128 + sig
+	case code > 0:
+		exitStatus = code
+	}
+}
+
+// outcome is how the run ended, for the exit hooks: done,
+// failed or retry
+var outcome = "failed"
+
+// errorClass is the error_class of a failed run, for the exit hooks
+var errorClass string
+
+var exitHooks struct {
+	sync.Mutex
+	fn []func()
+}
+
+// atExit registers fn to run before the process exits, whether main
+// returns, fails with fatal, or hands off to a retry
+func atExit(fn func()) {
+	exitHooks.Lock()
+	exitHooks.fn = append(exitHooks.fn, fn)
+	exitHooks.Unlock()
+}
+
+// runExitHooks runs the registered hooks in reverse order, once
+func runExitHooks() {
+	exitHooks.Lock()
+	fn := exitHooks.fn
+	exitHooks.fn = nil
+	exitHooks.Unlock()
+	for i := len(fn) - 1; i >= 0; i-- {
+		fn[i]()
+	}
+}
+
+// exitTrap must be deferred first thing in main. It runs the exit hooks
+// and exits with exitStatus when main fails with fatal.
+func exitTrap() {
+	v := recover()
+	runExitHooks()
+	if v == nil {
+		return
+	}
+	if _, ok := v.(fatalExit); ok {
+		os.Exit(exitStatus)
+	}
+	panic(v)
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"os/exec"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/as/log"
+)
+
+func TestFatal(t *testing.T) {
+	buf := new(bytes.Buffer)
+	defer log.SetOutput(log.SetOutput(buf))
+	for _, ln := range []logLine{log.Fatal.Add("topic", "test"), log.Error.Add("topic", "test").Fatal(), log.Info} {
+		buf.Reset()
+		func() {
+			defer func() {
+				if v := recover(); v != (fatalExit{}) {
+					t.Errorf("fatal panicked with %#v, want fatalExit", v)
+				}
+			}()
+			fatal(ln, "giving up on %d", 42)
+		}()
+		if !strings.Contains(buf.String(), "giving up on 42") {
+			t.Errorf("fatal logged %q, want the message", buf)
+		}
+	}
+}
+
+func TestExitInfo(t *testing.T) {
+	if runtime.GOOS == "windows" || runtime.GOOS == "plan9" {
+		t.Skip("needs /bin/sh and signals")
+	}
+	for _, tt := range []struct {
+		script    string
+		code, sig int
+	}{
+		{"exit 0", -1, -1}, // no error at all
+		{"exit 3", 3, -1},
+		{"kill -9 $$", -1, sigKill},
+		{"kill -15 $$", -1, 15},
+	} {
+		code, sig := exitInfo(exec.Command("sh", "-c", tt.script).Run())
+		if code != tt.code || sig != tt.sig {
+			t.Errorf("%s: exitInfo = %d, %d, want %d, %d", tt.script, code, sig, tt.code, tt.sig)
+		}
+	}
+}
+
+func TestSetExitStatus(t *testing.T) {
+	defer func(v int) { exitStatus = v }(exitStatus)
+	for _, tt := range []struct{ code, sig, want int }{
+		{-1, 9, 137},
+		{-1, 15, 143},
+		{3, -1, 3},
+		{-1, -1, 1},
+	} {
+		exitStatus = 1
+		if setExitStatus(tt.code, tt.sig); exitStatus != tt.want {
+			t.Errorf("setExitStatus(%d, %d) = %d, want %d", tt.code, tt.sig, exitStatus, tt.want)
+		}
+	}
+}
+
+func TestChildStatus(t *testing.T) {
+	if runtime.GOOS == "windows" || runtime.GOOS == "plan9" {
+		t.Skip("needs /bin/sh and signals")
+	}
+	for _, tt := range []struct {
+		script string
+		want   int
+	}{
+		{"exit 0", 0},
+		{"exit 1", 1},
+		{"exit 5", exitDisk},
+		{"exit 6", exitBadInput},
+		{"kill -9 $$", 137},
+		{"kill -15 $$", 143},
+	} {
+		if got := childStatus(exec.Command("sh", "-c", tt.script).Run()); got != tt.want {
+			t.Errorf("%s: childStatus = %d, want %d", tt.script, got, tt.want)
+		}
+	}
+	if got := childStatus(exec.Command("/nonexistent/ffmpeg-json").Run()); got != 1 {
+		t.Errorf("retry that didn't start: childStatus = %d, want 1", got)
+	}
+}
@@ -0,0 +1,25 @@
+//go:build !plan9
+
+package main
+
+import (
+	"errors"
+	"os/exec"
+	"syscall"
+)
+
+// sigKill is SIGKILL as exitInfo reports it
+const sigKill = int(syscall.SIGKILL)
+
+// exitInfo returns the exit code and terminating signal of a finished
+// ffmpeg, or -1 for either when it doesn't apply
+func exitInfo(err error) (code, sig int) {
+	var ee *exec.ExitError
+	if !errors.As(err, &ee) {
+		return -1, -1
+	}
+	if ws, ok := ee.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+		return -1, int(ws.Signal())
+	}
+	return ee.ExitCode(), -1
+}
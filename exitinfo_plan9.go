@@ -0,0 +1,20 @@
+package main
+
+import (
+	"errors"
+	"os/exec"
+)
+
+// sigKill is never reported here, there are notes instead of signals
+const sigKill = 9
+
+// exitInfo returns the exit code of a finished ffmpeg, or -1 when it
+// doesn't apply. A process killed by a note just has an exit message,
+// so the signal is always -1.
+func exitInfo(err error) (code, sig int) {
+	var ee *exec.ExitError
+	if !errors.As(err, &ee) {
+		return -1, -1
+	}
+	return ee.ExitCode(), -1
+}
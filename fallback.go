@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/as/log"
+)
+
+var (
+	// gpuFallback reruns the job once on the cpu after the gpu retries
+	// are exhausted, see cpuFallback
+	gpuFallback = os.Getenv("GPU_FALLBACK") == "1"
+
+	// fallback is cpu in the process that runs the cpu fallback
+	fallback = os.Getenv(fallbackEnv)
+)
+
+const fallbackEnv = "FFJSON_FALLBACK"
+
+// cpuEncoders maps the hardware encoders to software ones
+var cpuEncoders = map[string]string{
+	"h264_nvenc": "libx264", "hevc_nvenc": "libx265",
+	"h264_qsv": "libx264", "hevc_qsv": "libx265",
+	"h264_vaapi": "libx264", "hevc_vaapi": "libx265",
+	"h264_amf": "libx264", "hevc_amf": "libx265",
+}
+
+// hwOptions are dropped along with their values
+var hwOptions = []string{"-hwaccel", "-hwaccel_output_format", "-hwaccel_device", "-extra_hw_frames", "-gpu", "-init_hw_device", "-filter_hw_device"}
+
+// cpuFilters maps the gpu filters to software ones. The upload and
+// download filters become null so any link labels around them still work.
+var cpuFilters = map[string]string{
+	"scale_npp": "scale", "scale_cuda": "scale", "scale_vaapi": "scale", "scale_qsv": "scale",
+	"yadif_cuda": "yadif", "overlay_cuda": "overlay", "thumbnail_cuda": "thumbnail",
+	"hwupload": "null", "hwupload_cuda": "null", "hwdownload": "null",
+}
+
+// x264Presets and x264Tunes are the values libx264 and libx265 accept,
+// nvenc's p1-p7, hq, ll and so on aren't among them
+var (
+	x264Presets = []string{"ultrafast", "superfast", "veryfast", "faster", "fast", "medium", "slow", "slower", "veryslow", "placebo"}
+	x264Tunes   = []string{"film", "animation", "grain", "stillimage", "psnr", "ssim", "fastdecode", "zerolatency"}
+)
+
+var (
+	filterSepRE = regexp.MustCompile(`[,;]`)
+	filterRE    = regexp.MustCompile(`^(\s*(?:\[[^\]]*\]\s*)*)([A-Za-z0-9_]+)(?:=([^\[]*))?((?:\s*\[[^\]]*\])*\s*)$`)
+	gpuRE       = regexp.MustCompile(`_(npp|cuda|cuvid|vaapi|qsv|opencl|vulkan|amf)$`)
+)
+
+// cpuFilterGraph rewrites the gpu filters in a filter graph. It fails on
+// gpu filters it can't translate.
+func cpuFilterGraph(graph string) (string, error) {
+	parts := filterSepRE.Split(graph, -1)
+	seps := filterSepRE.FindAllString(graph, -1)
+	out := &strings.Builder{}
+	for i, p := range parts {
+		m := filterRE.FindStringSubmatch(p)
+		if m != nil {
+			in, name, opts, outl := m[1], m[2], m[3], m[4]
+			if cpu, ok := cpuFilters[name]; ok {
+				switch cpu {
+				case "null":
+					opts = ""
+				case "scale":
+					opts = scaleOpts(opts)
+				}
+				p = in + cpu
+				if opts != "" {
+					p += "=" + opts
+				}
+				p += outl
+			} else if gpuRE.MatchString(name) {
+				return graph, fmt.Errorf("no cpu equivalent for filter %s", name)
+			}
+		}
+		out.WriteString(p)
+		if i < len(seps) {
+			out.WriteString(seps[i])
+		}
+	}
+	return out.String(), nil
+}
+
+// scaleOpts keeps the width and height from a gpu scaler's options
+func scaleOpts(opts string) string {
+	keep := []string{}
+	for i, o := range strings.Split(opts, ":") {
+		k, _, named := strings.Cut(o, "=")
+		if !named && i < 2 || k == "w" || k == "h" || k == "width" || k == "height" {
+			keep = append(keep, o)
+		}
+	}
+	return strings.Join(keep, ":")
+}
+
+// cpuFallback rewrites args to run without a gpu: software encoders
+// with a sane preset, no hwaccel options, and software filters. It
+// returns the changes it made, or an error if it can't translate the
+// command.
+func cpuFallback(args []string) (out []string, changes []string, err error) {
+	for i := 0; i < len(args); i++ {
+		a := args[i]
+		if i+1 < len(args) && (hasFlag(hwOptions, a) || a == "-tune" && !hasFlag(x264Tunes, args[i+1])) {
+			changes = append(changes, "drop "+a+" "+args[i+1])
+			i++
+			continue
+		}
+		out = append(out, a)
+		if i+1 == len(args) {
+			break
+		}
+		v := args[i+1]
+		switch {
+		case a == "-c:v" || a == "-vcodec" || a == "-codec:v" || strings.HasPrefix(a, "-c:v:"):
+			if cpu, ok := cpuEncoders[v]; ok {
+				changes = append(changes, v+" -> "+cpu)
+				v = cpu
+			}
+		case a == "-preset" || strings.HasPrefix(a, "-preset:"):
+			if !hasFlag(x264Presets, v) {
+				changes = append(changes, "preset "+v+" -> medium")
+				v = "medium"
+			}
+		case a == "-vf" || a == "-filter:v" || a == "-filter_complex" || a == "-lavfi":
+			nv, ferr := cpuFilterGraph(v)
+			if ferr != nil {
+				return args, changes, ferr
+			}
+			if nv != v {
+				changes = append(changes, a+" "+v+" -> "+nv)
+			}
+			v = nv
+		default:
+			continue
+		}
+		out = append(out, v)
+		i++
+	}
+	for _, c := range changes {
+		log.Info.Add("topic", "transcode", "action", "rewrite", "fallback", "cpu", "change", c).Printf("cpu fallback")
+	}
+	return out, changes, nil
+}
@@ -0,0 +1,72 @@
+package ffmpegjson
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// The kinds of failure Run reports. Use errors.Is to check for them.
+var (
+	ErrStall    = errors.New("frame count stalled")
+	ErrDup      = errors.New("too many duplicate frames")
+	ErrFilter   = errors.New("impossible to convert between filter formats")
+	ErrHWFrames = errors.New("no decoder surfaces left")
+	ErrGPUOOM   = errors.New("gpu out of memory")
+)
+
+// Error is returned by Run when ffmpeg fails or is stopped
+type Error struct {
+	Kind  error  // one of the Err values, or nil if the failure wasn't recognized
+	Line  string // the stderr line Kind was recognized from
+	State State  // the last state decoded
+	Err   error  // from exec, if ffmpeg exited on its own
+}
+
+func (e *Error) Error() string {
+	switch {
+	case e.Kind == nil:
+		return fmt.Sprintf("ffmpeg: %v", e.Err)
+	case e.Line != "":
+		return fmt.Sprintf("ffmpeg: %v: %s", e.Kind, e.Line)
+	}
+	return fmt.Sprintf("ffmpeg: %v at frame %d", e.Kind, e.State.Frame)
+}
+
+func (e *Error) Is(target error) bool { return e.Kind != nil && target == e.Kind }
+func (e *Error) Unwrap() error        { return e.Err }
+
+// Classify returns the kind of failure line reports, or nil
+func Classify(line string) error {
+	switch {
+	case strings.Contains(line, "Impossible to convert between the formats supported by the filter"):
+		return ErrFilter
+	case strings.Contains(line, "No decoder surfaces left"):
+		return ErrHWFrames
+	case GPUOOM(line):
+		return ErrGPUOOM
+	}
+	return nil
+}
+
+// GPUOOM reports whether line is one of the ways the nvidia, vaapi and
+// amf libraries say the gpu ran out of memory
+func GPUOOM(line string) bool {
+	has := func(s ...string) bool {
+		for _, s := range s {
+			if strings.Contains(line, s) {
+				return true
+			}
+		}
+		return false
+	}
+	switch {
+	case has("nvenc") && has("OpenEncodeSessionEx failed", "out of memory"):
+		return true
+	case has("CUDA_ERROR_OUT_OF_MEMORY", "Failed to initialise VAAPI connection", "AMF failed to initialise"):
+		return true
+	case has("vaapi", "VAAPI") && has("out of memory"):
+		return true
+	}
+	return false
+}
@@ -0,0 +1,70 @@
+package ffmpegjson
+
+import (
+	"sync"
+	"time"
+)
+
+// ProgressFunc gets the latest State and its progress in [0, 1]
+type ProgressFunc func(s State, progress float64)
+
+// CompletionFunc gets the last State and Run's result
+type CompletionFunc func(s State, err error)
+
+// Notifier calls a ProgressFunc on its own goroutine, at most once per
+// interval, with the latest State. States that arrive faster are
+// coalesced the way Handoff does, so Update never blocks.
+type Notifier struct {
+	c    chan State
+	done chan bool
+	once sync.Once
+}
+
+// Notify starts a Notifier. progress maps a State to [0, 1].
+func Notify(interval time.Duration, fn ProgressFunc, progress func(State) float64) *Notifier {
+	n := &Notifier{c: make(chan State, 1), done: make(chan bool)}
+	go n.run(interval, fn, progress)
+	return n
+}
+
+func (n *Notifier) run(interval time.Duration, fn ProgressFunc, progress func(State) float64) {
+	defer close(n.done)
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+	var last State
+	fresh := false
+	for {
+		select {
+		case s, more := <-n.c:
+			if !more {
+				if fresh {
+					fn(last, progress(last))
+				}
+				return
+			}
+			last, fresh = s, true
+		case <-tick.C:
+			if fresh {
+				fn(last, progress(last))
+				fresh = false
+			}
+		}
+	}
+}
+
+// Update hands s to the notifier without blocking
+func (n *Notifier) Update(s State) {
+	if n != nil {
+		Handoff(n.c, s)
+	}
+}
+
+// Close delivers the last pending State, if any, and waits for the
+// ProgressFunc to return
+func (n *Notifier) Close() {
+	if n == nil {
+		return
+	}
+	n.once.Do(func() { close(n.c) })
+	<-n.done
+}
@@ -0,0 +1,93 @@
+package ffmpegjson
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// calls records a Runner's hooks in the order they ran
+type calls struct {
+	sync.Mutex
+	progress []State
+	done     []State
+	order    []string
+}
+
+func (c *calls) onProgress(s State, p float64) {
+	c.Lock()
+	defer c.Unlock()
+	c.progress = append(c.progress, s)
+	c.order = append(c.order, "progress")
+}
+
+func (c *calls) onDone(s State, err error) {
+	c.Lock()
+	defer c.Unlock()
+	c.done = append(c.done, s)
+	c.order = append(c.order, "done")
+}
+
+func TestNotifier(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		updates int
+		want    int
+	}{
+		{"none", 0, 0},
+		{"one", 1, 1},
+		{"coalesced", 100, 1},
+	} {
+		c := &calls{}
+		n := Notify(time.Hour, c.onProgress, func(State) float64 { return 0 })
+		for i := 1; i <= tt.updates; i++ {
+			n.Update(State{Frame: i, N: 1})
+		}
+		n.Close()
+		n.Close()
+		if len(c.progress) != tt.want || tt.want > 0 && c.progress[0].Frame != tt.updates {
+			t.Errorf("%s: %d calls %+v, want %d with the last State", tt.name, len(c.progress), c.progress, tt.want)
+		}
+	}
+	var n *Notifier
+	n.Update(State{})
+	n.Close()
+}
+
+func TestNotifierInterval(t *testing.T) {
+	c := &calls{}
+	n := Notify(time.Millisecond, c.onProgress, func(State) float64 { return 0 })
+	for i := 1; i <= 5; i++ {
+		n.Update(State{Frame: i, N: 1})
+		time.Sleep(20 * time.Millisecond)
+	}
+	n.Close()
+	// a slow scheduler may coalesce some, but never call more often
+	if n := len(c.progress); n < 2 || n > 5 || c.progress[n-1].Frame != 5 {
+		t.Errorf("%d calls for 5 spaced updates, want 2-5 ending with the last: %+v", n, c.progress)
+	}
+}
+
+// TestRunnerHooks counts the hooks against synthetic stderr: OnProgress
+// at most once per Interval, and OnDone once, after it
+func TestRunnerHooks(t *testing.T) {
+	bin := fakeFFmpeg(t, statusLines("1", "2", "3", "4", "5")+"\nLsize=200kB time=00:00:02.00 bitrate=800.0kbits/s speed=1x\n", 0)
+	c := &calls{}
+	r := &Runner{Path: bin, Dur: 4 * time.Second, Interval: time.Hour, OnProgress: c.onProgress, OnDone: c.onDone}
+	if err := r.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(c.progress) != 1 || !c.progress[0].Final || len(c.done) != 1 || c.done[0].Frame != 5 {
+		t.Fatalf("hooks: progress %+v, done %+v; want one of each with the final State", c.progress, c.done)
+	}
+	if c.order[len(c.order)-1] != "done" {
+		t.Errorf("hooks ran %q, want done last", c.order)
+	}
+
+	c = &calls{}
+	r = &Runner{Path: fakeFFmpeg(t, statusLines("5", "5", "5", "5"), 0), MaxStall: 2, OnDone: c.onDone}
+	if err := r.Run(context.Background()); err == nil || len(c.done) != 1 || len(c.progress) != 0 {
+		t.Errorf("stall: Run = %v, hooks progress %d done %d, want OnDone alone", err, len(c.progress), len(c.done))
+	}
+}
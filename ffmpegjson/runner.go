@@ -0,0 +1,154 @@
+package ffmpegjson
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os/exec"
+	"time"
+)
+
+// Runner runs an ffmpeg command once and decodes its progress. The zero
+// value of every field but Args is usable. Retrying is up to the
+// caller, who can tell the gpu failures apart with errors.Is.
+//
+//	states := make(chan ffmpegjson.State, 1)
+//	r := &ffmpegjson.Runner{Args: args, Dur: dur, MaxStall: 1000, States: states}
+//	go func() {
+//		for s := range states {
+//			fmt.Println(r.Progress(s))
+//		}
+//	}()
+//	err := r.Run(ctx)
+//	close(states)
+type Runner struct {
+	Path string   // ffmpeg binary, default=ffmpeg
+	Args []string // ffmpeg's arguments, without the program name
+	Env  []string // ffmpeg's environment, default=ours
+
+	Dur    time.Duration // expected output duration, see Progress
+	Frames int           // expected frame count, if Dur is unknown
+
+	MaxStall int // status lines without a new frame before ErrStall, 0 never, see Stalls
+	MaxDup   int // duplicated frames before ErrDup, 0 never
+
+	States chan State // if set, gets every new State, see Handoff. It must be buffered
+	Stderr io.Writer  // if set, gets a copy of ffmpeg's stderr
+
+	// OnProgress, if set, is called on its own goroutine at most once
+	// per Interval with the latest State. default Interval=3s
+	OnProgress ProgressFunc
+	Interval   time.Duration
+
+	// OnDone, if set, is called with the final State and result after
+	// the last OnProgress call has returned
+	OnDone CompletionFunc
+}
+
+// Progress returns the progress of s towards Dur or Frames in [0, 1]
+func (r *Runner) Progress(s State) float64 {
+	p := s.Progress(r.Dur, r.Frames)
+	if p > 1 {
+		return 1
+	}
+	return p
+}
+
+// Run runs ffmpeg until it exits or ctx is done. Failures are returned
+// as an *Error.
+func (r *Runner) Run(ctx context.Context) error {
+	if r.States != nil && cap(r.States) == 0 {
+		return errors.New("ffmpegjson: States must be buffered")
+	}
+	var notify *Notifier
+	if r.OnProgress != nil {
+		interval := r.Interval
+		if interval <= 0 {
+			interval = 3 * time.Second
+		}
+		notify = Notify(interval, r.OnProgress, r.Progress)
+	}
+	last, err := r.run(ctx, notify)
+	notify.Close()
+	if r.OnDone != nil {
+		r.OnDone(last, err)
+	}
+	return err
+}
+
+func (r *Runner) run(parent context.Context, notify *Notifier) (State, error) {
+	ctx, cancel := context.WithCancel(parent)
+	defer cancel()
+	path := r.Path
+	if path == "" {
+		path = "ffmpeg"
+	}
+	cmd := exec.CommandContext(ctx, path, r.Args...)
+	cmd.Env = r.Env
+	pipe, err := cmd.StderrPipe()
+	if err != nil {
+		return State{}, err
+	}
+	if err = cmd.Start(); err != nil {
+		return State{}, err
+	}
+	var stderr io.Reader = pipe
+	if r.Stderr != nil {
+		stderr = io.TeeReader(pipe, r.Stderr)
+	}
+
+	e := &Error{}
+	nstall := 0
+	sc := NewScanner(stderr)
+	for sc.Scan() {
+		line := sc.Text()
+		if e.Kind == nil {
+			if kind := Classify(line); kind != nil {
+				e.Kind, e.Line = kind, line
+			}
+		}
+		s0 := e.State
+		s1 := s0.Decode(line)
+		s1.N = 1
+		if IsStatusLine(line) {
+			nstall = Stalls(nstall, s0, s1)
+		}
+		switch {
+		case r.MaxStall > 0 && nstall > r.MaxStall && e.Kind == nil:
+			e.Kind = ErrStall
+			cancel()
+		case r.MaxDup > 0 && s1.Dup >= r.MaxDup && e.Kind == nil:
+			e.Kind = ErrDup
+			cancel()
+		}
+		if !s1.Advanced(s0) {
+			continue
+		}
+		e.State = s1
+		if r.States != nil {
+			Handoff(r.States, s1)
+		}
+		notify.Update(s1)
+	}
+	// drain whatever's left so ffmpeg can't block on a full pipe
+	io.Copy(io.Discard, stderr)
+	e.Err = cmd.Wait()
+	if e.Kind == nil && parent.Err() != nil {
+		return e.State, parent.Err()
+	}
+	if e.Kind == ErrStall || e.Kind == ErrDup || e.Err != nil {
+		return e.State, e
+	}
+	return e.State, nil
+}
+
+// Stalls returns the stall count once s follows prior: n plus the
+// updates coalesced into s when the frame count stood still, zero when
+// it moved. A count that went backwards is the next pass, and the final
+// line repeats the last frame, neither is a stall.
+func Stalls(n int, prior, s State) int {
+	if s.Frame == prior.Frame && s.Frame != 0 && !s.Final {
+		return n + s.N
+	}
+	return 0
+}
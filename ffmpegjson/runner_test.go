@@ -0,0 +1,77 @@
+package ffmpegjson
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// fakeFFmpeg writes a script that prints stderr to its stderr and exits
+// with status
+func fakeFFmpeg(t *testing.T, stderr string, status int) string {
+	t.Helper()
+	if runtime.GOOS == "windows" || runtime.GOOS == "plan9" {
+		t.Skip("needs /bin/sh")
+	}
+	dir := t.TempDir()
+	data := filepath.Join(dir, "stderr")
+	os.WriteFile(data, []byte(stderr), 0644)
+	bin := filepath.Join(dir, "ffmpeg")
+	script := "#!/bin/sh\ncat " + data + " >&2\nexit " + strconv.Itoa(status) + "\n"
+	if err := os.WriteFile(bin, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return bin
+}
+
+func statusLines(frames ...string) string {
+	var b strings.Builder
+	for _, f := range frames {
+		b.WriteString("frame=" + f + " fps=25 q=28.0 size=100kB time=00:00:01.00 bitrate=800.0kbits/s speed=1x\r")
+	}
+	return b.String()
+}
+
+func TestRunnerDone(t *testing.T) {
+	bin := fakeFFmpeg(t, statusLines("1", "2", "3")+"\nLsize=200kB time=00:00:02.00 bitrate=800.0kbits/s speed=1x\n", 0)
+	var done State
+	states := make(chan State, 1)
+	r := &Runner{Path: bin, States: states, MaxStall: 1, OnDone: func(s State, err error) { done = s }}
+	if err := r.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if done.Frame != 3 || !done.Final || done.Size != 200<<10 {
+		t.Fatalf("final state: %+v", done)
+	}
+	if s := <-states; s.Frame != 3 {
+		t.Fatalf("last state handed off: %+v", s)
+	}
+}
+
+func TestRunnerErrors(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		stderr string
+		status int
+		r      Runner
+		want   error
+	}{
+		{"stall", statusLines("5", "5", "5", "5"), 0, Runner{MaxStall: 2}, ErrStall},
+		{"dup", "frame=5 fps=25 dup=40 size=1kB\r", 0, Runner{MaxDup: 30}, ErrDup},
+		{"hwframes", "No decoder surfaces left\n", 1, Runner{}, ErrHWFrames},
+		{"oom", "[h264_nvenc @ 0x1] OpenEncodeSessionEx failed: out of memory (10)\n", 1, Runner{}, ErrGPUOOM},
+	} {
+		r := tt.r
+		r.Path = fakeFFmpeg(t, tt.stderr, tt.status)
+		err := r.Run(context.Background())
+		var e *Error
+		if !errors.Is(err, tt.want) || !errors.As(err, &e) {
+			t.Errorf("%s: Run = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
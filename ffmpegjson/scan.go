@@ -0,0 +1,93 @@
+package ffmpegjson
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+
+	"github.com/as/log"
+)
+
+// MaxLine is the longest stderr line NewScanner returns. Longer
+// lines (usually interleaved filter warnings) are skipped.
+const MaxLine = 4 << 20
+
+// NewScanner returns a scanner over ffmpeg's stderr that splits on
+// the bare \r after each status line as well as on newlines
+func NewScanner(r io.Reader) *bufio.Scanner {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), MaxLine)
+	sc.Split((&lineSplitter{max: MaxLine}).Split)
+	return sc
+}
+
+// Handoff sends s on c, which must be buffered. If the consumer hasn't
+// taken the previous State yet, the two are coalesced instead of
+// blocking, so stderr keeps draining no matter how slow the consumer is.
+func Handoff(c chan State, s State) {
+	for {
+		select {
+		case c <- s:
+			return
+		default:
+		}
+		select {
+		case old := <-c:
+			s.N += old.N
+		default:
+		}
+	}
+}
+
+// lineSplitter is a bufio.SplitFunc for ffmpeg's output, where status
+// lines end in a bare \r. Each of \r, \n, and \r\n ends one line, even
+// when the \r\n pair is split across reads.
+type lineSplitter struct {
+	max      int
+	cr, skip bool
+}
+
+// Split consumes skipped bytes together with the line after them.
+// bufio.Scanner gives up at EOF after a split that returns no token,
+// which would drop a last line that follows a \r\n.
+func (l *lineSplitter) Split(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	for {
+		n, token, err := l.split(data[advance:], atEOF)
+		advance += n
+		if token != nil || err != nil || n == 0 || advance == len(data) {
+			return advance, token, err
+		}
+	}
+}
+
+func (l *lineSplitter) split(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if l.cr && len(data) > 0 && data[0] == '\n' {
+		l.cr = false
+		return 1, nil, nil
+	}
+	l.cr = false
+	i := bytes.IndexAny(data, "\r\n")
+	if i < 0 {
+		switch {
+		case len(data) >= l.max:
+			// drop what we have and keep dropping until the line ends
+			// rather than letting the scanner fail with ErrTooLong
+			if !l.skip {
+				log.Warn.Add("topic", "ffmpeg", "action", "skip", "limit", l.max).Printf("skipping oversized stderr line")
+			}
+			l.skip = true
+			return len(data), nil, nil
+		case atEOF && len(data) > 0 && !l.skip:
+			return len(data), data, nil
+		case atEOF:
+			return len(data), nil, nil
+		}
+		return 0, nil, nil
+	}
+	l.cr = data[i] == '\r'
+	if l.skip {
+		l.skip = false
+		return i + 1, nil, nil
+	}
+	return i + 1, data[:i], nil
+}
@@ -0,0 +1,96 @@
+package ffmpegjson
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/as/log"
+)
+
+func scanAll(t *testing.T, r interface{ Read([]byte) (int, error) }) (lines []string) {
+	t.Helper()
+	sc := NewScanner(r)
+	for sc.Scan() {
+		lines = append(lines, sc.Text())
+	}
+	if err := sc.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return lines
+}
+
+func TestScanner(t *testing.T) {
+	for _, tt := range []struct {
+		in   string
+		want []string
+	}{
+		{"frame=1\rframe=2\rframe=3\n", []string{"frame=1", "frame=2", "frame=3"}},
+		{"a\r\nb\r\nc", []string{"a", "b", "c"}},
+		{"a\n\nb\n", []string{"a", "", "b"}},
+		{"frame=1\r\rframe=2", []string{"frame=1", "", "frame=2"}},
+		{"no newline", []string{"no newline"}},
+		{"", nil},
+	} {
+		if got := scanAll(t, strings.NewReader(tt.in)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q: whole reads %q, want %q", tt.in, got, tt.want)
+		}
+		// one byte per read splits every \r\n pair
+		if got := scanAll(t, iotest.OneByteReader(strings.NewReader(tt.in))); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q: 1-byte reads %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestScannerLongLine(t *testing.T) {
+	buf := new(bytes.Buffer)
+	defer log.SetOutput(log.SetOutput(buf))
+	in := "frame=1\r" + strings.Repeat("x", MaxLine+10) + "\nframe=2\r\nframe=3"
+	if got := scanAll(t, strings.NewReader(in)); !reflect.DeepEqual(got, []string{"frame=1", "frame=2", "frame=3"}) {
+		t.Errorf("oversized line: got %d lines, want it skipped", len(got))
+	}
+	if n := strings.Count(buf.String(), "skipping oversized"); n != 1 {
+		t.Errorf("warned %d times, want once", n)
+	}
+}
+
+// TestHandoff is a consumer that's stalled: the producer never blocks and
+// nothing it sent is lost from the count
+func TestHandoff(t *testing.T) {
+	c := make(chan State, 1)
+	const n = 1000
+	for i := 1; i <= n; i++ {
+		Handoff(c, State{Frame: i, N: 1})
+	}
+	s := <-c
+	if s.Frame != n || s.N != n {
+		t.Fatalf("coalesced into %+v, want frame %d and N=%d", s, n, n)
+	}
+	select {
+	case s := <-c:
+		t.Fatalf("left %+v behind", s)
+	default:
+	}
+}
+
+func TestHandoffConcurrent(t *testing.T) {
+	c := make(chan State, 1)
+	const n = 10000
+	done := make(chan int)
+	go func() {
+		total := 0
+		for s := range c {
+			total += s.N
+		}
+		done <- total
+	}()
+	for i := 1; i <= n; i++ {
+		Handoff(c, State{Frame: i, N: 1})
+	}
+	close(c)
+	if total := <-done; total != n {
+		t.Fatalf("consumer counted %d updates, want %d", total, n)
+	}
+}
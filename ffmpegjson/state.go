@@ -0,0 +1,290 @@
+// Package ffmpegjson runs ffmpeg and decodes the status lines it prints
+// to stderr. The ffmpeg-json command is built on it.
+package ffmpegjson
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// State is a carriage-return delimited output line in ffmpeg
+type State struct {
+	Frame   int
+	FPS     int
+	Q       float64 // of the first output stream
+	Qs      [MaxQ]float64
+	NQ      int // q values in Qs, one per output stream
+	Time    Time
+	Size    int64  // bytes, whatever unit ffmpeg printed
+	SizeRaw string // the size as printed, e.g. 10240kB
+	Bitrate int64  // bits per second, -1 when N/A
+	Dup     int
+	Drop    int
+	Speed   float64
+	OutTime time.Duration // out_time_us from -progress, exact
+	Final   bool          // the Lsize line ffmpeg prints when it's done
+
+	N int // number of status updates coalesced into this one
+}
+
+// MaxQ bounds the per-stream q values kept from one status line
+const MaxQ = 4
+
+func (s State) Fields() (kv []any) {
+	var bps any // omitted when N/A
+	if s.Bitrate >= 0 {
+		bps = s.Bitrate
+	}
+	kv = []any{
+		"frame", s.Frame,
+		"runtime", s.Time.Duration().Seconds(),
+		"time", string(s.Time),
+		"time_ms", s.TimeMS(),
+		"size", s.Size,
+		"size_raw", s.SizeRaw,
+		"dup", s.Dup,
+		"drop", s.Drop,
+		"bps", bps,
+		"fps", s.FPS,
+		"speed", fmt.Sprintf("%0.2f", s.Speed),
+		"q", s.Q,
+	}
+	for i := 0; s.NQ > 1 && i < s.NQ; i++ {
+		kv = append(kv, fmt.Sprintf("q%d", i), s.Qs[i])
+	}
+	return kv
+}
+
+// Advanced reports whether s is worth forwarding after s0. The final
+// line always is, even though it may repeat the last frame and size.
+func (s State) Advanced(s0 State) bool {
+	return s.Final || s.Frame > s0.Frame || s.Size > s0.Size || s.OutTime > s0.OutTime
+}
+
+// TimeMS returns the output time in milliseconds. It prefers the
+// -progress out_time_us to the rounded time on the status line.
+func (s State) TimeMS() int64 {
+	if s.OutTime != 0 {
+		return s.OutTime.Milliseconds()
+	}
+	return s.Time.Duration().Milliseconds()
+}
+
+// Progress returns a value between [0, 1] inclusive. Negative
+// timestamps and unknown targets are reported as zero.
+func (s State) Progress(max time.Duration, frames int) (p float64) {
+	switch {
+	case max != 0:
+		p = s.Time.Duration().Seconds() / max.Seconds()
+	case frames != 0:
+		p = float64(s.Frame) / float64(frames)
+	}
+	if p < 0 || math.IsNaN(p) {
+		return 0
+	}
+	return p
+}
+
+// pairRE matches one key=value pair. ffmpeg left-pads numbers, so there
+// may be spaces after the equal sign.
+var pairRE = regexp.MustCompile(`(\w+)=\s*(\S+)`)
+
+// IsStatusLine reports whether line is one of ffmpeg's status lines
+func IsStatusLine(line string) bool {
+	return strings.HasPrefix(line, "frame=") || strings.HasPrefix(line, "size=") || strings.HasPrefix(line, "Lsize=")
+}
+
+// Decode decodes line into a new state and returns it. The line
+// must begin with "frame=" (video) or "size=" (audio, packaging)
+// or "Lsize=", the final line for audio
+// which is what the state line looks like in the ffmpeg output.
+// fps and speed are ffmpeg's, see Totals for the aggregate.
+//
+// Fields that are missing or N/A keep their value from s. The
+// out_time_us lines written by -progress set OutTime.
+func (s State) Decode(line string) State {
+	s, _ = s.DecodeCount(line)
+	return s
+}
+
+// DecodeCount is Decode, and also returns the number of values in line
+// that couldn't be parsed
+func (s State) DecodeCount(line string) (_ State, bad int) {
+	if strings.HasPrefix(line, "out_time_us=") {
+		// ffmpeg -progress writes one key per line
+		us := strings.TrimSpace(strings.TrimPrefix(line, "out_time_us="))
+		if n, err := strconv.ParseInt(us, 10, 64); err == nil {
+			s.OutTime = time.Duration(n) * time.Microsecond
+		}
+		return s, 0
+	}
+	if !IsStatusLine(line) {
+		return s, 0
+	}
+	symtab := map[string]interface{}{
+		"frame": &s.Frame,
+		"fps":   &s.FPS,
+		"time":  &s.Time,
+		"dup":   &s.Dup,
+		"drop":  &s.Drop,
+		"speed": &s.Speed,
+	}
+
+	// the bitrate unit may be separated from the number by a space
+	if m := bitrateRE.FindStringSubmatch(line); m != nil {
+		if bps, ok := parseBitrate(m[1], m[2]); ok {
+			s.Bitrate = bps
+		} else {
+			bad++
+		}
+	}
+
+	// scan each keypair into the symbol table. There's a q for each
+	// output video stream, -1.0 until it starts encoding
+	nq := 0
+	for _, kv := range pairRE.FindAllStringSubmatch(line, -1) {
+		key, val := kv[1], kv[2]
+		if key == "q" {
+			var q float64
+			if _, err := fmt.Sscan(val, &q); err != nil {
+				bad++
+			} else if nq < MaxQ {
+				s.Qs[nq] = q
+				nq++
+			}
+			continue
+		}
+		if key == "Lsize" {
+			s.Final = true
+		}
+		if (key == "size" || key == "Lsize") && val != "N/A" {
+			if n, ok := parseSize(val); ok {
+				s.Size, s.SizeRaw = n, val
+			} else {
+				bad++
+			}
+			continue
+		}
+		dst, ok := symtab[key]
+		if !ok || val == "N/A" {
+			continue
+		}
+		if _, err := fmt.Sscan(val, dst); err != nil {
+			bad++
+		}
+	}
+	if nq > 0 {
+		s.Q, s.NQ = s.Qs[0], nq
+		for i := nq; i < MaxQ; i++ {
+			s.Qs[i] = 0
+		}
+	}
+	return s, bad
+}
+
+// Totals returns the aggregate throughput of a command encoding
+// outputs outputs from one input: fps_total is frames encoded per
+// second across all of them. speed_total is the same for media time.
+// ffmpeg's own fps and speed are per output, and speed is already
+// relative to the wall clock. It's empty for a single output.
+func (s State) Totals(outputs int) []any {
+	if outputs <= 1 {
+		return nil
+	}
+	return []any{
+		"fps_total", s.FPS * outputs,
+		"speed_total", fmt.Sprintf("%0.2f", s.Speed*float64(outputs)),
+	}
+}
+
+var bitrateRE = regexp.MustCompile(`bitrate=\s*(N/A|[\d.]+)\s*([kMG]?bits/s)?`)
+
+// parseBitrate returns the bitrate in bits per second, or -1 for N/A
+func parseBitrate(n, unit string) (int64, bool) {
+	if n == "N/A" {
+		return -1, true
+	}
+	f, err := strconv.ParseFloat(n, 64)
+	if err != nil {
+		return 0, false
+	}
+	switch unit {
+	case "kbits/s", "":
+		f *= 1e3
+	case "Mbits/s":
+		f *= 1e6
+	case "Gbits/s":
+		f *= 1e9
+	}
+	return int64(f), true
+}
+
+var sizeRE = regexp.MustCompile(`^([\d.]+)([KMG]i?B|[kmg]B|B)?$`)
+
+// parseSize parses a size like 1024kB, 2048KiB, or 512B into bytes. A
+// number without a unit is in kB, which is what ffmpeg always printed.
+func parseSize(v string) (int64, bool) {
+	m := sizeRE.FindStringSubmatch(v)
+	if m == nil {
+		return 0, false
+	}
+	n, err := strconv.ParseFloat(m[1], 64)
+	if err != nil {
+		return 0, false
+	}
+	if m[2] == "" {
+		m[2] = "kB"
+	}
+	return UnitBytes(n, m[2]), true
+}
+
+// UnitBytes converts n in the given unit to bytes. ffmpeg has always meant
+// 1024 by "kB" and newer versions print it as "KiB".
+func UnitBytes(n float64, unit string) int64 {
+	switch strings.ToLower(unit) {
+	case "kb", "kib":
+		n *= 1 << 10
+	case "mb", "mib":
+		n *= 1 << 20
+	case "gb", "gib":
+		n *= 1 << 30
+	}
+	return int64(n)
+}
+
+// Time helps us parse ffmpeg log times
+type Time string
+
+// Duration returns t as a duration, or zero if it can't be parsed
+func (t Time) Duration() time.Duration {
+	dur, _ := t.Parse()
+	return dur
+}
+
+// Parse parses [-][[HH:]MM:]SS[.frac], where the sign applies to the
+// whole value. Plain seconds are what the -progress format uses. It
+// returns ok=false for N/A and anything else it doesn't understand.
+func (t Time) Parse() (dur time.Duration, ok bool) {
+	v := strings.TrimSpace(string(t))
+	sign := 1.0
+	if strings.HasPrefix(v, "-") {
+		sign, v = -1, v[1:]
+	}
+	f := strings.Split(v, ":")
+	if v == "" || len(f) > 3 {
+		return 0, false
+	}
+	sec := 0.0
+	for _, part := range f {
+		n, err := strconv.ParseFloat(part, 64)
+		if err != nil || n < 0 {
+			return 0, false
+		}
+		sec = sec*60 + n
+	}
+	return time.Duration(math.Round(sign * sec * float64(time.Second))), true
+}
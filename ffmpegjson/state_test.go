@@ -0,0 +1,326 @@
+package ffmpegjson
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDecode(t *testing.T) {
+	for _, tt := range []struct {
+		line string
+		want State
+		bad  int
+	}{
+		{
+			line: "frame=  240 fps= 48 q=28.0 size=    1024kB time=00:00:10.00 bitrate= 838.9kbits/s dup=1 drop=2 speed=2.01x",
+			want: State{Frame: 240, FPS: 48, Q: 28, Qs: [MaxQ]float64{28}, NQ: 1, Size: 1024 << 10, SizeRaw: "1024kB", Time: "00:00:10.00", Bitrate: 838900, Dup: 1, Drop: 2, Speed: 2.01},
+		},
+		{
+			line: "frame=  100 fps=25 q=-1.0 q=30.0 size=N/A time=00:00:04.00 bitrate=N/A speed=1x",
+			want: State{Frame: 100, FPS: 25, Q: -1, Qs: [MaxQ]float64{-1, 30}, NQ: 2, Time: "00:00:04.00", Bitrate: -1, Speed: 1},
+		},
+		{
+			line: "size=     512KiB time=00:01:00.00 bitrate=  69.9kbits/s speed=30x",
+			want: State{Size: 512 << 10, SizeRaw: "512KiB", Time: "00:01:00.00", Bitrate: 69900, Speed: 30},
+		},
+		{
+			line: "Lsize=    2048kB time=00:00:20.00 bitrate= 838.9kbits/s speed=2x",
+			want: State{Size: 2048 << 10, SizeRaw: "2048kB", Time: "00:00:20.00", Bitrate: 838900, Speed: 2, Final: true},
+		},
+		{
+			line: "out_time_us=1500000",
+			want: State{OutTime: 1500 * time.Millisecond},
+		},
+		{
+			line: "frame=abc fps=25 size=12parsecs",
+			want: State{FPS: 25},
+			bad:  2,
+		},
+		{
+			line: "Stream mapping:",
+		},
+	} {
+		got, bad := State{}.DecodeCount(tt.line)
+		if got != tt.want || bad != tt.bad {
+			t.Errorf("DecodeCount(%q)\n\thave %+v, %d\n\twant %+v, %d", tt.line, got, bad, tt.want, tt.bad)
+		}
+	}
+}
+
+func TestDecodeKeeps(t *testing.T) {
+	s0 := State{}.Decode("frame=  10 fps=5 q=20.0 size=100kB time=00:00:01.00 bitrate=800.0kbits/s speed=1x")
+	s1 := s0.Decode("frame=  11 fps=N/A q=21.0 size=N/A time=00:00:01.04 bitrate=N/A speed=N/A")
+	if s1.FPS != 5 || s1.Size != s0.Size || s1.Speed != 1 || s1.Frame != 11 {
+		t.Fatalf("N/A fields: have %+v, want the values from %+v", s1, s0)
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	for _, tt := range []struct {
+		in   string
+		want int64
+		ok   bool
+	}{
+		{"0kB", 0, true},
+		{"1024kB", 1024 << 10, true},
+		{"1024KiB", 1024 << 10, true},
+		{"3MiB", 3 << 20, true},
+		{"1.5MB", 3 << 19, true},
+		{"7B", 7, true},
+		{"42", 42 << 10, true}, // kB, like ffmpeg always printed
+		{"", 0, false},
+		{"N/A", 0, false},
+		{"12parsecs", 0, false},
+	} {
+		got, ok := parseSize(tt.in)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("parseSize(%q) = %d, %v, want %d, %v", tt.in, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestStalls(t *testing.T) {
+	at := func(frame, n int) State { return State{Frame: frame, N: n} }
+	for _, tt := range []struct {
+		name     string
+		n        int
+		prior, s State
+		want     int
+	}{
+		{"moved", 5, at(10, 1), at(11, 1), 0},
+		{"stood still", 5, at(10, 1), at(10, 1), 6},
+		{"coalesced", 5, at(10, 1), at(10, 3), 8},
+		{"no frames yet", 5, at(0, 1), at(0, 1), 0},
+		{"next pass", 5, at(900, 1), at(3, 1), 0},
+		{"final line", 5, at(10, 1), State{Frame: 10, N: 1, Final: true}, 0},
+	} {
+		if got := Stalls(tt.n, tt.prior, tt.s); got != tt.want {
+			t.Errorf("%s: Stalls = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDecodeMissing(t *testing.T) {
+	prev := State{Frame: 10, FPS: 30, Size: 100 << 10, SizeRaw: "100kB", Bitrate: 800000, Speed: 1.5, Time: "00:00:01.00"}
+	for _, tt := range []struct {
+		line string
+		want State
+	}{
+		{
+			line: "frame=   11 fps=N/A q=-0.0 size=N/A time=N/A bitrate=N/A speed=N/A",
+			want: State{Frame: 11, FPS: 30, Q: -0, Qs: [MaxQ]float64{-0}, NQ: 1, Size: 100 << 10, SizeRaw: "100kB", Bitrate: -1, Speed: 1.5, Time: "00:00:01.00"},
+		},
+		{
+			line: "frame=   12",
+			want: State{Frame: 12, FPS: 30, Size: 100 << 10, SizeRaw: "100kB", Bitrate: 800000, Speed: 1.5, Time: "00:00:01.00"},
+		},
+		{
+			line: "size=     200kB time=00:00:02.00",
+			want: State{Frame: 10, FPS: 30, Size: 200 << 10, SizeRaw: "200kB", Bitrate: 800000, Speed: 1.5, Time: "00:00:02.00"},
+		},
+		{
+			line: "frame= fps= size= speed=",
+			want: prev,
+		},
+	} {
+		if got := prev.Decode(tt.line); got != tt.want {
+			t.Errorf("Decode(%q)\n\thave %+v\n\twant %+v", tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestTimeParse(t *testing.T) {
+	for _, tt := range []struct {
+		t    Time
+		want time.Duration
+		ok   bool
+	}{
+		{"00:00:10.00", 10 * time.Second, true},
+		{"01:02:03.50", time.Hour + 2*time.Minute + 3500*time.Millisecond, true},
+		{"02:03.5", 2*time.Minute + 3500*time.Millisecond, true},
+		{"7.25", 7250 * time.Millisecond, true},
+		{"-00:00:00.08", -80 * time.Millisecond, true},
+		{"-00:00:01.50", -1500 * time.Millisecond, true},
+		{" 00:00:01.00 ", time.Second, true},
+		{"N/A", 0, false},
+		{"", 0, false},
+		{"-", 0, false},
+		{"1:2:3:4", 0, false},
+		{"00:-1:00", 0, false},
+	} {
+		got, ok := tt.t.Parse()
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("Time(%q).Parse() = %v, %v, want %v, %v", tt.t, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestProgressNegative(t *testing.T) {
+	s := State{Time: "-00:00:00.08", Frame: -1}
+	if p := s.Progress(10*time.Second, 0); p != 0 {
+		t.Errorf("Progress of a negative timestamp = %v, want 0", p)
+	}
+	if p := (State{Time: "00:00:05.00"}).Progress(10*time.Second, 0); p != 0.5 {
+		t.Errorf("Progress = %v, want 0.5", p)
+	}
+}
+
+func TestUnitBytes(t *testing.T) {
+	for _, tt := range []struct {
+		n    float64
+		unit string
+		want int64
+	}{
+		{512, "B", 512},
+		{1, "kB", 1 << 10},
+		{1, "KiB", 1 << 10},
+		{1.5, "MiB", 3 << 19},
+		{2, "mB", 2 << 20},
+		{1, "GiB", 1 << 30},
+		{3, "", 3},
+	} {
+		if got := UnitBytes(tt.n, tt.unit); got != tt.want {
+			t.Errorf("UnitBytes(%v, %q) = %d, want %d", tt.n, tt.unit, got, tt.want)
+		}
+	}
+}
+
+func TestDecodeSize(t *testing.T) {
+	for _, tt := range []struct {
+		line string
+		size int64
+		raw  string
+	}{
+		{"frame=1 size=     256kB time=00:00:01.00", 256 << 10, "256kB"},
+		{"frame=1 size=     256KiB time=00:00:01.00", 256 << 10, "256KiB"},
+		{"frame=1 size=       2MiB time=00:00:01.00", 2 << 20, "2MiB"},
+		{"frame=1 size=     100B time=00:00:01.00", 100, "100B"},
+		{"Lsize=       1GiB time=00:10:00.00", 1 << 30, "1GiB"},
+	} {
+		s := State{}.Decode(tt.line)
+		if s.Size != tt.size || s.SizeRaw != tt.raw {
+			t.Errorf("Decode(%q): size %d %q, want %d %q", tt.line, s.Size, s.SizeRaw, tt.size, tt.raw)
+		}
+	}
+}
+
+func TestParseBitrate(t *testing.T) {
+	for _, tt := range []struct {
+		n, unit string
+		want    int64
+		ok      bool
+	}{
+		{"838.9", "kbits/s", 838900, true},
+		{"838.9", "", 838900, true},
+		{"12.5", "Mbits/s", 12500000, true},
+		{"1.2", "Gbits/s", 1200000000, true},
+		{"N/A", "", -1, true},
+		{"1.2.3", "kbits/s", 0, false},
+	} {
+		got, ok := parseBitrate(tt.n, tt.unit)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("parseBitrate(%q, %q) = %d, %v, want %d, %v", tt.n, tt.unit, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestDecodeBitrate(t *testing.T) {
+	for _, tt := range []struct {
+		line string
+		want int64
+	}{
+		{"frame=1 size=1kB time=00:00:01.00 bitrate= 838.9kbits/s speed=1x", 838900},
+		{"frame=1 size=1kB time=00:00:01.00 bitrate=12.5Mbits/s speed=1x", 12500000},
+		{"frame=1 size=1kB time=00:00:01.00 bitrate=12.5 Mbits/s speed=1x", 12500000},
+		{"frame=1 size=1kB time=00:00:01.00 bitrate=N/A speed=1x", -1},
+	} {
+		if got := (State{}).Decode(tt.line).Bitrate; got != tt.want {
+			t.Errorf("Decode(%q): bitrate %d, want %d", tt.line, got, tt.want)
+		}
+	}
+	kv := State{Bitrate: -1}.Fields()
+	for i := 0; i < len(kv); i += 2 {
+		if kv[i] == "bps" && kv[i+1] != nil {
+			t.Errorf("Fields: bps %v for N/A, want nil so it's omitted", kv[i+1])
+		}
+	}
+}
+
+// field returns the value of key in kv, and whether it's there
+func field(kv []any, key string) (any, bool) {
+	for i := 0; i+1 < len(kv); i += 2 {
+		if kv[i] == key {
+			return kv[i+1], true
+		}
+	}
+	return nil, false
+}
+
+func TestTimeFields(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		s       State
+		raw     string
+		ms      int64
+		runtime float64
+	}{
+		{"status line", State{Time: "00:01:02.50"}, "00:01:02.50", 62500, 62.5},
+		{"out_time_us wins", State{Time: "00:01:02.50", OutTime: 62512 * time.Millisecond}, "00:01:02.50", 62512, 62.5},
+		{"negative", State{Time: "-00:00:00.08"}, "-00:00:00.08", -80, -0.08},
+		{"N/A", State{Time: "N/A"}, "N/A", 0, 0},
+	} {
+		kv := tt.s.Fields()
+		raw, _ := field(kv, "time")
+		ms, _ := field(kv, "time_ms")
+		rt, _ := field(kv, "runtime")
+		if raw != tt.raw || ms != tt.ms || rt != tt.runtime {
+			t.Errorf("%s: time %v, time_ms %v, runtime %v, want %q, %d, %v", tt.name, raw, ms, rt, tt.raw, tt.ms, tt.runtime)
+		}
+	}
+	s := State{}.Decode("frame=1 size=1kB time=00:00:01.00 bitrate=8.0kbits/s speed=1x")
+	s = s.Decode("out_time_us=1040000")
+	if s.TimeMS() != 1040 || s.Time != "00:00:01.00" {
+		t.Errorf("after out_time_us: %+v, TimeMS %d", s, s.TimeMS())
+	}
+}
+
+func TestDecodeQ(t *testing.T) {
+	for _, tt := range []struct {
+		line string
+		q    float64
+		qs   [MaxQ]float64
+		nq   int
+		bad  int
+	}{
+		{"frame=1 q=28.0 size=1kB", 28, [MaxQ]float64{28}, 1, 0},
+		{"frame=1 q=-1.0 q=31.0 q=29.5 size=1kB", -1, [MaxQ]float64{-1, 31, 29.5}, 3, 0},
+		{"frame=1 q=1 q=2 q=3 q=4 q=5 q=6 size=1kB", 1, [MaxQ]float64{1, 2, 3, 4}, 4, 0},
+		{"frame=1 q=abc q=30.0 size=1kB", 30, [MaxQ]float64{30}, 1, 1},
+	} {
+		s, bad := State{}.DecodeCount(tt.line)
+		if s.Q != tt.q || s.Qs != tt.qs || s.NQ != tt.nq || bad != tt.bad {
+			t.Errorf("DecodeCount(%q): q %v %v n=%d bad=%d, want %v %v n=%d bad=%d", tt.line, s.Q, s.Qs, s.NQ, bad, tt.q, tt.qs, tt.nq, tt.bad)
+		}
+	}
+
+	// fewer streams on the next line clears the stale ones
+	s := State{}.Decode("frame=1 q=1 q=2 q=3 size=1kB")
+	s = s.Decode("frame=2 q=7 size=1kB")
+	if s.NQ != 1 || s.Qs != [MaxQ]float64{7} {
+		t.Errorf("stale q values: %v n=%d", s.Qs, s.NQ)
+	}
+	// a line without q keeps the last ones
+	if s = s.Decode("frame=3 size=2kB"); s.NQ != 1 || s.Q != 7 {
+		t.Errorf("line without q: %v n=%d", s.Qs, s.NQ)
+	}
+
+	kv := State{}.Decode("frame=1 q=-1.0 q=31.0 size=1kB").Fields()
+	q0, _ := field(kv, "q0")
+	q1, _ := field(kv, "q1")
+	if q0 != -1.0 || q1 != 31.0 {
+		t.Errorf("Fields: q0 %v q1 %v", q0, q1)
+	}
+	if _, ok := field(State{}.Decode("frame=1 q=28.0 size=1kB").Fields(), "q0"); ok {
+		t.Errorf("Fields: q0 for a single stream")
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/as/log"
+)
+
+var (
+	// ffmpegPath is the ffmpeg binary to run, i.e. /opt/ffmpeg-6.1/bin/ffmpeg.
+	// The probes use the ffprobe next to it. default=ffmpeg from PATH
+	ffmpegPath = os.Getenv("FFMPEG_PATH")
+
+	// prefixEnv are global options put before the caller's arguments,
+	// i.e. FFMPEG_PREFIX_ARGS="-hide_banner -loglevel repeat+info". They're
+	// split like a shell would, quotes and backslashes included
+	prefixEnv = os.Getenv("FFMPEG_PREFIX_ARGS")
+
+	// prefixArgs is prefixEnv split into words, see setPrefixArgs
+	prefixArgs []string
+)
+
+func init() {
+	if ffmpegPath == "" {
+		ffmpegPath = "ffmpeg"
+	}
+}
+
+// ffprobePath returns the ffprobe built with ffmpegPath. When FFMPEG_PATH
+// names a file in a directory, that's the ffprobe next to it, i.e.
+// /opt/ffmpeg-6.1/bin/ffprobe, so a custom build isn't paired with the
+// one on PATH.
+func ffprobePath() string {
+	dir, base := filepath.Split(ffmpegPath)
+	if dir == "" {
+		return "ffprobe"
+	}
+	if strings.Contains(base, "ffmpeg") {
+		return dir + strings.Replace(base, "ffmpeg", "ffprobe", 1)
+	}
+	return dir + "ffprobe"
+}
+
+// setPrefixArgs splits FFMPEG_PREFIX_ARGS. It must run in main, where
+// an unbalanced quote can fail the job through fatal.
+func setPrefixArgs() {
+	var err error
+	if prefixArgs, err = shellSplit(prefixEnv); err != nil {
+		exitStatus = exitBadArg
+		fatal(log.Fatal.Add("topic", "env", "action", "badarg", "var", "FFMPEG_PREFIX_ARGS", "err", err), "invalid FFMPEG_PREFIX_ARGS")
+	}
+}
+
+// shellSplit splits s into words like sh does, without expanding
+// anything: single quotes are literal, double quotes allow \" and \\,
+// and a backslash outside quotes escapes the next character.
+func shellSplit(s string) (words []string, err error) {
+	var (
+		w     strings.Builder
+		inw   bool
+		quote byte
+	)
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote == '\'':
+			if c == '\'' {
+				quote = 0
+			} else {
+				w.WriteByte(c)
+			}
+		case quote == '"':
+			switch {
+			case c == '"':
+				quote = 0
+			case c == '\\' && i+1 < len(s) && (s[i+1] == '"' || s[i+1] == '\\'):
+				i++
+				w.WriteByte(s[i])
+			default:
+				w.WriteByte(c)
+			}
+		case c == '\'' || c == '"':
+			quote, inw = c, true
+		case c == '\\':
+			if i+1 == len(s) {
+				return nil, fmt.Errorf("trailing backslash")
+			}
+			i++
+			w.WriteByte(s[i])
+			inw = true
+		case c == ' ' || c == '\t' || c == '\n':
+			if inw {
+				words = append(words, w.String())
+				w.Reset()
+				inw = false
+			}
+		default:
+			w.WriteByte(c)
+			inw = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated %c quote", quote)
+	}
+	if inw {
+		words = append(words, w.String())
+	}
+	return words, nil
+}
+
+// hasPrefixArgs reports whether args already starts with FFMPEG_PREFIX_ARGS
+func hasPrefixArgs(args []string) bool {
+	if len(args) < len(prefixArgs) {
+		return false
+	}
+	for i, a := range prefixArgs {
+		if args[i] != a {
+			return false
+		}
+	}
+	return true
+}
+
+// injectPrefix puts FFMPEG_PREFIX_ARGS first, where ffmpeg takes them as
+// global options. A retry's args already have them.
+func injectPrefix(args []string) []string {
+	if len(prefixArgs) == 0 || hasPrefixArgs(args) {
+		return args
+	}
+	log.Info.Add("topic", "transcode", "action", "rewrite", "prefix", redactArgs(prefixArgs)).Printf("FFMPEG_PREFIX_ARGS")
+	return append(append([]string{}, prefixArgs...), args...)
+}
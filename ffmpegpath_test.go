@@ -0,0 +1,70 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestShellSplit(t *testing.T) {
+	for _, tt := range []struct {
+		in   string
+		want []string
+	}{
+		{"", nil},
+		{"  ", nil},
+		{"-hide_banner", []string{"-hide_banner"}},
+		{"-hide_banner  -loglevel\trepeat+info", []string{"-hide_banner", "-loglevel", "repeat+info"}},
+		{`-metadata "title=a b c"`, []string{"-metadata", "title=a b c"}},
+		{`-metadata 'title=a "b" c'`, []string{"-metadata", `title=a "b" c`}},
+		{`-metadata "say \"hi\" \\ bye"`, []string{"-metadata", `say "hi" \ bye`}},
+		{`-metadata "keep \n as is"`, []string{"-metadata", `keep \n as is`}},
+		{`a\ b c`, []string{"a b", "c"}},
+		{`"" ''`, []string{"", ""}},
+		{`x"y z"'w'`, []string{"xy zw"}},
+		{"'it''s'", []string{"its"}},
+	} {
+		got, err := shellSplit(tt.in)
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("shellSplit(%q) = %q, %v, want %q", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestShellSplitError(t *testing.T) {
+	for _, in := range []string{`-loglevel "info`, `-x 'y`, `trailing\`} {
+		if got, err := shellSplit(in); err == nil {
+			t.Errorf("shellSplit(%q) = %q, want an error", in, got)
+		}
+	}
+}
+
+func TestInjectPrefix(t *testing.T) {
+	defer func(p []string) { prefixArgs = p }(prefixArgs)
+	prefixArgs = []string{"-hide_banner", "-loglevel", "repeat+info"}
+	args := []string{"-i", "in.mp4", "out.mp4"}
+	once := injectPrefix(args)
+	want := append(append([]string{}, prefixArgs...), args...)
+	if !reflect.DeepEqual(once, want) {
+		t.Fatalf("injectPrefix = %q, want %q", once, want)
+	}
+	if twice := injectPrefix(once); !reflect.DeepEqual(twice, want) {
+		t.Fatalf("injectPrefix on a retry = %q, want %q", twice, want)
+	}
+}
+
+func TestFFprobePath(t *testing.T) {
+	defer func(p string) { ffmpegPath = p }(ffmpegPath)
+	for _, tt := range []struct{ ffmpeg, want string }{
+		{"ffmpeg", "ffprobe"},
+		{"/opt/ffmpeg-6.1/bin/ffmpeg", "/opt/ffmpeg-6.1/bin/ffprobe"},
+		{"./ffmpeg", "./ffprobe"},
+		{"/usr/local/bin/ffmpeg7", "/usr/local/bin/ffprobe7"},
+		{"/opt/bin/transcoder", "/opt/bin/ffprobe"},
+	} {
+		ffmpegPath = tt.ffmpeg
+		if got := ffprobePath(); got != filepath.FromSlash(tt.want) {
+			t.Errorf("FFMPEG_PATH=%s: ffprobePath = %s, want %s", tt.ffmpeg, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"regexp"
+
+	"github.com/as/log"
+)
+
+// filterFixups, if set, is a json file with a list of fixups applied
+// after the default ones
+var filterFixups = os.Getenv("FILTER_FIXUPS")
+
+// Fixup rewrites filter graphs that trip the gpu filter bug (see
+// detect.go:/filter/). Replace may refer to Match's groups as $1.
+//
+//	{"match":"format=nv12,hwupload,scale_npp=","replace":"scale_npp="}
+type Fixup struct {
+	Match   string   `json:"match"`
+	Replace string   `json:"replace"`
+	Flags   []string `json:"flags,omitempty"` // default: filterFlags
+
+	re *regexp.Regexp
+}
+
+var filterFlags = []string{"-vf", "-filter:v", "-filter_complex"}
+
+var defaultFixups = []Fixup{
+	{Match: `format=(?:nv12|yuv420p|p010le|p010),hwupload(?:_cuda)?,(scale_npp|scale_cuda)=`, Replace: `$1=`},
+}
+
+// loadFixups compiles the default fixups and those in FILTER_FIXUPS.
+// A bad file is fatal, like a bad ARGREWRITE file.
+func loadFixups() []Fixup {
+	fixups := append([]Fixup{}, defaultFixups...)
+	if filterFixups != "" {
+		data, err := os.ReadFile(filterFixups)
+		if err == nil {
+			var extra []Fixup
+			err = json.Unmarshal(data, &extra)
+			fixups = append(fixups, extra...)
+		}
+		if err != nil {
+			fatal(log.Fatal.Add("topic", "transcode", "action", "badarg", "file", filterFixups, "err", err), "cant load filter fixups")
+		}
+	}
+	for i := range fixups {
+		re, err := regexp.Compile(fixups[i].Match)
+		if err != nil {
+			fatal(log.Fatal.Add("topic", "transcode", "action", "badarg", "file", filterFixups, "fixup", i, "err", err), "invalid filter fixup")
+		}
+		fixups[i].re = re
+		if len(fixups[i].Flags) == 0 {
+			fixups[i].Flags = filterFlags
+		}
+	}
+	return fixups
+}
+
+// fixups are loaded at startup with the rewrite rules
+var fixups []Fixup
+
+// fixFilters applies the filter fixups and the ARGREWRITE rules for
+// the filterbug condition, and reports whether args changed
+func fixFilters(args []string) bool {
+	fixed := applyFixups(args)
+	return rewrite(args, "filterbug") || fixed
+}
+
+// applyFixups rewrites the filter graphs in args in place and reports
+// whether any of them actually changed. Retrying when nothing changed
+// would only fail the same way again.
+func applyFixups(args []string) (changed bool) {
+	for _, f := range fixups {
+		for i := 1; i < len(args); i++ {
+			if !hasFlag(f.Flags, args[i-1]) {
+				continue
+			}
+			before := args[i]
+			after := f.re.ReplaceAllString(before, f.Replace)
+			if after == before {
+				continue
+			}
+			args[i] = after
+			changed = true
+			log.Info.Add("topic", "transcode", "action", "rewrite", "flag", args[i-1], "when", "filterbug", "fixup", f.Match, "before", before, "after", after).Printf("applied filter fixup")
+		}
+	}
+	return changed
+}
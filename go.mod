@@ -2,4 +2,4 @@ module github.com/as/ffmpeg-json
 
 go 1.18
 
-require github.com/as/log v0.0.7 // indirect
+require github.com/as/log v0.0.7
@@ -0,0 +1,491 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/as/ffmpeg-json/ffmpegjson"
+	"github.com/as/log"
+)
+
+type GPU struct {
+	N                       int
+	Vendor                  string // nvidia or amd
+	Name, PCI, Driver, UUID string
+	Used, Total             int    // MiB
+	Util                    int    // percent, -1 if unknown
+	Sessions                int    // nvenc sessions, -1 if unknown
+	Temp                    int    // celsius, -1 if unknown
+	SMClock                 int    // MHz, -1 if unknown
+	Throttle                uint64 // clocks_throttle_reasons.active bitmask
+}
+
+func (g GPU) Load() float64 {
+	return float64(g.Used) / float64(g.Total)
+}
+
+// gpuQuery are the nvidia-smi fields parsed by queryGPU, in order
+const gpuQuery = "index,memory.used,memory.total,name,pci.bus_id,driver_version,uuid,utilization.gpu,encoder.stats.sessionCount," +
+	"temperature.gpu,clocks.sm,clocks_throttle_reasons.active"
+
+// gpuCmd runs a gpu query tool and returns its standard output. Tests
+// replace it with a fake.
+var gpuCmd = func(ctx context.Context, name string, args ...string) ([]byte, error) {
+	return exec.CommandContext(ctx, name, args...).Output()
+}
+
+const (
+	// gpuTimeout bounds nvidia-smi, which hangs when the driver is wedged
+	gpuTimeout = 2 * time.Second
+
+	// gpuTTL is how long a query result is reused. gpuOOM can match
+	// many lines in a row and shouldn't run nvidia-smi for each one.
+	gpuTTL = 2 * time.Second
+)
+
+var gpuCache struct {
+	sync.Mutex
+	at     time.Time
+	list   []GPU
+	vendor string // whose tool succeeded last, and is tried first
+	warn   sync.Once
+}
+
+// queryGPU returns the gpus sorted by memory load, least loaded first,
+// or nil if no query tool is installed or they all fail. Results are
+// cached for gpuTTL.
+func queryGPU() (list []GPU) {
+	if !caps.NVIDIA && caps.AMD == "" {
+		return nil
+	}
+	gpuCache.Lock()
+	defer gpuCache.Unlock()
+	if time.Since(gpuCache.at) < gpuTTL {
+		return append([]GPU{}, gpuCache.list...)
+	}
+	list, err := queryVendors()
+	gpuCache.at = time.Now()
+	if err != nil {
+		gpuCache.warn.Do(func() {
+			log.Warn.Add("topic", "gpu", "action", "query", "err", err, "timeout", gpuTimeout.Seconds()).Printf("gpu query failed, gpu stats unavailable")
+		})
+		gpuCache.list = nil
+		return nil
+	}
+	sort.SliceStable(list, func(i, j int) bool {
+		return list[i].Load() < list[j].Load()
+	})
+	gpuCache.list = list
+	return append([]GPU{}, list...)
+}
+
+// lastVendor returns the vendor of the last successful gpu query
+func lastVendor() string {
+	gpuCache.Lock()
+	defer gpuCache.Unlock()
+	return gpuCache.vendor
+}
+
+// queryVendors runs the installed query tools, starting with the one
+// that worked last time, and returns the first result
+func queryVendors() (list []GPU, err error) {
+	vendors := []string{"nvidia", "amd"}
+	if gpuCache.vendor == "amd" {
+		vendors = []string{"amd", "nvidia"}
+	}
+	for _, v := range vendors {
+		var out []byte
+		ctx, cancel := context.WithTimeout(context.Background(), gpuTimeout)
+		switch {
+		case v == "nvidia" && caps.NVIDIA:
+			out, err = gpuCmd(ctx, "nvidia-smi", "--query-gpu="+gpuQuery, "--format=csv,noheader,nounits")
+			list = parseGPUs(out)
+		case v == "amd" && caps.AMD == "rocm-smi":
+			out, err = gpuCmd(ctx, "rocm-smi", "--showmeminfo", "vram", "--showuse", "--showtemp", "--showproductname", "--showbus", "--json")
+			list = parseROCm(out)
+		case v == "amd" && caps.AMD == "amd-smi":
+			out, err = gpuCmd(ctx, "amd-smi", "metric", "--mem-usage", "--usage", "--temperature", "--json")
+			list = parseAMDSMI(out)
+		default:
+			cancel()
+			continue
+		}
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		cancel()
+		if err == nil && len(list) > 0 {
+			gpuCache.vendor = v
+			return list, nil
+		}
+		if err == nil {
+			err = fmt.Errorf("%s: no gpus in output", v)
+		}
+	}
+	return nil, err
+}
+
+// parseGPUs parses nvidia-smi's csv output for gpuQuery
+func parseGPUs(out []byte) (list []GPU) {
+	num := func(s string) int {
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return -1
+		}
+		return n
+	}
+	sc := bufio.NewScanner(bytes.NewReader(out))
+	for sc.Scan() {
+		f := strings.Split(sc.Text(), ",")
+		if len(f) < 12 {
+			continue
+		}
+		for i := range f {
+			f[i] = strings.TrimSpace(f[i])
+		}
+		list = append(list, GPU{
+			N: num(f[0]), Vendor: "nvidia", Used: num(f[1]), Total: num(f[2]),
+			Name: f[3], PCI: f[4], Driver: f[5], UUID: f[6],
+			Util: num(f[7]), Sessions: num(f[8]),
+			Temp: num(f[9]), SMClock: num(f[10]),
+		})
+		list[len(list)-1].Throttle, _ = strconv.ParseUint(strings.TrimPrefix(f[11], "0x"), 16, 64)
+	}
+	return list
+}
+
+// parseROCm parses rocm-smi --json, which maps each card to its fields
+// with the values as strings
+func parseROCm(out []byte) (list []GPU) {
+	var cards map[string]map[string]string
+	if json.Unmarshal(out, &cards) != nil {
+		return nil
+	}
+	for card, f := range cards {
+		n, err := strconv.Atoi(strings.TrimPrefix(card, "card"))
+		if err != nil {
+			continue
+		}
+		g := GPU{N: n, Vendor: "amd", Used: -1, Total: -1, Util: -1, Sessions: -1, Temp: -1, SMClock: -1}
+		for k, v := range f {
+			num, _ := strconv.ParseFloat(v, 64)
+			switch {
+			case strings.HasPrefix(k, "VRAM Total Used Memory"):
+				g.Used = int(num / (1 << 20))
+			case strings.HasPrefix(k, "VRAM Total Memory"):
+				g.Total = int(num / (1 << 20))
+			case strings.HasPrefix(k, "GPU use"):
+				g.Util = int(num)
+			case strings.HasPrefix(k, "Temperature") && strings.Contains(k, "edge"):
+				g.Temp = int(num)
+			case k == "Card series" || k == "Card Series":
+				g.Name = v
+			case strings.HasPrefix(k, "PCI Bus"):
+				g.PCI = v
+			}
+		}
+		list = append(list, g)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].N < list[j].N })
+	return list
+}
+
+// parseAMDSMI parses amd-smi metric --json, a list of gpus with
+// {value, unit} pairs
+func parseAMDSMI(out []byte) (list []GPU) {
+	type value struct {
+		Value any    `json:"value"`
+		Unit  string `json:"unit"`
+	}
+	var gpus []struct {
+		GPU   int `json:"gpu"`
+		Usage struct {
+			GFX value `json:"gfx_activity"`
+		} `json:"usage"`
+		Mem struct {
+			Total value `json:"total_vram"`
+			Used  value `json:"used_vram"`
+		} `json:"mem_usage"`
+		Temp struct {
+			Edge value `json:"edge"`
+		} `json:"temperature"`
+	}
+	if json.Unmarshal(out, &gpus) != nil {
+		return nil
+	}
+	num := func(v value) int {
+		f, ok := v.Value.(float64)
+		if !ok {
+			return -1
+		}
+		return int(f)
+	}
+	for _, g := range gpus {
+		list = append(list, GPU{
+			N: g.GPU, Vendor: "amd", Used: num(g.Mem.Used), Total: num(g.Mem.Total),
+			Util: num(g.Usage.GFX), Sessions: -1, Temp: num(g.Temp.Edge), SMClock: -1,
+		})
+	}
+	return list
+}
+
+func gpuOOM(s string) (oom bool) {
+	defer func() {
+		if oom {
+			for _, g := range queryGPU() {
+				log.Warn.Add(
+					"vendor", g.Vendor,
+					"gpu_num", g.N,
+					"gpu_mem_used", g.Used,
+					"gpu_mem_total", g.Total,
+					"gpu_name", g.Name,
+					"gpu_pci", g.PCI,
+					"gpu_driver", g.Driver,
+				).Printf("ffmpeg-json: gpu out of memory condition")
+			}
+		}
+	}()
+	if ffmpegjson.GPUOOM(s) {
+		return true
+	}
+	if hastext(s, "CUDA_ERROR_NO_DEVICE") && len(queryGPU()) != 0 {
+		return true
+	}
+	return false
+}
+
+// gpuDevice returns the index or uuid of the device ffmpeg uses:
+// -hwaccel_device or -gpu, mapped through CUDA_VISIBLE_DEVICES. A vaapi
+// render node is mapped to its card number.
+func gpuDevice(args []string) string {
+	dev := flagValue(args, "-hwaccel_device")
+	if dev == "" {
+		dev = flagValue(args, "-gpu")
+	}
+	if n, err := strconv.Atoi(strings.TrimPrefix(dev, "/dev/dri/renderD")); err == nil && n >= 128 {
+		return strconv.Itoa(n - 128) // vaapi render nodes start at 128
+	}
+	n, err := strconv.Atoi(dev)
+	if dev == "" || n < 0 {
+		n, err = 0, nil
+	}
+	if err != nil {
+		return dev
+	}
+	if v := os.Getenv("CUDA_VISIBLE_DEVICES"); v != "" {
+		if vis := strings.Split(v, ","); n < len(vis) {
+			return strings.TrimSpace(vis[n])
+		}
+	}
+	return strconv.Itoa(n)
+}
+
+var (
+	// gpuTempWarn raises the throttle alert at this temperature in
+	// celsius even if the driver isn't throttling yet. default=85
+	gpuTempWarn, _ = strconv.Atoi(os.Getenv("GPU_TEMP_WARN"))
+
+	// throttleEvery is the minimum time between throttle alerts
+	throttleEvery = 5 * time.Minute
+)
+
+// throttleReasons names the clocks_throttle_reasons bits. Idle and the
+// application clock setting are normal and not reported.
+var throttleReasons = []struct {
+	bit  uint64
+	name string
+}{
+	{0x4, "sw_power_cap"},
+	{0x8, "hw_slowdown"},
+	{0x10, "sync_boost"},
+	{0x20, "sw_thermal"},
+	{0x40, "hw_thermal"},
+	{0x80, "hw_power_brake"},
+	{0x100, "display_clocks"},
+}
+
+// Throttled returns the active throttle reasons
+func (g GPU) Throttled() (reasons []string) {
+	for _, r := range throttleReasons {
+		if g.Throttle&r.bit != 0 {
+			reasons = append(reasons, r.name)
+		}
+	}
+	return reasons
+}
+
+// findGPU returns the gpu in list with the index or uuid dev
+func findGPU(list []GPU, dev string) (GPU, bool) {
+	for _, g := range list {
+		if strconv.Itoa(g.N) == dev || g.UUID == dev {
+			return g, true
+		}
+	}
+	return GPU{}, false
+}
+
+// gpuHistory is how many samples GPUSampler keeps for the OOM alert
+const gpuHistory = 20
+
+// GPUSampler polls nvidia-smi once per LOGFREQ for the device in use.
+// A failed poll leaves the fields out rather than reporting zeros.
+type GPUSampler struct {
+	dev       string
+	mu        sync.Mutex
+	last      *GPU
+	history   []GPU
+	alerted   time.Time
+	throttled bool
+
+	// run totals for the summary
+	info        GPU
+	peak        int
+	util, nutil int
+}
+
+// startGPUSampler returns nil for jobs that don't use an nvidia gpu
+func startGPUSampler(ctx context.Context, args []string) *GPUSampler {
+	if !usesGPU(args) {
+		return nil
+	}
+	if !caps.NVIDIA && caps.AMD == "" {
+		log.Warn.Add("topic", "gpu", "action", "query", "err", "no nvidia-smi, rocm-smi or amd-smi on PATH").Printf("gpu stats unavailable")
+		return nil
+	}
+	s := &GPUSampler{dev: gpuDevice(args)}
+	go func() {
+		tick := time.NewTicker(logFreq)
+		defer tick.Stop()
+		for {
+			s.sample()
+			select {
+			case <-ctx.Done():
+				return
+			case <-tick.C:
+			}
+		}
+	}()
+	return s
+}
+
+func (s *GPUSampler) sample() {
+	var g *GPU
+	if v, ok := findGPU(queryGPU(), s.dev); ok {
+		g = &v
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.last = g
+	if g == nil {
+		return
+	}
+	s.history = append(s.history, *g)
+	if len(s.history) > gpuHistory {
+		s.history = s.history[1:]
+	}
+	s.info = *g
+	if g.Used > s.peak {
+		s.peak = g.Used
+	}
+	if g.Util >= 0 {
+		s.util += g.Util
+		s.nutil++
+	}
+	if gpuTempWarn == 0 {
+		gpuTempWarn = 85
+	}
+	reasons := g.Throttled()
+	if len(reasons) == 0 && g.Temp < gpuTempWarn {
+		return
+	}
+	s.throttled = true
+	if time.Since(s.alerted) < throttleEvery {
+		return
+	}
+	s.alerted = time.Now()
+	log.Warn.Add("topic", "gpu", "action", "alert", "subject", "throttle", "vendor", g.Vendor, "gpu_num", g.N, "gpu_temp", avail(g.Temp >= 0, g.Temp),
+		"gpu_sm_clock", avail(g.SMClock >= 0, g.SMClock), "reasons", avail(len(reasons) > 0, reasons), "temp_warn", gpuTempWarn,
+	).Printf("gpu %d is throttling", g.N)
+}
+
+// Throttled returns true if the gpu throttled at any point in the run
+func (s *GPUSampler) Throttled() bool {
+	if s == nil {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.throttled
+}
+
+// Fields returns the latest sample. It's a no-op on a nil GPUSampler.
+func (s *GPUSampler) Fields() []any {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	g := s.last
+	if g == nil {
+		return nil
+	}
+	return []any{
+		"vendor", g.Vendor,
+		"gpu_num", g.N,
+		"gpu_mem_used", avail(g.Used >= 0, g.Used),
+		"gpu_mem_total", avail(g.Total >= 0, g.Total),
+		"gpu_util", avail(g.Util >= 0, g.Util),
+		"encoder_sessions", avail(g.Sessions >= 0, g.Sessions),
+		"gpu_temp", avail(g.Temp >= 0, g.Temp),
+		"gpu_sm_clock", avail(g.SMClock >= 0, g.SMClock),
+	}
+}
+
+// History returns the memory used in the recent samples, oldest first
+func (s *GPUSampler) History() []int {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	mem := make([]int, 0, len(s.history))
+	for _, g := range s.history {
+		mem = append(mem, g.Used)
+	}
+	return mem
+}
+
+// Summary returns the device and its peak memory and average utilization
+// over the run, from the samples already taken. It returns nil for cpu
+// jobs and when no sample succeeded.
+func (s *GPUSampler) Summary() []any {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.info.Vendor == "" {
+		return nil
+	}
+	var util any
+	if s.nutil > 0 {
+		util = round100(float64(s.util) / float64(s.nutil))
+	}
+	return []any{
+		"gpu_name", s.info.Name,
+		"gpu_pci", s.info.PCI,
+		"gpu_mem_peak", avail(s.peak > 0, s.peak),
+		"gpu_util_avg", util,
+		"driver", s.info.Driver,
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/as/log"
+)
+
+const smiOut = `0, 7000, 8192, Tesla T4, 00000000:00:1E.0, 535.104.05, GPU-aaa, 90, 3, 71, 1590, 0x0000000000000000
+1, 1000, 8192, Tesla T4, 00000000:00:1F.0, 535.104.05, GPU-bbb, 10, [N/A], 45, 1590, 0x0000000000000004
+garbage
+`
+
+// fakeGPU replaces gpuCmd and resets the cache. It returns the number of
+// times a tool ran, by name.
+func fakeGPU(t *testing.T, nvidia bool, amd string, cmd func(ctx context.Context, name string) ([]byte, error)) map[string]int {
+	t.Helper()
+	resetGPUCache()
+	ran := map[string]int{}
+	var mu sync.Mutex
+	defer func(f func(context.Context, string, ...string) ([]byte, error), c Caps) {
+		t.Cleanup(func() { gpuCmd, caps = f, c; resetGPUCache() })
+	}(gpuCmd, caps)
+	caps.NVIDIA, caps.AMD = nvidia, amd
+	gpuCmd = func(ctx context.Context, name string, args ...string) ([]byte, error) {
+		mu.Lock()
+		ran[name]++
+		mu.Unlock()
+		return cmd(ctx, name)
+	}
+	return ran
+}
+
+func resetGPUCache() {
+	gpuCache.Lock()
+	defer gpuCache.Unlock()
+	gpuCache.at, gpuCache.list, gpuCache.vendor = time.Time{}, nil, ""
+	gpuCache.warn = sync.Once{}
+}
+
+func TestParseGPUs(t *testing.T) {
+	list := parseGPUs([]byte(smiOut))
+	if len(list) != 2 {
+		t.Fatalf("parsed %d gpus, want 2: %+v", len(list), list)
+	}
+	g := list[1]
+	if g.N != 1 || g.Used != 1000 || g.Total != 8192 || g.Name != "Tesla T4" || g.UUID != "GPU-bbb" ||
+		g.Util != 10 || g.Sessions != -1 || g.Temp != 45 || g.Throttle != 4 || g.Vendor != "nvidia" {
+		t.Errorf("gpu 1: %+v", g)
+	}
+}
+
+func TestQueryGPU(t *testing.T) {
+	ran := fakeGPU(t, true, "", func(ctx context.Context, name string) ([]byte, error) {
+		if _, ok := ctx.Deadline(); !ok {
+			t.Errorf("%s ran without a timeout", name)
+		}
+		return []byte(smiOut), nil
+	})
+	list := queryGPU()
+	if len(list) != 2 || list[0].N != 1 {
+		t.Fatalf("queryGPU = %+v, want the least loaded gpu 1 first", list)
+	}
+	list[0].N = 99
+	if again := queryGPU(); again[0].N != 1 || ran["nvidia-smi"] != 1 {
+		t.Errorf("second query ran nvidia-smi %d times, got %+v; want it cached", ran["nvidia-smi"], again)
+	}
+	if lastVendor() != "nvidia" {
+		t.Errorf("lastVendor = %q", lastVendor())
+	}
+}
+
+// TestQueryGPUHung is nvidia-smi hanging on a wedged driver: the query
+// gives up at its deadline and the failure is logged once
+func TestQueryGPUHung(t *testing.T) {
+	buf := new(bytes.Buffer)
+	defer log.SetOutput(log.SetOutput(buf))
+	fakeGPU(t, true, "", func(ctx context.Context, name string) ([]byte, error) {
+		d, _ := ctx.Deadline()
+		if time.Until(d) > gpuTimeout {
+			t.Errorf("deadline in %s, want at most %s", time.Until(d), gpuTimeout)
+		}
+		return nil, context.DeadlineExceeded
+	})
+	for i := 0; i < 3; i++ {
+		if list := queryGPU(); list != nil {
+			t.Fatalf("queryGPU = %+v, want nil", list)
+		}
+		resetAt()
+	}
+	if n := strings.Count(buf.String(), "gpu query failed"); n != 1 {
+		t.Errorf("warned %d times, want once:\n%s", n, buf)
+	}
+}
+
+func resetAt() {
+	gpuCache.Lock()
+	gpuCache.at = time.Time{}
+	gpuCache.Unlock()
+}
+
+// TestQueryGPUFallback has nvidia-smi failing on a host that also has
+// rocm-smi. The one that worked is tried first next time.
+func TestQueryGPUFallback(t *testing.T) {
+	defer log.SetOutput(log.SetOutput(new(bytes.Buffer)))
+	ran := fakeGPU(t, true, "rocm-smi", func(ctx context.Context, name string) ([]byte, error) {
+		if name == "nvidia-smi" {
+			return nil, errors.New("exit status 9")
+		}
+		return []byte(`{"card0": {"VRAM Total Memory (B)": "8589934592", "VRAM Total Used Memory (B)": "1073741824", "GPU use (%)": "12", "Card series": "Radeon"}}`), nil
+	})
+	list := queryGPU()
+	if len(list) != 1 || list[0].Vendor != "amd" || list[0].Used != 1024 || list[0].Total != 8192 || list[0].Util != 12 {
+		t.Fatalf("queryGPU = %+v", list)
+	}
+	resetAt()
+	queryGPU()
+	if ran["nvidia-smi"] != 1 || ran["rocm-smi"] != 2 {
+		t.Errorf("ran %v, want rocm-smi tried first after it worked", ran)
+	}
+}
+
+func TestQueryGPUNone(t *testing.T) {
+	ran := fakeGPU(t, false, "", func(ctx context.Context, name string) ([]byte, error) {
+		return nil, nil
+	})
+	if list := queryGPU(); list != nil || len(ran) != 0 {
+		t.Errorf("queryGPU without tools = %+v, ran %v", list, ran)
+	}
+}
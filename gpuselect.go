@@ -0,0 +1,72 @@
+package main
+
+import (
+	"os"
+	"strconv"
+
+	"github.com/as/log"
+)
+
+// gpuSelect=auto moves the job to the nvidia gpu with the most free
+// memory, breaking ties by the fewest encoder sessions. It runs again
+// on every retry, so a retry after an OOM can land on another device.
+var gpuSelect = os.Getenv("GPUSELECT")
+
+// pickGPU returns the gpu with the most free memory
+func pickGPU(list []GPU) (best GPU, ok bool) {
+	for _, g := range list {
+		if g.Vendor != "nvidia" || g.Total <= 0 {
+			continue
+		}
+		free, bfree := g.Total-g.Used, best.Total-best.Used
+		if !ok || free > bfree || free == bfree && g.Sessions < best.Sessions {
+			best, ok = g, true
+		}
+	}
+	return best, ok
+}
+
+// selectGPU points the command at the least loaded gpu. It rewrites
+// whichever of CUDA_VISIBLE_DEVICES, -hwaccel_device and -gpu the caller
+// used, or adds -hwaccel_device after -hwaccel cuda if none were.
+func selectGPU(args []string) []string {
+	if gpuSelect != "auto" || !usesGPU(args) {
+		return args
+	}
+	list := queryGPU()
+	g, ok := pickGPU(list)
+	if !ok {
+		log.Warn.Add("topic", "gpu", "action", "select", "gpus", len(list)).Printf("no gpu to select, leaving the command alone")
+		return args
+	}
+	stats := make([]string, 0, len(list))
+	for _, v := range list {
+		stats = append(stats, strconv.Itoa(v.N)+":free="+strconv.Itoa(v.Total-v.Used)+"MiB,sessions="+strconv.Itoa(v.Sessions))
+	}
+	dev, how := strconv.Itoa(g.N), []string{}
+	if _, ok := os.LookupEnv("CUDA_VISIBLE_DEVICES"); ok {
+		os.Setenv("CUDA_VISIBLE_DEVICES", dev)
+		how = append(how, "CUDA_VISIBLE_DEVICES")
+		dev = "0" // the only visible device now
+	}
+	for i := 1; i < len(args); i++ {
+		switch args[i-1] {
+		case "-hwaccel_device", "-gpu":
+			args[i] = dev
+			how = append(how, args[i-1])
+		}
+	}
+	if len(how) == 0 {
+		for i := 1; i < len(args); i++ {
+			if args[i-1] == "-hwaccel" && args[i] == "cuda" {
+				args = append(args[:i+1], append([]string{"-hwaccel_device", dev}, args[i+1:]...)...)
+				how = append(how, "inject -hwaccel_device")
+				break
+			}
+		}
+	}
+	log.Info.Add("topic", "gpu", "action", "select", "vendor", g.Vendor, "gpu_num", g.N, "gpu_mem_free", g.Total-g.Used,
+		"encoder_sessions", avail(g.Sessions >= 0, g.Sessions), "applied", how, "gpus", stats,
+	).Printf("selected gpu %d", g.N)
+	return args
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/as/log"
+)
+
+var (
+	// live marks the job as a live feed, enabling the health score. It is
+	// also enabled by -re or a live protocol input (rtmp, srt, udp, ...).
+	live = os.Getenv("LIVE") == "1"
+
+	// healthPolicy, if set, is a json file overriding defaultPolicy
+	healthPolicy = os.Getenv("HEALTH_POLICY")
+)
+
+// Policy weighs the low-grade symptoms of a live feed into a 0-100 score.
+// Each weight is the penalty per event per minute over the window.
+type Policy struct {
+	Window   float64            `json:"window"` // seconds
+	Weights  map[string]float64 `json:"weights"`
+	Degraded float64            `json:"degraded"`
+	Critical float64            `json:"critical"`
+}
+
+var defaultPolicy = Policy{
+	Window: 60,
+	Weights: map[string]float64{
+		"dts":      2,
+		"loss":     1,
+		"underrun": 5,
+		"dupdrop":  0.1, // per frame
+		"speed":    50,  // per unit of deviation from 1.0x
+	},
+	Degraded: 70,
+	Critical: 40,
+}
+
+func loadPolicy() Policy {
+	// copy the weights, a partial policy is unmarshaled on top of them
+	p := defaultPolicy
+	p.Weights = make(map[string]float64, len(defaultPolicy.Weights))
+	for k, v := range defaultPolicy.Weights {
+		p.Weights[k] = v
+	}
+	if healthPolicy == "" {
+		return p
+	}
+	data, err := os.ReadFile(healthPolicy)
+	if err == nil {
+		err = json.Unmarshal(data, &p)
+	}
+	if err != nil {
+		fatal(log.Fatal.Add("topic", "health", "action", "badarg", "file", healthPolicy, "err", err), "cant load health policy")
+	}
+	var unknown []string
+	for k := range p.Weights {
+		if _, ok := defaultPolicy.Weights[k]; !ok {
+			unknown = append(unknown, k)
+			delete(p.Weights, k)
+		}
+	}
+	sort.Strings(unknown)
+	for _, k := range unknown {
+		log.Warn.Add("topic", "health", "action", "badarg", "file", healthPolicy, "weight", k).Printf("ignoring unknown health weight %q", k)
+	}
+	return p
+}
+
+var (
+	rtpLoss = regexp.MustCompile(`RTP: missed (\d+) packets`)
+
+	symptoms = struct {
+		sync.Mutex
+		n map[string]int
+	}{n: map[string]int{}}
+)
+
+// noteSymptom counts the health symptoms in an ffmpeg output line
+func noteSymptom(line string) {
+	n, class := 1, ""
+	switch {
+	case hastext(line, "cur_dts is invalid"):
+		class = "dts"
+	case hastext(line, "underflow", "underrun"):
+		class = "underrun"
+	case rtpLoss.MatchString(line):
+		class = "loss"
+		fmt.Sscan(rtpLoss.FindStringSubmatch(line)[1], &n)
+	default:
+		return
+	}
+	symptoms.Lock()
+	symptoms.n[class] += n
+	symptoms.Unlock()
+}
+
+func isLive(args []string) bool {
+	if live || hasFlag(args, "-re") {
+		return true
+	}
+	for i := 1; i < len(args); i++ {
+		if args[i-1] == "-i" && hastext(args[i], "rtmp://", "rtmps://", "rtsp://", "srt://", "udp://", "rtp://") {
+			return true
+		}
+	}
+	return false
+}
+
+type healthSample struct {
+	at    time.Time
+	n     map[string]int
+	state State
+}
+
+// Health tracks a rolling health score and the time spent in each band
+type Health struct {
+	Policy
+	Score   float64
+	band    string
+	since   time.Time
+	samples []healthSample
+	inband  map[string]time.Duration
+}
+
+func NewHealth(p Policy) *Health {
+	return &Health{Policy: p, Score: 100, band: "healthy", since: time.Now(), inband: map[string]time.Duration{}}
+}
+
+// Update folds the current symptom counts and state into the score
+// and logs a transition when the score crosses a band threshold. Rates
+// are per minute over the whole window, so a symptom early on, before
+// the window fills, weighs the same as it would later.
+func (h *Health) Update(s State) {
+	now := time.Now()
+	symptoms.Lock()
+	n := make(map[string]int, len(symptoms.n))
+	for k, v := range symptoms.n {
+		n[k] = v
+	}
+	symptoms.Unlock()
+
+	h.samples = append(h.samples, healthSample{now, n, s})
+	for len(h.samples) > 1 && now.Sub(h.samples[0].at).Seconds() > h.Window {
+		h.samples = h.samples[1:]
+	}
+	first := h.samples[0]
+	minutes := h.Window / 60
+	if len(h.samples) < 2 || minutes <= 0 {
+		return
+	}
+	penalty := 0.0
+	for class, w := range h.Weights {
+		penalty += w * float64(n[class]-first.n[class]) / minutes
+	}
+	dd := (s.Dup + s.Drop) - (first.state.Dup + first.state.Drop)
+	penalty += h.Weights["dupdrop"] * float64(dd) / minutes
+	if s.Speed > 0 {
+		penalty += h.Weights["speed"] * math.Abs(1-s.Speed)
+	}
+	h.Score = math.Max(0, math.Min(100, math.Round(100-penalty)))
+
+	band := "healthy"
+	if h.Score < h.Critical {
+		band = "critical"
+	} else if h.Score < h.Degraded {
+		band = "degraded"
+	}
+	if band != h.band {
+		ln := log.Warn
+		if band == "healthy" {
+			ln = log.Info
+		}
+		ln.Add("topic", "health", "action", "transition", "from", h.band, "to", band, "health", h.Score).Printf("stream health %s", band)
+		h.inband[h.band] += now.Sub(h.since)
+		h.band, h.since = band, now
+	}
+}
+
+// Value returns the score, or nil when health isn't tracked
+func (h *Health) Value() any {
+	if h == nil {
+		return nil
+	}
+	return h.Score
+}
+
+// Fields returns the seconds spent in each health band
+func (h *Health) Fields() []any {
+	if h == nil {
+		return nil
+	}
+	h.inband[h.band] += time.Since(h.since)
+	h.since = time.Now()
+	return []any{
+		"health_healthy_s", h.inband["healthy"].Seconds(),
+		"health_degraded_s", h.inband["degraded"].Seconds(),
+		"health_critical_s", h.inband["critical"].Seconds(),
+	}
+}
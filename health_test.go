@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/as/log"
+)
+
+func TestLoadPolicy(t *testing.T) {
+	defer func(f string) { healthPolicy = f }(healthPolicy)
+	healthPolicy = filepath.Join(t.TempDir(), "policy.json")
+	os.WriteFile(healthPolicy, []byte(`{"weights": {"dts": 9, "custom": 3}, "degraded": 80}`), 0644)
+	buf := new(bytes.Buffer)
+	defer log.SetOutput(log.SetOutput(buf))
+
+	p := loadPolicy()
+	if p.Weights["dts"] != 9 || p.Weights["loss"] != 1 || p.Degraded != 80 || p.Critical != 40 {
+		t.Errorf("loaded %+v, want the file on top of the defaults", p)
+	}
+	if _, ok := p.Weights["custom"]; ok || !strings.Contains(buf.String(), `"weight":"custom"`) {
+		t.Errorf("unknown weight: loaded %v, logged %s; want it dropped with a warning", p.Weights, buf)
+	}
+	if defaultPolicy.Weights["dts"] != 2 || defaultPolicy.Weights["custom"] != 0 || defaultPolicy.Degraded != 70 {
+		t.Errorf("loading a policy changed the default: %+v", defaultPolicy)
+	}
+
+	healthPolicy = ""
+	if p := loadPolicy(); p.Weights["dts"] != 2 || len(p.Weights) != len(defaultPolicy.Weights) {
+		t.Errorf("default policy %+v", p)
+	}
+}
+
+func resetSymptoms() {
+	symptoms.Lock()
+	defer symptoms.Unlock()
+	symptoms.n = map[string]int{}
+}
+
+// TestHealthEarly is a symptom seconds into the job, which is rated
+// over the whole window rather than the few seconds seen so far
+func TestHealthEarly(t *testing.T) {
+	defer resetSymptoms()
+	resetSymptoms()
+	buf := new(bytes.Buffer)
+	defer log.SetOutput(log.SetOutput(buf))
+
+	h := NewHealth(defaultPolicy)
+	h.Update(State{Speed: 1})
+	noteSymptom("[mp4 @ 0x1] Application provided invalid, non monotonically increasing dts to muxer: cur_dts is invalid")
+	time.Sleep(time.Millisecond)
+	h.Update(State{Speed: 1})
+	if h.Score != 98 || h.band != "healthy" || buf.Len() != 0 {
+		t.Fatalf("one dts error: score %v, band %s, logged %s; want 98 and healthy", h.Score, h.band, buf)
+	}
+
+	for i := 0; i < 20; i++ {
+		noteSymptom("RTP: missed 2 packets")
+	}
+	h.Update(State{Speed: 1})
+	if h.Score != 58 || h.band != "degraded" || !strings.Contains(buf.String(), `"to":"degraded"`) {
+		t.Errorf("40 lost packets: score %v, band %s, logged %s; want 58 and degraded", h.Score, h.band, buf)
+	}
+}
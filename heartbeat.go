@@ -0,0 +1,65 @@
+package main
+
+import (
+	"os"
+	"strconv"
+
+	"github.com/as/log"
+)
+
+var (
+	// heartbeatFile, if set, is rewritten with the frame number whenever
+	// ffmpeg makes progress, so an external watchdog can check its mtime
+	heartbeatFile = os.Getenv("HEARTBEAT_FILE")
+
+	// heartbeatKeep leaves HEARTBEAT_FILE with the outcome after a
+	// clean exit instead of removing it
+	heartbeatKeep = os.Getenv("HEARTBEAT_KEEP") == "1"
+)
+
+// Heartbeat touches HEARTBEAT_FILE when the frame or size advances. It
+// stops beating when ffmpeg stalls even though the wrapper is alive.
+type Heartbeat struct {
+	path  string
+	frame int
+	size  int64
+	fail  bool
+}
+
+// newHeartbeat returns nil when HEARTBEAT_FILE is unset
+func newHeartbeat() *Heartbeat {
+	if heartbeatFile == "" {
+		return nil
+	}
+	h := &Heartbeat{path: heartbeatFile}
+	atExit(func() {
+		if outcome == "done" && !heartbeatKeep {
+			os.Remove(h.path)
+			return
+		}
+		h.write(outcome)
+	})
+	return h
+}
+
+// Beat writes the frame number if s advanced. A counter that went
+// backwards started over, i.e. the next pass, and counts as a beat too.
+// It's a no-op on a nil Heartbeat.
+func (h *Heartbeat) Beat(s State) {
+	if h == nil {
+		return
+	}
+	reset := s.Frame < h.frame || s.Size < h.size
+	if !reset && s.Frame <= h.frame && s.Size <= h.size {
+		return
+	}
+	h.frame, h.size = s.Frame, s.Size
+	h.write(strconv.Itoa(s.Frame))
+}
+
+func (h *Heartbeat) write(v string) {
+	if err := os.WriteFile(h.path, []byte(v+"\n"), 0644); err != nil && !h.fail {
+		h.fail = true
+		log.Warn.Add("topic", "heartbeat", "action", "write", "file", h.path, "err", err).Printf("cant write heartbeat, further errors suppressed")
+	}
+}
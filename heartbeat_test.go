@@ -0,0 +1,39 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestHeartbeat(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "heartbeat")
+	h := &Heartbeat{path: file}
+	read := func() string {
+		data, _ := os.ReadFile(file)
+		os.Remove(file)
+		return strings.TrimSpace(string(data))
+	}
+	for _, tt := range []struct {
+		name  string
+		frame int
+		size  int64
+		want  string // "" when it shouldn't beat
+	}{
+		{"first frame", 10, 100, "10"},
+		{"advanced", 20, 200, "20"},
+		{"stalled", 20, 200, ""},
+		{"size only", 20, 300, "20"},
+		{"pass 2 starts", 1, 10, "1"},
+		{"pass 2 advances", 5, 50, "5"},
+		{"pass 2 stalls", 5, 50, ""},
+	} {
+		h.Beat(State{Frame: tt.frame, Size: tt.size})
+		if got := read(); got != tt.want {
+			t.Errorf("%s: heartbeat %q, want %q", tt.name, got, tt.want)
+		}
+	}
+	var nilh *Heartbeat
+	nilh.Beat(State{Frame: 1})
+}
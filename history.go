@@ -0,0 +1,78 @@
+package main
+
+import (
+	"math"
+	"sort"
+)
+
+// historySize bounds the samples History keeps per metric. When it
+// fills, every other sample is dropped and the stride doubles, so a
+// day-long job still keeps an evenly spaced curve.
+const historySize = 1024
+
+// History samples fps, speed and bitrate once per tick for the
+// percentiles in the summary
+type History struct {
+	stride, skip    int
+	fps, speed, bps []float64
+}
+
+func (h *History) Add(s State) {
+	if s.Frame == 0 && s.Size == 0 {
+		return
+	}
+	if h.stride == 0 {
+		h.stride = 1
+	}
+	if h.skip++; h.skip < h.stride {
+		return
+	}
+	h.skip = 0
+	h.fps = append(h.fps, float64(s.FPS))
+	h.speed = append(h.speed, s.Speed)
+	if s.Bitrate >= 0 {
+		h.bps = append(h.bps, float64(s.Bitrate))
+	}
+	if len(h.fps) >= historySize {
+		h.fps, h.speed, h.bps = halve(h.fps), halve(h.speed), halve(h.bps)
+		h.stride *= 2
+	}
+}
+
+// halve keeps every other element of v
+func halve(v []float64) []float64 {
+	for i := 0; 2*i < len(v); i++ {
+		v[i] = v[2*i]
+	}
+	return v[:(len(v)+1)/2]
+}
+
+// Summary returns the min, median, 95th percentile and max of each metric
+func (h *History) Summary() (kv []any) {
+	for _, m := range []struct {
+		name string
+		v    []float64
+	}{{"fps", h.fps}, {"speed", h.speed}, {"bps", h.bps}} {
+		if len(m.v) == 0 {
+			continue
+		}
+		v := append([]float64{}, m.v...)
+		sort.Float64s(v)
+		kv = append(kv,
+			m.name+"_min", round100(v[0]),
+			m.name+"_p50", round100(percentile(v, 50)),
+			m.name+"_p95", round100(percentile(v, 95)),
+			m.name+"_max", round100(v[len(v)-1]),
+		)
+	}
+	return kv
+}
+
+// percentile returns the nearest-rank p'th percentile of sorted v
+func percentile(v []float64, p float64) float64 {
+	i := int(math.Ceil(p/100*float64(len(v)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	return v[i]
+}
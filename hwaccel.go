@@ -0,0 +1,122 @@
+package main
+
+import (
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/as/log"
+)
+
+// hwaccelStrict fails the job when ffmpeg falls back to a software
+// decoder even though the command asked for a hardware one
+var hwaccelStrict = os.Getenv("HWACCEL_STRICT") == "1"
+
+// exitHWAccel is the exit status when HWACCEL_STRICT fails a job
+const exitHWAccel = 8
+
+// hwDecoders are the suffixes of ffmpeg's hardware decoders
+var hwDecoders = []string{"_cuvid", "_qsv", "_mmal", "_v4l2m2m", "_mediacodec", "_rkmpp"}
+
+var (
+	autoHWRE = regexp.MustCompile(`Using auto hwaccel type (\w+)`)
+	decRE    = regexp.MustCompile(`^\w+ \((\w+)\)`)
+)
+
+// hwaccel is what ffmpeg said about the decoders it picked
+var hwaccel struct {
+	sync.Mutex
+	auto    string // the type -hwaccel auto chose
+	decoder string // a hardware decoder in the stream mapping
+	mapped  bool   // the stream mapping was seen
+	failed  string // the line where hwaccel setup failed
+}
+
+// noteHWAccel records the hwaccel and decoder lines from the banner
+func noteHWAccel(line string) {
+	hwaccel.Lock()
+	defer hwaccel.Unlock()
+	if m := autoHWRE.FindStringSubmatch(line); m != nil {
+		hwaccel.auto = m[1]
+	}
+	if m := mapRE.FindStringSubmatch(line); m != nil {
+		hwaccel.mapped = true
+		if d := decRE.FindStringSubmatch(m[3]); d != nil && hwDecoder(d[1]) != "" {
+			hwaccel.decoder = d[1]
+		}
+	}
+	if hwaccel.failed == "" && hastext(line, "Failed setup for format", "hwaccel initialisation returned error", "doesn't support hardware accelerated", "No device available for decoder") {
+		hwaccel.failed = line
+	}
+}
+
+// hwDecoder returns the hardware family of decoder, or ""
+func hwDecoder(decoder string) string {
+	for _, s := range hwDecoders {
+		if strings.HasSuffix(decoder, s) {
+			return s[1:]
+		}
+	}
+	return ""
+}
+
+// requestedHWAccel returns the hwaccel args ask for: the -hwaccel type,
+// or the family of a hardware decoder given as an input codec
+func requestedHWAccel(args []string) (hw string, decoder bool) {
+	if v := flagValue(args, "-hwaccel"); v != "" && v != "none" {
+		return v, false
+	}
+	for i := 1; i < len(args); i++ {
+		if args[i] == "-i" {
+			break
+		}
+		switch args[i-1] {
+		case "-c:v", "-codec:v", "-vcodec":
+			if hw := hwDecoder(args[i]); hw != "" {
+				return hw, true
+			}
+		}
+	}
+	return "", false
+}
+
+// hwaccelEffective returns the hwaccel ffmpeg actually decoded with:
+// software when it fell back, or "" when it can't tell
+func hwaccelEffective(args []string) string {
+	hwaccel.Lock()
+	defer hwaccel.Unlock()
+	want, decoder := requestedHWAccel(args)
+	switch {
+	case hwaccel.decoder != "":
+		return hwDecoder(hwaccel.decoder)
+	case want == "":
+		return ""
+	case hwaccel.failed != "":
+		return "software"
+	case hwaccel.auto != "":
+		return hwaccel.auto
+	case decoder && hwaccel.mapped:
+		// a hardware decoder was asked for and the mapping shows another
+		return "software"
+	case !decoder && want != "auto":
+		// -hwaccel doesn't show in the mapping, no news is good news
+		return want
+	}
+	return ""
+}
+
+// checkHWAccel warns when ffmpeg decodes in software although args
+// asked for a hwaccel. It returns true if that should fail the job,
+// see HWACCEL_STRICT.
+func checkHWAccel(args []string) (fail bool) {
+	want, _ := requestedHWAccel(args)
+	if want == "" || hwaccelEffective(args) != "software" {
+		return false
+	}
+	hwaccel.Lock()
+	line := hwaccel.failed
+	hwaccel.Unlock()
+	log.Warn.Add("topic", "gpu", "action", "alert", "subject", "sw_fallback", "hwaccel", want, "hwaccel_effective", "software", "line", line, "strict", hwaccelStrict).Printf("ffmpeg fell back to software decoding, expect it to be much slower")
+	return hwaccelStrict
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"os"
+	"strconv"
+)
+
+var (
+	// hwframesStep grows -extra_hw_frames by this much on every retry
+	// instead of doubling it
+	hwframesStep, _ = strconv.Atoi(os.Getenv("HWFRAMES_STEP"))
+
+	// hwframesStart is the -extra_hw_frames value injected when the
+	// command doesn't have one. default=8
+	hwframesStart, _ = strconv.Atoi(os.Getenv("HWFRAMES_START"))
+
+	// hwframesMin is the floor when shrinking -extra_hw_frames after a
+	// gpu OOM. default=2
+	hwframesMin, _ = strconv.Atoi(os.Getenv("HWFRAMES_MIN"))
+
+	// hwframesDir is the direction earlier attempts moved
+	// -extra_hw_frames in: up, down or empty
+	hwframesDir = os.Getenv(hwframesDirEnv)
+)
+
+const hwframesDirEnv = "FFJSON_HWFRAMES_DIR"
+
+// hwframesMove decides what to do with -extra_hw_frames (n, or zero if
+// absent) after a failure. Running out of decoder surfaces grows it and a
+// gpu OOM shrinks it, but never back the way an earlier attempt went: once
+// both have been seen the range has collapsed and ok is false.
+func hwframesMove(dir string, surfacesLeft, oom bool, n int) (next int, newdir string, ok bool) {
+	switch {
+	case surfacesLeft && dir != "down":
+		if n == 0 {
+			return 0, "up", true // growHWFrames injects the starting value
+		}
+		next = growFrames(n)
+		return next, "up", next > n
+	case oom && dir != "up" && n > 0:
+		if hwframesMin == 0 {
+			hwframesMin = 2
+		}
+		next = n / 2
+		if next < hwframesMin {
+			next = hwframesMin
+		}
+		return next, "down", next < n
+	}
+	return n, dir, false
+}
+
+// growFrames returns the next -extra_hw_frames value after n: n doubled,
+// or n+HWFRAMES_STEP, capped at MAXEXTRAHWFRAMES
+func growFrames(n int) int {
+	switch {
+	case hwframesStep > 0:
+		n += hwframesStep
+	case n < 1:
+		n = 1
+	default:
+		n *= 2
+	}
+	if n > hwframesmax {
+		n = hwframesmax
+	}
+	return n
+}
+
+// growHWFrames raises -extra_hw_frames in argv, or adds it before the
+// first input if it's missing. It returns the old and new values and
+// false if the value can't grow any more.
+func growHWFrames(argv []string) (_ []string, old, new int, ok bool) {
+	if !hasFlag(argv, "-extra_hw_frames") {
+		if hwframesStart == 0 {
+			hwframesStart = 8
+		}
+		for i, a := range argv {
+			if a == "-i" {
+				argv = append(argv[:i:i], append([]string{"-extra_hw_frames", strconv.Itoa(hwframesStart)}, argv[i:]...)...)
+				return argv, 0, hwframesStart, true
+			}
+		}
+		return argv, 0, 0, false
+	}
+	old, _ = strconv.Atoi(flagValue(argv, "-extra_hw_frames"))
+	if old >= hwframesmax || !rewrite(argv, "hwframes") {
+		return argv, old, old, false
+	}
+	new, _ = strconv.Atoi(flagValue(argv, "-extra_hw_frames"))
+	return argv, old, new, new != old
+}
+
+// setHWFrames sets every -extra_hw_frames value in argv to n
+func setHWFrames(argv []string, n int) []string {
+	for i := 1; i < len(argv); i++ {
+		if argv[i-1] == "-extra_hw_frames" {
+			argv[i] = strconv.Itoa(n)
+		}
+	}
+	return argv
+}
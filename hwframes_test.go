@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/as/log"
+)
+
+func TestHWFramesMove(t *testing.T) {
+	defer func(min, step, max int) { hwframesMin, hwframesStep, hwframesmax = min, step, max }(hwframesMin, hwframesStep, hwframesmax)
+	hwframesMin, hwframesStep, hwframesmax = 0, 0, 64
+	for _, tt := range []struct {
+		name     string
+		dir      string
+		surfaces bool
+		oom      bool
+		n        int
+		next     int
+		newdir   string
+		ok       bool
+	}{
+		{"grow from nothing", "", true, false, 0, 0, "up", true},
+		{"grow", "", true, false, 8, 16, "up", true},
+		{"grow again", "up", true, false, 16, 32, "up", true},
+		{"at the cap", "up", true, false, 64, 64, "up", false},
+		{"shrink", "", false, true, 16, 8, "down", true},
+		{"shrink to the floor", "down", false, true, 3, 2, "down", true},
+		{"at the floor", "down", false, true, 2, 2, "down", false},
+		{"oom without the flag", "", false, true, 0, 0, "", false},
+		{"grow after shrinking", "down", true, false, 8, 8, "down", false},
+		{"shrink after growing", "up", false, true, 32, 32, "up", false},
+		{"neither", "", false, false, 8, 8, "", false},
+	} {
+		next, dir, ok := hwframesMove(tt.dir, tt.surfaces, tt.oom, tt.n)
+		if next != tt.next || dir != tt.newdir || ok != tt.ok {
+			t.Errorf("%s: hwframesMove = %d, %q, %v, want %d, %q, %v", tt.name, next, dir, ok, tt.next, tt.newdir, tt.ok)
+		}
+	}
+}
+
+func TestGrowFrames(t *testing.T) {
+	defer func(step, max int) { hwframesStep, hwframesmax = step, max }(hwframesStep, hwframesmax)
+	for _, tt := range []struct {
+		step, max, n, want int
+	}{
+		{0, 64, 0, 1},
+		{0, 64, 8, 16},
+		{0, 64, 48, 64},
+		{4, 64, 8, 12},
+		{4, 10, 8, 10},
+	} {
+		hwframesStep, hwframesmax = tt.step, tt.max
+		if got := growFrames(tt.n); got != tt.want {
+			t.Errorf("growFrames(%d) with step %d, max %d = %d, want %d", tt.n, tt.step, tt.max, got, tt.want)
+		}
+	}
+}
+
+func TestGrowHWFrames(t *testing.T) {
+	defer log.SetOutput(log.SetOutput(new(bytes.Buffer)))
+	defer func(r []Rule, start, step, max int) {
+		rules, hwframesStart, hwframesStep, hwframesmax = r, start, step, max
+	}(rules, hwframesStart, hwframesStep, hwframesmax)
+	rules, hwframesStart, hwframesStep, hwframesmax = defaultRules, 0, 0, 64
+
+	argv, old, n, ok := growHWFrames([]string{"ffmpeg-json", "-hwaccel", "cuda", "-i", "in.mp4", "out.mp4"})
+	want := []string{"ffmpeg-json", "-hwaccel", "cuda", "-extra_hw_frames", "8", "-i", "in.mp4", "out.mp4"}
+	if !reflect.DeepEqual(argv, want) || old != 0 || n != 8 || !ok {
+		t.Errorf("inject: %q, %d, %d, %v", argv, old, n, ok)
+	}
+	argv, old, n, ok = growHWFrames(argv)
+	if flagValue(argv, "-extra_hw_frames") != "16" || old != 8 || n != 16 || !ok {
+		t.Errorf("grow: %q, %d, %d, %v", argv, old, n, ok)
+	}
+	argv = setHWFrames(argv, 64)
+	if _, old, n, ok = growHWFrames(argv); old != 64 || n != 64 || ok {
+		t.Errorf("at the cap: %d, %d, %v, want no change", old, n, ok)
+	}
+	if _, _, _, ok = growHWFrames([]string{"ffmpeg-json", "out.mp4"}); ok {
+		t.Errorf("grew without an input")
+	}
+}
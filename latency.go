@@ -0,0 +1,54 @@
+package main
+
+import (
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/as/log"
+)
+
+// firstStart is when the first attempt started. A retry passes it to
+// the next attempt in RETRY_START, in unix milliseconds
+var firstStart = retryStart()
+
+func retryStart() time.Time {
+	ms, err := strconv.ParseInt(os.Getenv("RETRY_START"), 10, 64)
+	if err != nil || ms <= 0 {
+		return procstart
+	}
+	return time.UnixMilli(ms)
+}
+
+// Latency measures how long this attempt took to encode its first frame
+// and to write its first byte of output
+type Latency struct {
+	start        time.Time
+	frame, bytes time.Duration
+}
+
+func NewLatency(start time.Time) *Latency {
+	return &Latency{start: start}
+}
+
+// Observe records the latencies the first time s shows them. The first
+// frame is logged once as topic=status action=first_frame.
+func (l *Latency) Observe(s State, now time.Time) {
+	if l.bytes == 0 && s.Size > 0 {
+		l.bytes = now.Sub(l.start)
+	}
+	if l.frame == 0 && s.Frame > 0 {
+		l.frame = now.Sub(l.start)
+		log.Info.Add("topic", "status", "action", "first_frame", "latency", l.frame.Seconds(), "retry", avail(retry > 0, retry)).Printf("first frame after %s", l.frame)
+	}
+}
+
+// Summary returns the latencies of this attempt and the wall time
+// since the first attempt started
+func (l *Latency) Summary(now time.Time) []any {
+	return []any{
+		"first_frame_latency", avail(l.frame > 0, l.frame.Seconds()),
+		"first_byte_latency", avail(l.bytes > 0, l.bytes.Seconds()),
+		"wall_time", now.Sub(firstStart).Seconds(),
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/as/log"
+)
+
+func TestLatency(t *testing.T) {
+	buf := new(bytes.Buffer)
+	defer log.SetOutput(log.SetOutput(buf))
+	defer func(t time.Time) { firstStart = t }(firstStart)
+	start := time.Unix(1000, 0)
+	firstStart = start.Add(-time.Minute) // an earlier attempt
+
+	l := NewLatency(start)
+	if kv := l.Summary(start); kv[1] != nil || kv[3] != nil || kv[5] != 60.0 {
+		t.Errorf("Summary before any output: %v", kv)
+	}
+	for _, s := range []struct {
+		State
+		at time.Duration
+	}{
+		{State{}, time.Second},
+		{State{Size: 48}, 2 * time.Second}, // the header
+		{State{Frame: 1, Size: 1024}, 3500 * time.Millisecond},
+		{State{Frame: 50, Size: 4096}, 5 * time.Second},
+	} {
+		l.Observe(s.State, start.Add(s.at))
+	}
+	kv := l.Summary(start.Add(10 * time.Second))
+	if kv[1] != 3.5 || kv[3] != 2.0 || kv[5] != 70.0 {
+		t.Errorf("Summary = %v, want first frame 3.5s, first byte 2s, wall time 70s", kv)
+	}
+	if n := strings.Count(buf.String(), `"first_frame"`); n != 1 {
+		t.Errorf("logged first_frame %d times, want once:\n%s", n, buf)
+	}
+}
+
+func TestRetryStart(t *testing.T) {
+	t.Setenv("RETRY_START", "1700000000123")
+	if got := retryStart(); !got.Equal(time.UnixMilli(1700000000123)) {
+		t.Errorf("retryStart = %v", got)
+	}
+	for _, v := range []string{"", "0", "soon"} {
+		t.Setenv("RETRY_START", v)
+		if got := retryStart(); !got.Equal(procstart) {
+			t.Errorf("RETRY_START=%q: retryStart = %v, want procstart", v, got)
+		}
+	}
+}
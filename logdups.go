@@ -0,0 +1,53 @@
+package main
+
+import (
+	"os"
+	"time"
+
+	"github.com/as/ffmpeg-json/ffmpegjson"
+)
+
+var (
+	// logDups=1 logs every periodic status line, even when nothing but
+	// the clock changed since the last one
+	logDups = os.Getenv("LOGDUPS") == "1"
+
+	// logKeepalive is the longest a repeated status line is skipped
+	// for, so a quiet log still shows the job is alive. default=5m
+	logKeepalive = envDur("LOGDUPS_KEEPALIVE")
+)
+
+func init() {
+	if logKeepalive <= 0 {
+		logKeepalive = 5 * time.Minute
+	}
+}
+
+// Repeats skips status lines that would repeat the last one logged.
+// It's only consulted when logging, so the watchdogs see every State.
+type Repeats struct {
+	last State
+	perc int
+	when time.Time
+}
+
+// Skip reports whether s at perc percent repeats the last line logged.
+// It records s as the last line otherwise.
+func (r *Repeats) Skip(s State, perc int, now time.Time) bool {
+	if logDups {
+		return false
+	}
+	s = r.key(s)
+	if s == r.last && perc == r.perc && now.Sub(r.when) < logKeepalive {
+		return true
+	}
+	r.last, r.perc, r.when = s, perc, now
+	return false
+}
+
+// key drops the fields that change without meaning anything: q jitters
+// while a stalled encoder idles, and N is the wrapper's own bookkeeping
+func (r *Repeats) key(s State) State {
+	s.Q, s.Qs, s.NQ, s.N = 0, [ffmpegjson.MaxQ]float64{}, 0, 0
+	return s
+}
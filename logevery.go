@@ -0,0 +1,62 @@
+package main
+
+import (
+	"os"
+	"strconv"
+	"time"
+)
+
+var (
+	// logEvery=change also logs a status line when the integer progress
+	// changes or the frame advances by LOGEVERY_FRAMES, so jobs shorter
+	// than the ticker don't jump from 0 to 100. default=ticker only
+	logEvery = os.Getenv("LOGEVERY")
+
+	// logEveryFrames is the frame step for LOGEVERY=change. default=250
+	logEveryFrames, _ = strconv.Atoi(os.Getenv("LOGEVERY_FRAMES"))
+
+	// logEveryMin caps LOGEVERY=change to one line per interval, so a
+	// verbose ffmpeg can't flood the log. default=500ms
+	logEveryMin = envDur("LOGEVERY_MIN")
+)
+
+func init() {
+	if logEveryFrames <= 0 {
+		logEveryFrames = 250
+	}
+	if logEveryMin <= 0 {
+		logEveryMin = 500 * time.Millisecond
+	}
+}
+
+// Changes decides when a state change is worth a status line. A nil
+// Changes never is, see newChanges.
+type Changes struct {
+	perc  int
+	frame int
+	last  time.Time
+}
+
+// newChanges returns nil unless LOGEVERY=change
+func newChanges() *Changes {
+	if logEvery != "change" {
+		return nil
+	}
+	return &Changes{perc: -1}
+}
+
+// Due reports whether s at perc percent should be logged now
+func (c *Changes) Due(s State, perc int, now time.Time) bool {
+	if c == nil || now.Sub(c.last) < logEveryMin {
+		return false
+	}
+	return perc != c.perc || s.Frame-c.frame >= logEveryFrames
+}
+
+// Logged records a status line, from either the ticker or Due
+func (c *Changes) Logged(s State, perc int, now time.Time) {
+	if c == nil {
+		return
+	}
+	c.perc, c.frame, c.last = perc, s.Frame, now
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/as/log"
+)
+
+// logFieldsEnv are extra key=value pairs added to every log line,
+// i.e. job_id=abc123,tenant=acme
+var logFieldsEnv = os.Getenv("LOG_FIELDS")
+
+var fieldKeyRE = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.-]*$`)
+
+// reservedKeys are the keys the logger and the wrapper itself emit
+var reservedKeys = []string{"svc", "ts", "level", "msg", "topic", "action", "subject", "details", "err"}
+
+// parseLogFields parses s into key value pairs, returning the pairs
+// and the entries that were rejected
+func parseLogFields(s string) (kv []any, bad []string) {
+	seen := map[string]bool{}
+	for _, f := range strings.Split(s, ",") {
+		if f = strings.TrimSpace(f); f == "" {
+			continue
+		}
+		k, v, ok := strings.Cut(f, "=")
+		if !ok || !fieldKeyRE.MatchString(k) || hasFlag(reservedKeys, k) || seen[k] {
+			bad = append(bad, f)
+			continue
+		}
+		seen[k] = true
+		kv = append(kv, k, v)
+	}
+	return kv, bad
+}
+
+// setLogFields adds LOG_FIELDS to every log line. A retried process
+// gets the same environment and so the same fields.
+func setLogFields() {
+	if logFieldsEnv == "" {
+		return
+	}
+	kv, bad := parseLogFields(logFieldsEnv)
+	log.Tags = log.Tags.Add(kv...)
+	if len(bad) > 0 {
+		log.Warn.Add("topic", "env", "action", "log_fields", "invalid", bad).Printf("ignoring invalid LOG_FIELDS entries")
+	}
+}
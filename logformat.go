@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/as/log"
+)
+
+// logFormat is json or kv. json re-encodes every log line as compact
+// json with stable types: speed and q are always numbers, size and bps
+// always integers. kv leaves the log package's output alone. default=kv
+var logFormat = os.Getenv("LOGFORMAT")
+
+var (
+	// logDest is stdout, syslog or both. stdout is the regular log
+	// stream. default=stdout
+	logDest = os.Getenv("LOGDEST")
+
+	// syslogTag is the syslog tag. default=ffmpeg-json
+	syslogTag = os.Getenv("SYSLOG_TAG")
+)
+
+// syslogger is the part of *syslog.Writer used for LOGDEST=syslog
+type syslogger interface {
+	Debug(string) error
+	Info(string) error
+	Warning(string) error
+	Err(string) error
+}
+
+var (
+	floatKeys = map[string]bool{"speed": true, "q": true, "runtime": true, "uptime": true, "fps_avg": true, "speed_avg": true}
+	intKeys   = map[string]bool{"size": true, "bps": true, "frame": true, "fps": true, "dup": true, "drop": true}
+)
+
+// logWriter is the writer every log line goes through
+type logWriter struct {
+	mu   sync.Mutex
+	w    io.Writer // nil when LOGDEST=syslog
+	sys  syslogger
+	json bool
+}
+
+func (l *logWriter) Write(p []byte) (int, error) {
+	line := p
+	if l.json {
+		if v, err := encodeJSON(p); err == nil {
+			line = v
+		}
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.sys != nil {
+		priority(l.sys, line)(string(bytes.TrimSpace(line)))
+	}
+	if l.w != nil {
+		if _, err := l.w.Write(line); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// priority returns the syslog function for a log line. Alerts are
+// warnings and failure summaries errors, regardless of the level
+// they're logged at.
+func priority(sys syslogger, line []byte) func(string) error {
+	var f struct{ Level, Topic, Action string }
+	json.Unmarshal(line, &f)
+	switch {
+	case f.Topic == "summary" && f.Action == "failed":
+		return sys.Err
+	case f.Action == "alert" || f.Action == "stall" || f.Topic == "dup":
+		return sys.Warning
+	case f.Level == "fatal" || f.Level == "error":
+		return sys.Err
+	case f.Level == "warn":
+		return sys.Warning
+	case f.Level == "debug":
+		return sys.Debug
+	}
+	return sys.Info
+}
+
+// setLogFormat installs the logWriter according to LOGFORMAT and LOGDEST
+func setLogFormat() {
+	lw := &logWriter{}
+	switch logFormat {
+	case "json":
+		lw.json = true
+	case "", "kv":
+	default:
+		defer log.Warn.Add("topic", "env", "action", "logformat", "logformat", logFormat).Printf("unknown LOGFORMAT, using kv")
+	}
+	stdout := log.SetOutput(lw)
+	switch logDest {
+	case "syslog", "both":
+		if syslogTag == "" {
+			syslogTag = "ffmpeg-json"
+		}
+		sys, err := dialSyslog(syslogTag)
+		if err != nil {
+			lw.w = stdout
+			defer log.Warn.Add("topic", "env", "action", "logdest", "logdest", logDest, "err", err).Printf("cant connect to syslog, logging to stdout")
+			return
+		}
+		lw.sys = sys
+		if logDest == "both" {
+			lw.w = stdout
+		}
+	case "", "stdout":
+		lw.w = stdout
+	default:
+		lw.w = stdout
+		defer log.Warn.Add("topic", "env", "action", "logdest", "logdest", logDest).Printf("unknown LOGDEST, using stdout")
+	}
+}
+
+// encodeJSON re-encodes a log line, keeping the key order and fixing
+// up the types of the keys in floatKeys and intKeys
+func encodeJSON(p []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(p))
+	dec.UseNumber()
+	if t, err := dec.Token(); err != nil || t != json.Delim('{') {
+		return nil, fmt.Errorf("not an object")
+	}
+	out := &bytes.Buffer{}
+	out.WriteByte('{')
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		key, _ := t.(string)
+		var v any
+		if err := dec.Decode(&v); err != nil {
+			return nil, err
+		}
+		switch {
+		case floatKeys[key]:
+			v = toNumber(v, 64)
+		case intKeys[key]:
+			v = toNumber(v, 0)
+		}
+		k, _ := json.Marshal(key)
+		val, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		if out.Len() > 1 {
+			out.WriteByte(',')
+		}
+		out.Write(k)
+		out.WriteByte(':')
+		out.Write(val)
+	}
+	out.WriteString("}\n")
+	return out.Bytes(), nil
+}
+
+// toNumber converts v, which may be a number or a formatted string, to a
+// float64 (bits=64) or an int64 (bits=0). It returns v if it isn't numeric.
+func toNumber(v any, bits int) any {
+	var s string
+	switch v := v.(type) {
+	case json.Number:
+		s = v.String()
+	case string:
+		s = v
+	default:
+		return v
+	}
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil && bits == 0 {
+		return n
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return v
+	}
+	if bits == 0 {
+		return int64(f)
+	}
+	return f
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/as/log"
+)
+
+func TestEncodeJSON(t *testing.T) {
+	for _, tt := range []struct {
+		in, want string
+	}{
+		{
+			`{"ts":1, "level":"info", "speed":"1.50", "q":28, "size":1024, "frame":"12", "msg":""}`,
+			`{"ts":1,"level":"info","speed":1.5,"q":28,"size":1024,"frame":12,"msg":""}`,
+		},
+		{
+			`{"bps":8.389e5, "fps":"N/A", "uptime":"3", "msg":"say \"hi\"\n"}`,
+			`{"bps":838900,"fps":"N/A","uptime":3,"msg":"say \"hi\"\n"}`,
+		},
+		{
+			`{"errors":["a","b"], "outputs":[{"file":"x","size":"1"}], "speed":null}`,
+			`{"errors":["a","b"],"outputs":[{"file":"x","size":"1"}],"speed":null}`,
+		},
+	} {
+		got, err := encodeJSON([]byte(tt.in))
+		if err != nil || string(got) != tt.want+"\n" {
+			t.Errorf("encodeJSON(%s)\n\thave %s, %v\n\twant %s", tt.in, got, err, tt.want)
+		}
+	}
+	for _, bad := range []string{"", "[1]", `{"a":`, "not json"} {
+		if _, err := encodeJSON([]byte(bad)); err == nil {
+			t.Errorf("encodeJSON(%q) didn't fail", bad)
+		}
+	}
+}
+
+// TestLogFormatJSON logs a job's worth of lines with LOGFORMAT=json.
+// Every line must parse, with numbers where the types say so.
+func TestLogFormatJSON(t *testing.T) {
+	buf := new(bytes.Buffer)
+	defer log.SetOutput(log.SetOutput(&logWriter{w: buf, json: true}))
+	defer resetParsed()
+	lines := "Input #0, mov,mp4,m4a,3gp,3g2,mj2, from 'in.mp4':\n" +
+		"frame=   25 fps=25 q=28.0 size=     100kB time=00:00:01.00 bitrate= 800.0kbits/s speed=1x\r" +
+		"frame=   50 fps=25 q=28.0 q=-1.0 size=     200KiB time=00:00:02.00 bitrate=N/A speed=1.02x\r" +
+		"[h264 @ 0x1] error while decoding MB 1 2, bytestream -5\n" +
+		"Error while \"filtering\": Invalid argument\n"
+	statc := make(chan State, 1)
+	go watchState(strings.NewReader(lines), statc, &Detected{})
+	drain(statc, State{})
+	s := State{}.Decode("frame=   50 fps=25 q=28.0 q=-1.0 size=     200KiB time=00:00:02.00 bitrate=N/A speed=1.02x")
+	log.Info.Add("topic", "status", "action", "update").Add(stateFields(s)...).Printf("")
+	log.Error.Add("topic", "summary", "action", "failed", "errors", []string{"a\tb"}).Printf("failed: %q", "x\ny")
+
+	logged := buf.String()
+	sc := bufio.NewScanner(buf)
+	n := 0
+	for sc.Scan() {
+		n++
+		var m map[string]any
+		if err := json.Unmarshal(sc.Bytes(), &m); err != nil {
+			t.Errorf("line %d doesn't parse: %v\n%s", n, err, sc.Bytes())
+			continue
+		}
+		for k := range floatKeys {
+			if v, ok := m[k]; ok {
+				if _, num := v.(float64); !num {
+					t.Errorf("line %d: %s is %T, want a number", n, k, v)
+				}
+			}
+		}
+	}
+	if n < 4 {
+		t.Fatalf("logged %d lines:\n%s", n, logged)
+	}
+}
+
+type fakeSyslog struct{ got string }
+
+func (f *fakeSyslog) Debug(string) error   { f.got = "debug"; return nil }
+func (f *fakeSyslog) Info(string) error    { f.got = "info"; return nil }
+func (f *fakeSyslog) Warning(string) error { f.got = "warning"; return nil }
+func (f *fakeSyslog) Err(string) error     { f.got = "err"; return nil }
+
+func TestPriority(t *testing.T) {
+	for _, tt := range []struct {
+		line, want string
+	}{
+		{`{"level":"info","topic":"status","action":"update"}`, "info"},
+		{`{"level":"fatal","topic":"summary","action":"failed"}`, "err"},
+		{`{"level":"info","topic":"summary","action":"done"}`, "info"},
+		{`{"level":"error","topic":"gpu","action":"alert"}`, "warning"},
+		{`{"level":"fatal","topic":"status","action":"stall"}`, "warning"},
+		{`{"level":"warn","topic":"env"}`, "warning"},
+		{`{"level":"error","topic":"ffmpeg"}`, "err"},
+		{`{"level":"debug"}`, "debug"},
+		{`not json`, "info"},
+	} {
+		f := &fakeSyslog{}
+		priority(f, []byte(tt.line))("")
+		if f.got != tt.want {
+			t.Errorf("priority(%s) = %s, want %s", tt.line, f.got, tt.want)
+		}
+	}
+}
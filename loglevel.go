@@ -0,0 +1,102 @@
+package main
+
+import (
+	"os"
+	"os/signal"
+	"strings"
+	"sync/atomic"
+
+	"github.com/as/log"
+)
+
+// logLevel is one of debug, info, warn or error. debug logs every line
+// read from ffmpeg, warn and error drop the periodic status updates but
+// keep alerts and summaries. default=info
+var logLevel = os.Getenv("LOGLEVEL")
+
+// quiet drops the periodic status updates (1) or logs them only when
+// the integer progress changes (progressonly). The watchdogs, alerts and
+// the start and summary lines are unaffected.
+var quiet = os.Getenv("QUIET")
+
+const (
+	levelDebug = iota
+	levelInfo
+	levelWarn
+	levelError
+)
+
+var levels = map[string]int32{"debug": levelDebug, "info": levelInfo, "warn": levelWarn, "warning": levelWarn, "error": levelError}
+
+// level is the current level, SIGUSR2 toggles it to and from debug
+var level int32
+
+// setLogLevel maps LOGLEVEL onto the log package and starts the
+// SIGUSR2 handler
+func setLogLevel() {
+	l, ok := levels[strings.ToLower(logLevel)]
+	if !ok {
+		if logLevel != "" {
+			log.Warn.Add("topic", "env", "action", "loglevel", "loglevel", logLevel).Printf("unknown LOGLEVEL, using info")
+		}
+		l = levelInfo
+	}
+	setLevel(l)
+	// the debug lines are gated on level, see debugOn. log.DebugOn is
+	// only ever set here, before anything logs from another goroutine.
+	log.DebugOn = true
+
+	if len(debugSignals) == 0 {
+		return
+	}
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, debugSignals...)
+	go func() {
+		prev := l
+		for range sig {
+			prev = toggleDebug(prev)
+			log.Warn.Add("topic", "env", "action", "loglevel", "debug", debugOn()).Printf("SIGUSR2: toggled debug logging")
+		}
+	}()
+}
+
+// toggleDebug switches to debug, or back to prev when already there. It
+// returns the level to go back to on the next toggle.
+func toggleDebug(prev int32) int32 {
+	if cur := atomic.LoadInt32(&level); cur != levelDebug {
+		setLevel(levelDebug)
+		return cur
+	}
+	setLevel(prev)
+	return prev
+}
+
+func setLevel(l int32) {
+	atomic.StoreInt32(&level, l)
+}
+
+// debugOn reports whether debug lines should be logged. Every log.Debug
+// line must check it first.
+func debugOn() bool {
+	return atomic.LoadInt32(&level) == levelDebug
+}
+
+var lastProgress = -1
+
+// logStatus returns true if the periodic status update for progress
+// should be logged
+func logStatus(progress int) bool {
+	if atomic.LoadInt32(&level) > levelInfo {
+		return false
+	}
+	switch quiet {
+	case "1", "true":
+		return false
+	case "progressonly":
+		if progress == lastProgress {
+			return false
+		}
+		lastProgress = progress
+	}
+	return true
+}
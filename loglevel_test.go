@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/as/log"
+)
+
+func TestLogStatus(t *testing.T) {
+	defer func(l int32, q string) { setLevel(l); quiet = q }(level, quiet)
+	for _, tt := range []struct {
+		level int32
+		quiet string
+		want  []bool // for progress 1, 1, 2
+	}{
+		{levelDebug, "", []bool{true, true, true}},
+		{levelInfo, "", []bool{true, true, true}},
+		{levelWarn, "", []bool{false, false, false}},
+		{levelError, "", []bool{false, false, false}},
+		{levelInfo, "1", []bool{false, false, false}},
+		{levelInfo, "progressonly", []bool{true, false, true}},
+		{levelWarn, "progressonly", []bool{false, false, false}},
+	} {
+		setLevel(tt.level)
+		quiet, lastProgress = tt.quiet, -1
+		for i, p := range []int{1, 1, 2} {
+			if got := logStatus(p); got != tt.want[i] {
+				t.Errorf("level %d quiet %q: logStatus(%d) #%d = %v, want %v", tt.level, tt.quiet, p, i, got, tt.want[i])
+			}
+		}
+	}
+}
+
+func TestToggleDebug(t *testing.T) {
+	defer func(l int32) { setLevel(l) }(level)
+	for _, from := range []int32{levelInfo, levelWarn, levelError} {
+		setLevel(from)
+		prev := toggleDebug(from)
+		if !debugOn() || prev != from {
+			t.Fatalf("toggle from %d: debug %v, prev %d", from, debugOn(), prev)
+		}
+		toggleDebug(prev)
+		if debugOn() || level != from {
+			t.Fatalf("toggle back: level %d, want %d", level, from)
+		}
+	}
+}
+
+// watchLines runs lines of ffmpeg stderr through watchState and returns what
+// it logged
+func watchLines(lines string) string {
+	buf := new(bytes.Buffer)
+	defer log.SetOutput(log.SetOutput(buf))
+	statc := make(chan State, 1)
+	go watchState(strings.NewReader(lines), statc, &Detected{})
+	drain(statc, State{})
+	return buf.String()
+}
+
+func TestDebugLines(t *testing.T) {
+	defer func(l int32, on bool) { setLevel(l); log.DebugOn = on }(level, log.DebugOn)
+	log.DebugOn = true
+	line := "frame=1 fps=25 size=1kB time=00:00:00.04 bitrate=1.0kbits/s speed=1x\r"
+	for _, tt := range []struct {
+		level int32
+		want  bool
+	}{
+		{levelDebug, true},
+		{levelInfo, false},
+		{levelWarn, false},
+		{levelError, false},
+	} {
+		setLevel(tt.level)
+		if got := strings.Contains(watchLines(line), "watch: state"); got != tt.want {
+			t.Errorf("level %d: debug line logged %v, want %v", tt.level, got, tt.want)
+		}
+	}
+}
+
+// TestToggleRace toggles debug logging the way SIGUSR2 does while
+// watchState logs, run it with -race
+func TestToggleRace(t *testing.T) {
+	defer func(l int32, on bool) { setLevel(l); log.DebugOn = on }(level, log.DebugOn)
+	log.DebugOn = true
+	lines := strings.Repeat("frame=1 fps=25 size=1kB time=00:00:00.04 bitrate=1.0kbits/s speed=1x\r", 200)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		prev := int32(levelInfo)
+		for i := 0; i < 100; i++ {
+			prev = toggleDebug(prev)
+		}
+	}()
+	watchLines(lines)
+	wg.Wait()
+}
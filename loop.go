@@ -0,0 +1,457 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/as/ffmpeg-json/ffmpegjson"
+	"github.com/as/log"
+)
+
+// Loop is the main loop. It watches the States from watchState, runs the
+// watchdogs every tick and logs the summary once ffmpeg is done. main
+// wires it to ffmpeg, the tests to channels of their own.
+type Loop struct {
+	args  []string         // the wrapper's argv, rewritten before a retry
+	donec <-chan error     // ffmpeg's result, see runPasses
+	statc <-chan State     // closed by watchState after the last State
+	tick  <-chan time.Time // the status and watchdog interval
+
+	stopTick  func()              // stops tick
+	unforward func()              // stops forwarding signals to ffmpeg
+	kill      func()              // stops ffmpeg's process group
+	retry     func(args []string) // re-executes the wrapper, see reexec
+
+	// status logs the periodic status line. It has the library's hook
+	// signature, see ffmpegjson/notify.go
+	status ffmpegjson.ProgressFunc
+
+	fd2      *os.File // ffmpeg's stderr as written
+	det      *Detected
+	launched time.Time
+
+	metrics   *Metrics
+	statsd    *StatsD
+	webhook   *Webhook
+	progfile  *ProgressFile
+	sock      *StatusSocket
+	heartbeat *Heartbeat
+
+	wd       *Watchdog
+	win      *Window
+	hist     *History
+	trend    *Trend
+	delta    *Delta
+	band     *BitrateBand
+	drift    *Drift
+	changes  *Changes
+	repeats  *Repeats
+	latency  *Latency
+	overtime *Overtime
+	disk     *DiskMonitor
+	outwatch *OutputWatch
+	rss      *RSSGuard
+	cpu      *CPUUsage
+	gpus     *GPUSampler
+	health   *Health
+
+	prior              State
+	nstall, nslow      int
+	slowbound, stopped string
+	psample            ProcSample
+	gpujob, working    bool
+	hwchecked          bool
+}
+
+// newLoop returns a Loop for args with its sinks and monitors set up
+// from the environment. The caller connects the channels and funcs.
+func newLoop(ctx context.Context, args []string, det *Detected, launched time.Time) *Loop {
+	l := &Loop{
+		args:      args,
+		det:       det,
+		launched:  launched,
+		stopTick:  func() {},
+		unforward: func() {},
+		kill:      func() {},
+		retry:     reexec,
+		metrics:   serveMetrics(det),
+		statsd:    dialStatsD(),
+		webhook:   startWebhook(),
+		progfile:  openProgressFile(),
+		sock:      listenStatus(),
+		heartbeat: newHeartbeat(),
+		gpujob:    usesGPU(args),
+		wd:        NewWatchdog(),
+		win:       NewWindow(window),
+		hist:      &History{},
+		trend:     NewTrend(),
+		delta:     NewDelta(launched),
+		band:      &BitrateBand{},
+		drift:     NewDrift(args[1:]),
+		changes:   newChanges(),
+		repeats:   &Repeats{},
+		latency:   NewLatency(procstart),
+		overtime:  NewOvertime(firstStart),
+		disk:      NewDiskMonitor(args[1:]),
+		outwatch:  NewOutputWatch(args[1:]),
+		rss:       NewRSSGuard(maxRSS),
+		cpu:       &CPUUsage{},
+		gpus:      startGPUSampler(ctx, args[1:]),
+	}
+	if isLive(args) {
+		l.health = NewHealth(loadPolicy())
+	}
+	l.status = l.logStatus
+	return l
+}
+
+// Run runs until ffmpeg is done. It returns after logging the done
+// summary, a failure exits through fatal or retry.
+func (l *Loop) Run() {
+	if perc := progress(l.prior); logStatus(perc) {
+		log.Info.Add("topic", "status", "action", "update", "progress", perc, "progress_reset", progressReset()).Add(stateFields(l.prior)...).Printf("")
+	}
+	for l.donec != nil {
+		select {
+		case err := <-l.donec:
+			l.done(err)
+		case current, more := <-l.statc:
+			if !more {
+				// no more States, but the summary still waits for ffmpeg
+				l.statc = nil
+				continue
+			}
+			l.observe(current)
+		case <-l.tick:
+			l.check()
+		}
+	}
+}
+
+// summary returns the fields shared by the done and failed summaries
+func (l *Loop) summary() (kv []any) {
+	// size_unit tells dashboards that size is in bytes, not kB
+	kv = append(kv, "size_unit", "bytes", "final", avail(l.prior.Final, true))
+	kv = append(kv, ffversion.Fields()...)
+	kv = append(kv, "bound", l.slowbound, "seed", seed, "outputs", outputStatus(), "stopped", l.stopped)
+	kv = append(kv, "gpu_throttled", avail(l.gpus.Throttled(), true))
+	kv = append(kv, "fallback", fallback)
+	kv = append(kv, "extra_hw_frames", avail(hwframes > 0, hwframes))
+	kv = append(kv, l.latency.Summary(time.Now())...)
+	hw := hwaccelEffective(l.args)
+	kv = append(kv, "hwaccel_effective", avail(hw != "", hw))
+	kv = append(kv, l.cpu.Summary()...)
+	kv = append(kv, l.hist.Summary()...)
+	kv = append(kv, concatFields()...)
+	kv = append(kv, l.health.Fields()...)
+	kv = append(kv, parseFields()...)
+	kv = append(kv, passFields()...)
+	return append(kv, segmentFields()...)
+}
+
+// publish sends the state to the metrics and status sinks, which
+// don't depend on whether the status line is logged
+func (l *Loop) publish(s State, perc int) {
+	l.metrics.Set(s, perc, l.nstall)
+	l.statsd.Gauges(s, perc)
+	l.webhook.Update(s, perc)
+	l.progfile.Update(s, perc)
+	l.sock.Update(s, perc)
+}
+
+func (l *Loop) logStatus(s State, p float64) {
+	perc := int(math.Round(p * 100))
+	l.changes.Logged(s, perc, time.Now())
+	if logStatus(perc) && !l.repeats.Skip(s, perc, time.Now()) {
+		log.Info.Add("topic", "status", "action", "update", "progress", perc, "progress_reset", progressReset(), "health", l.health.Value()).Add(stateFields(s)...).Add(l.win.Fields()...).Add(segmentFields()...).Add(l.gpus.Fields()...).Add(l.rss.Fields()...).Add(l.cpu.Fields()...).Add(l.drift.Fields()...).Add(l.delta.Fields(s, time.Now())...).Add("outputs", outputStatus()).Printf("")
+	}
+}
+
+// done handles ffmpeg's result: the done summary, a retry or the failed
+// summary
+func (l *Loop) done(err error) {
+	// NOTE(as): The summary must be the last event. Stop the ticker so no
+	// stale status follows it, and drain statc so the final State and the
+	// error flags set by watchState are complete before we look at them.
+	l.stopTick()
+	l.unforward()
+	if l.statc != nil {
+		l.prior = drain(l.statc, l.prior)
+	}
+	l.donec, l.statc, l.tick = nil, nil, nil
+
+	l.fd2.Seek(0, 0)
+	logdata := new(bytes.Buffer)
+	io.Copy(logdata, l.fd2)
+
+	det, prior := l.det, l.prior
+	lasterr := redact(lastline(logdata))
+	if err == nil && lasterr != "" && !det.Any() {
+		// Sometimes ffmpeg will emit errors that appear to be fatal but aren't. Failing on these
+		// types of outputs is detrimental. For example, the PCM decoder can emit errors that
+		// look fatal, but ffmpeg will return a zero exit code because an error threshold wasn't reached
+		//err = fmt.Errorf("ffmpeg failed")
+		lasterr = strings.Join(det.Errors(), "\n")
+		if tolerate {
+			log.Warn.Add("topic", "status", "errors", det.Errors()).Printf("non fatal error: %s", lasterr)
+		} else {
+			err = fmt.Errorf("ffmpeg: zero exit code but parsed fatal error: %s", lasterr)
+			log.Error.Add("topic", "status").Printf("%s", lasterr)
+		}
+	}
+	var probes []Probe
+	if err == nil && verifyOutput {
+		if probes, err = verifyOutputs(l.args[1:]); err != nil {
+			log.Error.Add("topic", "summary", "action", "verify_failed", "probes", probes, "err", err).Printf("output verification failed")
+		}
+	}
+	var sums []map[string]any
+	if err == nil && checksumAlg != "" {
+		sums = checksumOutputs(l.args[1:])
+	}
+	if err == nil {
+		l.publish(prior, 100)
+		outcome = "done"
+		log.Info.Add("topic", "summary", "action", "done", "progress", 100, "uptime", time.Since(procstart).Seconds()).Add(stateFields(prior)...).Add(l.summary()...).Add(muxFields()...).Add(l.gpus.Summary()...).Add("probes", avail(len(probes) > 0, probes), "output_files", avail(len(sums) > 0, sums)).Printf("done")
+		return
+	}
+
+	code, sig := exitInfo(err)
+	setExitStatus(code, sig)
+	class, classline, classified := det.Failure()
+	if classified && class.Exit != 0 && sig <= 0 {
+		exitStatus = class.Exit
+	}
+	failed := func() {
+		errorClass = class.Class
+		fatal(log.Fatal.Add("topic", "summary", "action", "failed", "err", err, "progress", -100, "error_class", class.Class,
+			"ffmpeg_exit", avail(code >= 0, code), "ffmpeg_signal", avail(sig > 0, sig), "errors", det.Errors(),
+			"disk", avail(class.Class == "disk_full", diskFields(l.args[1:])), "input", redact(badInput(classline)),
+		).Add(l.summary()...), "failed: %q", lasterr)
+	}
+	if class.Permanent {
+		failed()
+	}
+	if det.FilterBug && fixFilters(l.args) {
+		log.Error.Add("topic", "gpu", "action", "alert", "vendor", lastVendor(), "subject", "filterbug", "details", "gpu filter bug",
+			"retry", retry, "maxretry", maxretry, "err", err,
+		).Printf("filterbug")
+		l.retry(l.args)
+	}
+	if det.Session && retry < maxretry {
+		log.Warn.Add("topic", "gpu", "action", "alert", "vendor", "nvidia", "subject", "session_limit", "details", "nvenc session limit reached",
+			"sessions", avail(gpuSessions() >= 0, gpuSessions()), "retry", retry, "maxretry", maxretry, "err", err,
+		).Printf("waiting for an nvenc session: %q", lasterr)
+		waited, freed := waitSession()
+		log.Info.Add("topic", "gpu", "action", "session_wait", "waited", waited.Seconds(), "freed", freed).Printf("retry after session wait")
+		l.retry(l.args)
+	}
+	if det.HWFrames || det.VRAM && hasFlag(l.args, "-extra_hw_frames") {
+		// NOTE(as): HWFRAMES2
+		// This is a dirty hack to restart the process created out of necessity. The argument is adjusted and ffmpeg-json
+		// re-executes itself. This clobbers all state in the current process, but we haven't done much work anyway.
+		// Too few surfaces grows it, a gpu OOM shrinks it, see hwframes.go:/hwframesMove/
+		//
+		// Finally, see detect.go:/HWFRAMES3/ for the detection logic
+		old, _ := strconv.Atoi(flagValue(l.args, "-extra_hw_frames"))
+		next, dir, ok := hwframesMove(hwframesDir, det.HWFrames, det.VRAM, old)
+		if ok && dir == "up" {
+			l.args, old, next, ok = growHWFrames(l.args)
+		} else if ok {
+			l.args = setHWFrames(l.args, next)
+		}
+		ln := log.Error.Add("topic", "gpu", "action", "alert", "vendor", lastVendor(), "subject", "retry", "details", "extra_hw_frames",
+			"old", old, "new", next, "direction", dir, "max", hwframesmax, "min", avail(hwframesMin > 0, hwframesMin),
+		)
+		if ok {
+			hwframes = next
+			os.Setenv(hwframesDirEnv, dir)
+			ln.Printf("adjust extra_hw_frames and retry")
+			l.retry(l.args)
+		}
+		ln.Printf("extra_hw_frames can't be adjusted any further")
+	}
+	if det.VRAM {
+		ln := log.Error.Add(
+			"topic", "gpu", "action", "alert", "vendor", lastVendor(), "subject", "oom", "details", "gpu note out of vram",
+			"retry", retry, "maxretry", maxretry, "err", err, "gpu_mem_history", avail(len(l.gpus.History()) > 0, l.gpus.History()),
+		)
+		if retry < maxretry {
+			ln.Printf("retry: gpu OOM: %q", lasterr)
+			time.Sleep(2 * time.Second)
+			l.retry(l.args)
+		}
+		if !gpuFallback || fallback != "" {
+			fatal(ln.Fatal(), "max retry reached: gpu OOM: %q", lasterr)
+		}
+		ln.Printf("max retry reached: gpu OOM: %q", lasterr)
+	}
+	if det.Transient && cudaJob(l.args) && retry < maxretry {
+		backoff := retryBackoff(retry)
+		log.Error.Add("topic", "gpu", "action", "alert", "vendor", lastVendor(), "subject", "transient", "details", "gpu library error",
+			"line", det.Line("transient"), "retry", retry, "maxretry", maxretry, "backoff", backoff.Seconds(), "err", err,
+		).Printf("retry: transient gpu error: %q", lasterr)
+		time.Sleep(backoff)
+		l.retry(l.args)
+	}
+	if det.QSV && !det.QSVFatal && retry < maxretry {
+		backoff := retryBackoff(retry)
+		log.Error.Add("topic", "gpu", "action", "alert", "vendor", "intel", "subject", "qsv", "details", "quick sync session or device busy",
+			"retry", retry, "maxretry", maxretry, "backoff", backoff.Seconds(), "err", err,
+		).Printf("retry: qsv: %q", lasterr)
+		time.Sleep(backoff)
+		l.retry(l.args)
+	}
+	if det.QSVFatal {
+		log.Error.Add("topic", "gpu", "action", "alert", "vendor", "intel", "subject", "qsv_unsupported", "details", "quick sync can't encode or decode this, not retrying").Printf("qsv: %q", lasterr)
+	}
+	if gpuFallback && fallback == "" && (det.VRAM || det.HWFrames || det.Session || det.QSV || det.QSVFatal) {
+		args, changes, ferr := cpuFallback(l.args[1:])
+		ln := log.Error.Add("topic", "gpu", "action", "fallback", "vendor", lastVendor(), "changes", changes, "err", ferr)
+		if ferr == nil {
+			ln.Printf("gpu retries exhausted, running on the cpu")
+			l.args = append(l.args[:1:1], args...)
+			os.Setenv(fallbackEnv, "cpu")
+			l.retry(l.args)
+		}
+		ln.Printf("cant fall back to the cpu")
+	}
+	if sig == sigKill && !det.Any() && atomic.LoadInt32(&killed) == 0 {
+		log.Error.Add("topic", "host", "action", "alert", "subject", "host_oom", "details", "ffmpeg killed without gpu errors, likely the oom killer").Printf("ffmpeg killed by signal %d", sig)
+	}
+	failed()
+}
+
+// observe runs the per-State watchdogs on current
+func (l *Loop) observe(current State) {
+	l.wd.Observe(current)
+	l.latency.Observe(current, time.Now())
+	if !l.hwchecked && current.Frame > 0 {
+		l.hwchecked = true
+		if checkHWAccel(l.args) {
+			l.kill()
+			exitStatus, errorClass = exitHWAccel, "sw_fallback"
+			fatal(log.Fatal.Add("topic", "summary", "action", "failed", "error_class", "sw_fallback", "progress", -100).Add(l.summary()...), "HWACCEL_STRICT: ffmpeg fell back to software decoding")
+		}
+	}
+	l.working = l.working || started(current)
+	l.overtime.Arm(current, time.Now())
+	l.heartbeat.Beat(current)
+	if limit, kind := l.wd.DupLimit(); limit > 0 && current.Dup >= limit {
+		l.kill()
+		fatal(log.Fatal.Add("topic", "dup", "frames", current.Dup, "limit", limit, "threshold", kind, "basis", l.wd.Basis(), "fatal", true), "freeze detected")
+	}
+	l.nstall = ffmpegjson.Stalls(l.nstall, l.prior, current)
+	l.prior = current
+	if maxstall > 0 && l.nstall > maxstall {
+		l.kill()
+		fatal(log.Fatal.Add("topic", "status", "action", "stall", "subject", "maxstall", "frame", current.Frame, "threshold", "static"), "stalled on frame %d after %d updates", current.Frame, l.nstall)
+	}
+	if perc := progress(current); l.changes.Due(current, perc, time.Now()) {
+		l.status(current, float64(perc)/100)
+	}
+}
+
+// check runs the periodic watchdogs and logs the status line
+func (l *Loop) check() {
+	prior := l.prior
+	if l.wd.Stalled() {
+		l.kill()
+		fatal(log.Fatal.Add("topic", "status", "action", "stall", "frame", prior.Frame, "threshold", "derived", "stall_after", l.wd.StallAfter.Seconds(), "basis", l.wd.Basis()), "stalled on frame %d", prior.Frame)
+	}
+	if limit, elapsed, basis, over := l.overtime.Check(time.Now()); over {
+		l.kill()
+		fatal(log.Fatal.Add("topic", "status", "action", "overtime", "cap", limit.Seconds(), "elapsed", elapsed.Seconds(), "basis", basis, "factor", avail(basis == "factor", maxRuntimeFactor), "speed", prior.Speed), "still running after %s", elapsed.Round(time.Second))
+	}
+	if d, over := preStalled(prior, time.Now()); over {
+		l.kill()
+		fatal(log.Fatal.Add("topic", "status", "action", "stall", "subject", "prestall", "frame", 0, "waited", d.Seconds(), "threshold", preStall.Seconds(), "stderr", tailFile(l.fd2, 10)), "no first frame %s after the first status line", d.Round(time.Second))
+	}
+	if !l.working && startTimeout > 0 && time.Since(l.launched) > startTimeout {
+		l.kill()
+		retryable := networkInput(l.args[1:]) && retry < maxretry
+		ln := log.Error.Add("topic", "status", "action", "start_timeout", "timeout", startTimeout.Seconds(), "retryable", retryable,
+			"retry", retry, "maxretry", maxretry, "stderr", tailFile(l.fd2, 10))
+		if retryable {
+			ln.Printf("no progress since start, retrying")
+			time.Sleep(retryBackoff(retry))
+			l.retry(l.args)
+		}
+		fatal(ln.Fatal(), "no progress since start")
+	}
+	if n, bad := badKey(l.args, l.det, time.Since(l.launched)); bad {
+		l.kill()
+		exitStatus, errorClass = exitDecrypt, "decrypt"
+		fatal(log.Fatal.Add("topic", "summary", "action", "failed", "error_class", "decrypt", "progress", -100, "decode_errors", n, "window", decryptWindow.Seconds(), "errors", l.det.Errors()).Add(l.summary()...), "cant decrypt the input, wrong key?")
+	}
+	if l.outwatch.Stalled(prior) {
+		l.kill()
+		fatal(log.Fatal.Add("topic", "status", "action", "output_stalled", "frame", prior.Frame).Add(l.outwatch.Fields()...), "outputs stopped growing while frames advanced")
+	}
+	if !checkParse() {
+		l.kill()
+		errorClass = "parse_failure"
+		fatal(log.Fatal.Add("topic", "summary", "action", "failed", "error_class", "parse_failure", "progress", -100, "samples", parseSamples()).Add(parseFields()...), "cant parse ffmpeg status lines")
+	}
+	if err := l.disk.Check(); err != nil && l.stopped == "" {
+		l.stopped = "lowspace"
+		log.Error.Add("topic", "disk", "action", "stop", "err", err).Printf("stopping ffmpeg before the disk fills")
+		interrupt()
+	}
+	if l.rss.Check(child()) {
+		// a second tick over the limit means ffmpeg didn't stop on the interrupt
+		if l.stopped == "oom_guard" {
+			l.kill()
+		}
+		if l.stopped == "" {
+			l.stopped = "oom_guard"
+			log.Error.Add("topic", "host", "action", "oom_guard", "limit", maxRSS).Add(l.rss.Fields()...).Printf("stopping ffmpeg before the oom killer does")
+			interrupt()
+		}
+	}
+	sample, _ := sampleProc(child())
+	if minspeed > 0 && prior.Frame > 0 && prior.Speed < minspeed {
+		l.nslow++
+	} else {
+		l.nslow = 0
+	}
+	if l.nslow >= 3 && (l.nslow-3)%10 == 0 {
+		l.slowbound = bound(l.psample, sample, l.gpujob)
+		log.Warn.Add("topic", "status", "action", "slow", "speed", prior.Speed, "minspeed", minspeed, "bound", l.slowbound).Printf("speed below minimum for %d updates", l.nslow)
+	}
+	l.cpu.Add(l.psample, sample)
+	l.psample = sample
+	if l.health != nil {
+		l.health.Update(prior)
+	}
+	l.win.Add(prior)
+	l.hist.Add(prior)
+	l.drift.Check(prior)
+	if dir := l.band.Check(time.Now(), prior); dir != "" {
+		ln := log.Warn.Add("topic", "status", "action", "bitrate", "subject", dir).Add(l.band.Fields()...)
+		if bitrateAction == "kill" {
+			l.kill()
+			fatal(ln.Fatal(), "bitrate too %s", dir)
+		}
+		ln.Printf("bitrate too %s", dir)
+	}
+	if l.trend.Add(time.Now(), prior) {
+		ln := log.Warn.Add("topic", "status", "action", "degrading").Add(l.trend.Fields()...)
+		if degradeAction == "kill" {
+			l.kill()
+			fatal(ln.Fatal(), "throughput keeps degrading")
+		}
+		ln.Printf("throughput keeps degrading")
+	}
+	perc := progress(prior)
+	l.publish(prior, perc)
+	l.status(prior, float64(perc)/100)
+}
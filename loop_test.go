@@ -0,0 +1,349 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/as/log"
+)
+
+// events records what a Loop logged and when it killed ffmpeg, in order
+type events struct {
+	sync.Mutex
+	list  []string         // topic/action of each line, or kill
+	lines []map[string]any // the lines as logged, nil for a kill
+}
+
+func (e *events) Write(p []byte) (int, error) {
+	for _, line := range bytes.Split(bytes.TrimSpace(p), []byte("\n")) {
+		var m map[string]any
+		if err := json.Unmarshal(line, &m); err != nil {
+			m = map[string]any{"raw": string(line)}
+		}
+		e.add(fmt.Sprintf("%v/%v", m["topic"], m["action"]), m)
+	}
+	return len(p), nil
+}
+
+func (e *events) add(ev string, line map[string]any) {
+	e.Lock()
+	defer e.Unlock()
+	e.list = append(e.list, ev)
+	e.lines = append(e.lines, line)
+}
+
+// count returns how many times ev happened
+func (e *events) count(ev string) (n int) {
+	for _, v := range e.list {
+		if v == ev {
+			n++
+		}
+	}
+	return n
+}
+
+// last returns the last event and its line
+func (e *events) last() (string, map[string]any) {
+	if len(e.list) == 0 {
+		return "", nil
+	}
+	return e.list[len(e.list)-1], e.lines[len(e.lines)-1]
+}
+
+// index returns where ev first happened, or -1
+func (e *events) index(ev string) int {
+	for i, v := range e.list {
+		if v == ev {
+			return i
+		}
+	}
+	return -1
+}
+
+// testLoop returns a Loop that records its events instead of killing or
+// retrying anything
+func testLoop(t *testing.T) (*Loop, *events) {
+	t.Helper()
+	fd2, err := os.Create(filepath.Join(t.TempDir(), "stderr"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { fd2.Close() })
+	ev := &events{}
+	old := log.SetOutput(ev)
+	t.Cleanup(func() { log.SetOutput(old) })
+
+	restore(t, &exitStatus)
+	restore(t, &outcome, &errorClass)
+	l := newLoop(context.Background(), []string{"ffmpeg-json", "-i", "in.mp4", "out.mp4"}, &Detected{}, time.Now())
+	l.fd2 = fd2
+	l.kill = func() { ev.add("kill", nil) }
+	l.retry = func(args []string) { t.Errorf("unexpected retry with %q", args) }
+	return l, ev
+}
+
+// never is ffmpeg's result when it's still running
+var never = errors.New("still running")
+
+// runLoop queues states and ticks, then gives l ffmpeg's result err and
+// closes statc the way runPasses and watchState do. It returns the
+// panic value of a fatal exit, or nil when Run returned.
+func runLoop(t *testing.T, l *Loop, err error, ticks int, states ...State) (exit any) {
+	t.Helper()
+	statc := make(chan State, len(states)+1)
+	for _, s := range states {
+		statc <- s
+	}
+	tick := make(chan time.Time, ticks)
+	for i := 0; i < ticks; i++ {
+		tick <- time.Now()
+	}
+	donec := make(chan error)
+	l.statc, l.donec, l.tick = statc, donec, tick
+	if err != never {
+		go func() {
+			donec <- err
+			close(statc)
+		}()
+	}
+
+	result := make(chan any, 1)
+	go func() {
+		defer func() { result <- recover() }()
+		l.Run()
+	}()
+	select {
+	case exit = <-result:
+		return exit
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run didn't finish")
+	}
+	return nil
+}
+
+func frames(n ...int) (s []State) {
+	for _, n := range n {
+		s = append(s, State{Frame: n, FPS: 25, Size: int64(n) * 1000, Speed: 1, N: 1})
+	}
+	return s
+}
+
+func setMaxstall(t *testing.T, n int) {
+	restore(t, &maxstall)
+	maxstall = n
+}
+
+func TestLoopDone(t *testing.T) {
+	l, ev := testLoop(t)
+	if exit := runLoop(t, l, nil, 3, frames(1, 2, 3)...); exit != nil {
+		t.Fatalf("Run exited with %v, events %q", exit, ev.list)
+	}
+	if ev.list[0] != "status/update" {
+		t.Errorf("first event %q, want the initial status/update", ev.list[0])
+	}
+	last, line := ev.last()
+	if last != "summary/done" || ev.count("summary/done") != 1 {
+		t.Fatalf("events %q, want one summary/done last", ev.list)
+	}
+	if line["frame"] != 3.0 {
+		t.Errorf("summary frame %v, want the last State's 3", line["frame"])
+	}
+	if ev.count("kill") != 0 {
+		t.Errorf("killed ffmpeg on success: %q", ev.list)
+	}
+	if outcome != "done" {
+		t.Errorf("outcome %q, want done", outcome)
+	}
+}
+
+func TestLoopFailed(t *testing.T) {
+	l, ev := testLoop(t)
+	exit := runLoop(t, l, errors.New("exit status 1"), 2, frames(1, 2)...)
+	if exit != (fatalExit{}) {
+		t.Fatalf("Run exited with %v, want fatalExit", exit)
+	}
+	last, line := ev.last()
+	if last != "summary/failed" || ev.count("summary/failed") != 1 || ev.count("summary/done") != 0 {
+		t.Fatalf("events %q, want one summary/failed last", ev.list)
+	}
+	if line["progress"] != -100.0 || line["err"] != "exit status 1" {
+		t.Errorf("summary %v, want progress -100 and ffmpeg's error", line)
+	}
+}
+
+func TestLoopWatchdog(t *testing.T) {
+	setMaxstall(t, 2)
+	l, ev := testLoop(t)
+	exit := runLoop(t, l, never, 0, frames(1, 5, 5, 5, 5)...)
+	if exit != (fatalExit{}) {
+		t.Fatalf("Run exited with %v, want fatalExit", exit)
+	}
+	last, line := ev.last()
+	if last != "status/stall" || line["subject"] != "maxstall" {
+		t.Fatalf("events %q, want status/stall maxstall last", ev.list)
+	}
+	if k := ev.index("kill"); k < 0 || k != len(ev.list)-2 {
+		t.Errorf("events %q, want kill right before the stall", ev.list)
+	}
+	if ev.count("summary/done") != 0 {
+		t.Errorf("events %q, stall logged a done summary", ev.list)
+	}
+}
+
+// TestLoopCancel is a job stopped from outside: runPasses returns the
+// context's error and the States still queued are drained first
+func TestLoopCancel(t *testing.T) {
+	l, ev := testLoop(t)
+	exit := runLoop(t, l, context.Canceled, 0, frames(1, 2, 3, 4)...)
+	if exit != (fatalExit{}) {
+		t.Fatalf("Run exited with %v, want fatalExit", exit)
+	}
+	last, line := ev.last()
+	if last != "summary/failed" || ev.count("summary/failed") != 1 {
+		t.Fatalf("events %q, want one summary/failed last", ev.list)
+	}
+	if line["err"] != context.Canceled.Error() {
+		t.Errorf("summary %v, want the context error", line)
+	}
+	if l.prior.Frame != 4 {
+		t.Errorf("summary after frame %d, want the last queued 4", l.prior.Frame)
+	}
+	if i := ev.index("status/update"); i > ev.index("summary/failed") {
+		t.Errorf("events %q, status after the summary", ev.list)
+	}
+}
+
+// TestLoopEarlyClose has watchState finish before ffmpeg's result is in.
+// The summary still waits for it.
+func TestLoopEarlyClose(t *testing.T) {
+	l, ev := testLoop(t)
+	statc, donec := make(chan State, 2), make(chan error)
+	statc <- frames(7)[0]
+	close(statc)
+	l.statc, l.donec, l.tick = statc, donec, nil
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		donec <- nil
+	}()
+	l.Run()
+	if last, line := ev.last(); last != "summary/done" || line["frame"] != 7.0 {
+		t.Fatalf("events %q, want summary/done at frame 7 last", ev.list)
+	}
+}
+
+// TestLoopCounterReset is a retry or the next pass starting over, which
+// isn't a stall
+func TestLoopCounterReset(t *testing.T) {
+	setMaxstall(t, 2)
+	l, ev := testLoop(t)
+	if exit := runLoop(t, l, nil, 0, frames(900, 900, 900, 1, 1, 1, 2)...); exit != nil {
+		t.Fatalf("Run exited with %v, events %q", exit, ev.list)
+	}
+	if last, _ := ev.last(); last != "summary/done" || ev.count("status/stall") != 0 {
+		t.Fatalf("events %q, want no stall", ev.list)
+	}
+}
+
+func resetParsed() {
+	parsed.Lock()
+	defer parsed.Unlock()
+	parsed.seen, parsed.ok, parsed.bad, parsed.samples = 0, 0, 0, nil
+	parsed.warned, parsed.first, parsed.video = false, time.Time{}, false
+}
+
+func TestLoopPrestall(t *testing.T) {
+	defer func(d time.Duration) { preStall = d; resetParsed() }(preStall)
+	preStall = time.Millisecond
+	resetParsed()
+	noteParse("frame=    0 fps=0.0 q=0.0 size=       0kB time=N/A bitrate=N/A speed=N/A", State{}, 0)
+	time.Sleep(5 * time.Millisecond)
+
+	l, ev := testLoop(t)
+	exit := runLoop(t, l, never, 1, State{N: 1})
+	if exit != (fatalExit{}) {
+		t.Fatalf("Run exited with %v, want fatalExit", exit)
+	}
+	last, line := ev.last()
+	if last != "status/stall" || line["subject"] != "prestall" || line["frame"] != 0.0 {
+		t.Fatalf("events %q, want status/stall prestall last", ev.list)
+	}
+	if k := ev.index("kill"); k != len(ev.list)-2 {
+		t.Errorf("events %q, want kill right before the stall", ev.list)
+	}
+}
+
+func TestLoopPrestallArmed(t *testing.T) {
+	defer func(d time.Duration) { preStall = d; resetParsed() }(preStall)
+	preStall = time.Millisecond
+	resetParsed()
+	noteParse("frame=    1 fps=0.0 q=0.0 size=       1kB time=00:00:00.04 bitrate=N/A speed=N/A", State{Frame: 1}, 0)
+	time.Sleep(5 * time.Millisecond)
+
+	l, ev := testLoop(t)
+	l.prior = frames(1)[0]
+	if exit := runLoop(t, l, nil, 2, frames(2)...); exit != nil {
+		t.Fatalf("frames past zero: Run exited with %v, events %q", exit, ev.list)
+	}
+}
+
+// TestLoopQuiet checks QUIET=1 only drops the status lines, not the
+// watchdogs or the summary
+func TestLoopQuiet(t *testing.T) {
+	defer func(q string) { quiet = q }(quiet)
+	quiet = "1"
+
+	l, ev := testLoop(t)
+	if exit := runLoop(t, l, nil, 3, frames(1, 2, 3)...); exit != nil {
+		t.Fatalf("Run exited with %v", exit)
+	}
+	if n := ev.count("status/update"); n != 0 {
+		t.Errorf("QUIET=1 logged %d status lines: %q", n, ev.list)
+	}
+	if last, _ := ev.last(); last != "summary/done" {
+		t.Errorf("events %q, want summary/done last", ev.list)
+	}
+
+	setMaxstall(t, 2)
+	l, ev = testLoop(t)
+	if exit := runLoop(t, l, never, 2, frames(5, 5, 5, 5)...); exit != (fatalExit{}) {
+		t.Fatalf("QUIET=1 stall: Run exited with %v, want fatalExit", exit)
+	}
+	if last, _ := ev.last(); last != "status/stall" || ev.count("kill") != 1 {
+		t.Errorf("QUIET=1 events %q, want a kill and status/stall", ev.list)
+	}
+}
+
+// TestLoopStatusHook counts the calls of the status hook, the same
+// ProgressFunc the library calls
+func TestLoopStatusHook(t *testing.T) {
+	l, _ := testLoop(t)
+	var calls []State
+	l.status = func(s State, p float64) { calls = append(calls, s) }
+	statc, donec, tick := make(chan State, 1), make(chan error), make(chan time.Time)
+	l.statc, l.donec, l.tick = statc, donec, tick
+	finished := make(chan bool)
+	go func() {
+		l.Run()
+		close(finished)
+	}()
+	for i, s := range frames(10, 20, 30) {
+		statc <- s
+		tick <- time.Now()
+		if i == 2 {
+			donec <- nil
+			close(statc)
+		}
+	}
+	<-finished
+	if len(calls) != 3 || calls[2].Frame != 30 {
+		t.Fatalf("status hook called %d times, last with %+v, want 3 ending at frame 30", len(calls), calls)
+	}
+}
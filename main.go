@@ -4,7 +4,6 @@ package main
 
 import (
 	"bufio"
-	"bytes"
 	"context"
 	"fmt"
 	"io"
@@ -13,7 +12,6 @@ import (
 	"os/exec"
 	"regexp"
 	"strconv"
-	"strings"
 	"time"
 
 	"github.com/as/log"
@@ -31,7 +29,7 @@ var (
 
 	// logFreq outputs logs at the given frequency in seconds
 	// default=3.0
-	logFreq = stringDur(os.Getenv("LOGFREQ"))
+	logFreq = envDur("LOGFREQ")
 
 	// maxdup, if non-zero, terminates the process with an error
 	// if maxdup duplicate frames are detected during transcoding
@@ -39,32 +37,54 @@ var (
 
 	// targetDur, if non-zero, calculates structured progress output
 	// based on the encoder output timestamps
-	targetDur = stringDur(os.Getenv("DUR"))
+	targetDur = envDur("DUR")
 
 	// targetFrames, if non-zero, calculates structured progress output
 	// based on the expected number of frames encoded
 	targetFrames, _ = strconv.Atoi(os.Getenv("FRAMES"))
 
-	// targetOutputs, if non-zero, adjusts FPS and SPEED with a
-	// multiplier
+	// targetOutputs is the number of outputs encoded from the input,
+	// reported as fps_total and speed_total. See outputCount
 	targetOutputs, _ = strconv.Atoi(os.Getenv("OUTPUTS"))
 
+	// ratesCompat multiplies fps and speed by targetOutputs in place,
+	// like older releases did. Deprecated: use fps_total and speed_total
+	ratesCompat = os.Getenv("RATES_COMPAT") == "1"
+
+	// retry is which attempt this is, zero for the first. It's never
+	// written after startup, so the metrics and socket goroutines read it
+	// without a lock; reexec passes retry+1 to the next attempt.
 	retry, _    = strconv.Atoi(os.Getenv("RETRY"))
 	maxretry, _ = strconv.Atoi(os.Getenv("MAXRETRY"))
 
+	// minspeed, if non-zero, warns when the encoding speed stays below
+	// minspeed for several updates, along with what the encoder is bound by
+	minspeed, _ = strconv.ParseFloat(os.Getenv("MINSPEED"), 64)
+
 	tolerate = (os.Getenv("STRICT_ERRORS") == "" || os.Getenv("STRICT_ERRORS") == "0")
+
+	// autoreconnect inserts -reconnect options before each http(s) input
+	// that doesn't already have them. See args.go:/injectReconnect/
+	autoreconnect = os.Getenv("AUTORECONNECT") == "1"
+
+	// reconnectmax is the -reconnect_delay_max value used by autoreconnect
+	// default=30
+	reconnectmax = os.Getenv("RECONNECT_DELAY_MAX")
+
+	// nostdin forces -nostdin on (1) or off (0). When unset, -nostdin
+	// is added unless an input reads from stdin (-i -, -i pipe:0)
+	nostdin = os.Getenv("NOSTDIN")
+
+	// dryrun logs the final rewritten command and exits without running
+	// ffmpeg. A leading --dry-run argument does the same
+	dryrun = os.Getenv("DRYRUN") == "1"
 )
 
 // NOTE(as): HWFRAMES: We might need to re-execute ffmpeg with a new value for extra_hw_frames
 // Search for HWFRAMES1 for notes
 var (
-	hwframesbug    = false
-	hwframesptr    *string
 	hwframes       = 0
 	hwframesmax, _ = strconv.Atoi(os.Getenv("MAXEXTRAHWFRAMES"))
-	filterbug      = false
-
-	vramoverflow = false
 )
 
 func init() {
@@ -86,17 +106,61 @@ func init() {
 	if maxretry == 0 {
 		maxretry = 60
 	}
+	if reconnectmax == "" {
+		reconnectmax = "30"
+	}
 }
 
 var procstart = time.Now()
 
 func main() {
-	log.DebugOn = false
+	if printVersion(os.Args) {
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "--dry-run" {
+		os.Args = append(os.Args[:1:1], os.Args[2:]...)
+		dryrun = true
+	}
+	setLogFormat()
+	setLogFields()
+	setLogLevel()
 
-	defer log.Trap()
-	_, err := exec.LookPath("ffmpeg")
+	defer exitTrap()
+	setPrefixArgs()
+	_, err := exec.LookPath(ffmpegPath)
 	if err != nil {
-		log.Fatal.F("ffmpeg not found: %v", err)
+		fatal(log.Fatal, "ffmpeg not found: %v", err)
+	}
+	ffversion = queryVersion()
+
+	secrets, err := loadSecrets()
+	if err != nil {
+		exitStatus = exitBadArg
+		fatal(log.Fatal.Add("topic", "transcode", "action", "badarg", "err", err), "cant read secrets file")
+	}
+	if !secrets.empty() && !secrets.viaFiles() {
+		log.Warn.Add("topic", "transcode", "action", "secrets", "ffmpeg_version", ffversion.Raw, "secret_argv", secretArgv).Printf("ffmpeg reads secrets from its command line, they're visible to other users on the host")
+	}
+
+	var fired []string
+	os.Args, fired = prepareArgs(os.Args)
+	validateArgs(os.Args[1:])
+	checkFeatures(os.Args[1:])
+	if dryrun {
+		log.Info.Add("topic", "transcode", "action", "dryrun", "argv", secrets.redacted(os.Args[1:]), "rewrites", fired,
+			"target_duration", targetDur.Seconds(), "target_frames", targetFrames, "extra_hw_frames", hwframes,
+		).Printf("dry run, not starting ffmpeg")
+		return
+	}
+	checkDisk(os.Args[1:])
+	setupCleanup(os.Args[1:])
+
+	// ffmpeg prints a status line every 0.5s by default, and maxstall is counted
+	// in status lines. Scale the default so it covers the same wall time.
+	if period := flagValue(os.Args, "-stats_period"); period != "" && os.Getenv("MAXSTALL") == "" {
+		if sec, _ := strconv.ParseFloat(period, 64); sec > 0 {
+			maxstall = int(math.Max(1, float64(maxstall)*0.5/sec))
+		}
 	}
 
 	fd2 := os.Stderr
@@ -110,155 +174,135 @@ func main() {
 		fd2 = os.Stderr
 	}
 
+	logCaps()
+
 	statr, statw := biopipe()
 
 	donec := make(chan error) // command execution channel
-	ctx, kill := context.WithCancel(context.Background())
-	defer kill()
-
-	// NOTE(as): HWFRAMES1: For GPU featuresets, scan for hwframes on the command line and keep track of it
-	// because this value might be too small or too large for some media. In our case, assume its always too small
-	// and increment it with retry as a brute force solution for now. See HWFRAMES2
-	for i := 1; i < len(os.Args); i++ {
-		if os.Args[i-1] == "-extra_hw_frames" {
-			hwframesptr = &os.Args[i]
-			hwframes, _ = strconv.Atoi(*hwframesptr)
-			log.Info.Add("topic", "gpu", "action", "bootstrap", "extra_hw_frames", hwframes).Printf("detected -extra_hw_frames arg")
-		}
+	ctx, cancel := context.WithCancel(context.Background())
+	// kill stops ffmpeg's whole process group before returning, the
+	// fatal paths that call it exit right after
+	kill := func() {
+		stopGroup(child())
+		cancel()
 	}
+	defer kill()
+	unforward := forwardSignals()
 
 	// run the command
 	// inherit from parent process and override
 	// necessary values.
+	launched := time.Now()
+	// ffmpeg echoes input urls, redact them before anything reads its
+	// output. See redact.go
+	var filer *Redactor
+	filew, statusw := io.Writer(fd2), NewRedactor(statw)
+	if !keepRawStderr {
+		filer = NewRedactor(fd2)
+		filew = filer
+	}
 	go func() {
 		//fd2 = os.Stderr
-		donec <- ffmpeg(ctx, io.MultiWriter(fd2, statw), os.Args[1:]...)
+		err := runPasses(ctx, io.MultiWriter(filew, statusw), statusw, secrets, os.Args[1:])
+		filer.Flush()
+		statusw.Flush()
+		donec <- err
 		statw.Close()
 	}()
 
-	statc := make(chan State, 1000) // status channel
-	go watchState(statr, statc)
+	statc := make(chan State, 1) // status channel, see ffmpegjson/scan.go:/Handoff/
+	det := &Detected{}
+	go watchState(statr, statc, det)
 
 	update := time.NewTicker(logFreq)
 	defer update.Stop()
-	prior := State{}
-	nstall := 0
-	log.Info.Add("topic", "status", "action", "update", "progress", progress(prior)).Add(prior.Fields()...).Printf("")
-	for statc != nil {
-		select {
-		case err := <-donec:
-			fd2.Seek(0, 0)
-			logdata := new(bytes.Buffer)
-			io.Copy(logdata, fd2)
-
-			lasterr := lastline(logdata)
-			if err == nil && lasterr != "" && !(filterbug || vramoverflow || hwframesbug) {
-				// Sometimes ffmpeg will emit errors that appear to be fatal but aren't. Failing on these
-				// types of outputs is detrimental. For example, the PCM decoder can emit errors that
-				// look fatal, but ffmpeg will return a zero exit code because an error threshold wasn't reached
-				//err = fmt.Errorf("ffmpeg failed")
-				lasterr = strings.Join(globalmsg, "\n")
-				if tolerate {
-					log.Warn.Add("topic", "status").Printf("non fatal error: %s", lasterr)
-				} else {
-					err = fmt.Errorf("ffmpeg: zero exit code but parsed fatal error: %s", lasterr)
-					log.Error.Add("topic", "status").Printf("%s", lasterr)
-				}
-			}
-			if err == nil {
-				log.Info.Add("topic", "summary", "action", "done", "progress", 100, "uptime", time.Since(procstart).Seconds()).Add(prior.Fields()...).Printf("done")
-			} else {
-				doretry := func() {
-					c := exec.Command(os.Args[0], os.Args[1:]...)
-					c.Stdin = os.Stdin
-					c.Stdout = os.Stdout
-					c.Stderr = os.Stderr
-					retry++
-					c.Env = append([]string{}, os.Environ()...)
-					c.Env = append(c.Env, fmt.Sprintf("RETRY=%d", retry))
-					err := c.Run()
-					if err != nil {
-						os.Exit(1)
-					}
-					os.Exit(0)
-				}
-
-				if filterbug && strings.Contains(strings.Join(os.Args, " "), "format=nv12,hwupload,scale_npp=") {
-					log.Error.Add("topic", "gpu", "action", "alert", "subject", "filterbug", "details", "gpu filter bug",
-						"retry", retry, "maxretry", maxretry, "err", err,
-					).Printf("filterbug")
-					for i := 1; i < len(os.Args); i++ {
-						if os.Args[i-1] == "-vf" {
-							filter := &os.Args[i]
-							*filter = strings.ReplaceAll(*filter, "format=nv12,hwupload,scale_npp=", "scale_npp=")
-						}
-					}
-					doretry()
-				}
-				if vramoverflow {
-					ln := log.Error.Add(
-						"topic", "gpu", "action", "alert", "subject", "oom", "details", "gpu note out of vram",
-						"retry", retry, "maxretry", maxretry, "err", err,
-					)
-					if retry >= maxretry {
-						ln.Fatal().Printf("max retry reached: gpu OOM: %q", lasterr)
-					}
-					ln.Printf("retry: gpu OOM: %q", lasterr)
-					time.Sleep(2 * time.Second)
-					doretry()
-				}
-				if hwframesbug && hwframes < hwframesmax {
-					// NOTE(as): HWFRAMES2
-					// This is a dirty hack to restart the process created out of necessity. The argument is incremented and ffmpeg-json
-					// re-executes itself. This clobbers all state in the current process, but we haven't done much work anyway.
-					//
-					// Finally, see state.go:/HWFRAMES3/ for the detection logic
-					hwframes++
-					*hwframesptr = fmt.Sprint(hwframes)
-					log.Error.Add("topic", "gpu", "action", "alert", "subject", "retry", "details", "extra_hw_frames", hwframes).Printf("increment extra_hw_frames and retry")
-					doretry()
-				}
-				log.Fatal.Add("topic", "summary", "action", "failed", "err", err, "progress", -100).Printf("failed: %q", lasterr)
-			}
-		case current, more := <-statc:
-			if !more {
-				statc = nil
-				continue
-			}
-			if maxdup > 0 && current.Dup >= maxdup {
-				kill()
-				log.Fatal.Add("topic", "dup", "frames", current.Dup, "limit", maxdup, "fatal", true).Printf("freeze detected")
-			}
-			if current.Frame <= prior.Frame && current.Frame != 0 {
-				nstall++
-			} else {
-				nstall = 0
-			}
-			prior = current
-			if maxstall > 0 && nstall > maxstall {
-				kill()
-				log.Fatal.Add("topic", "status", "action", "stall", "frame", current.Frame).Printf("stalled on frame %d after %d updates", current.Frame, nstall)
-			}
-		case <-update.C:
-			log.Info.Add("topic", "status", "action", "update", "progress", progress(prior)).Add(prior.Fields()...).Printf("")
+	l := newLoop(ctx, os.Args, det, launched)
+	l.donec, l.statc, l.tick = donec, statc, update.C
+	l.stopTick, l.unforward, l.kill = update.Stop, unforward, kill
+	l.fd2 = fd2
+	l.Run()
+}
+
+// reexec re-executes ffmpeg-json with args and exits with its status
+func reexec(args []string) {
+	outcome = "retry"
+	runExitHooks()
+	c := exec.Command(args[0], args[1:]...)
+	c.Stdin = os.Stdin
+	c.Stdout = os.Stdout
+	c.Stderr = os.Stderr
+	c.Env = append([]string{}, os.Environ()...)
+	c.Env = append(c.Env, fmt.Sprintf("RETRY=%d", retry+1), fmt.Sprintf("PROGRESS_LATCH=%d", latched), fmt.Sprintf("RETRY_START=%d", firstStart.UnixMilli()))
+	os.Exit(childStatus(c.Run()))
+}
+
+// childStatus is the exit status of a retry that finished with err. It
+// passes the retry's own status on, so the error class codes and 128+n
+// for signal n reach the caller the same as without a retry.
+func childStatus(err error) int {
+	if err == nil {
+		return 0
+	}
+	code, sig := exitInfo(err)
+	switch {
+	case sig > 0:
+		return 128 + sig
+	case code > 0:
+		return code
+	}
+	return 1
+}
+
+// drain consumes statc until watchState closes it and returns the last
+// State received, or last if there were none
+func drain(statc <-chan State, last State) State {
+	for s := range statc {
+		// the Lsize line has the true final size, keep it
+		if !last.Final || s.Final {
+			last = s
 		}
 	}
+	return last
 }
 
-func ffmpeg(ctx context.Context, stderr io.Writer, args ...string) (err error) {
+func ffmpeg(ctx context.Context, stderr io.Writer, secrets Secrets, args ...string) (err error) {
 	ln := log.Info.Add("topic", "transcode")
-	ln.Add("action", "start").Printf("cmd: ffmpeg %q", args)
+	ln.Add("action", "start", "seed", seed, "wrapper_version", version).Add(ffversion.Fields()...).Add("ffmpeg_config", avail(len(ffversion.Config) > 0, ffversion.Config)).Printf("cmd: ffmpeg %q", secrets.redacted(args))
 	defer ln.Add("action", "stop", "err", err).Printf("")
 
-	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+	// NOTE(as): not CommandContext, which only kills ffmpeg itself and
+	// leaves its helpers running. See stopGroup.
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	argv, done, err := secrets.argv(args)
+	if err != nil {
+		return
+	}
+	defer done()
+	cmd := exec.Command(ffmpegPath, argv...)
 	cmd.Stdin = os.Stdin
 	cmd.Stdout = os.Stdout
 	cmd.Env = os.Environ()
+	setProcGroup(cmd)
 
 	r, _ := cmd.StderrPipe()
 	if err = cmd.Start(); err != nil {
 		return
 	}
+	pid := cmd.Process.Pid
+	shapeChild(pid)
+	setChild(pid)
+	defer setChild(0)
+	waited := make(chan bool)
+	defer close(waited)
+	go func() {
+		select {
+		case <-ctx.Done():
+			stopGroup(pid)
+		case <-waited:
+		}
+	}()
 	if _, err = io.Copy(stderr, bufio.NewReader(r)); err != nil {
 		return
 	}
@@ -271,8 +315,12 @@ var (
 	errNoStream   = regexp.MustCompile("^[Ss]tream map.+matches no stream")
 	errLine       = regexp.MustCompile("^[eE]rror")
 	errFilter     = regexp.MustCompile("Impossible to convert between the formats supported by the filter")
+	errOutput     = regexp.MustCompile("Could not write header for output file|Error initializing output stream|Automatic encoder selection failed")
+	errConversion = regexp.MustCompile("^Conversion failed!")
+	errDiskFull   = regexp.MustCompile("No space left on device")
+	errNoInput    = regexp.MustCompile(": (No such file or directory|Permission denied|Protocol not found)$")
 
-	errCk = []*regexp.Regexp{errFilter, errImpossible, errInvalid, errNoStream, errLine}
+	errCk = []*regexp.Regexp{errDiskFull, errNoInput, errFilter, errImpossible, errInvalid, errNoStream, errOutput, errLine, errConversion}
 )
 
 func lastline(r io.Reader) (msg string) {
@@ -296,18 +344,96 @@ func biopipe() (io.Reader, io.WriteCloser) {
 	return bufio.NewReader(r), w
 }
 
+// retryBackoff returns how long to wait before retry n: 1s, 2s, 4s,
+// up to 30s, plus up to a quarter of that as jitter so jobs that failed
+// together don't retry together. The jitter comes from random, so
+// FFJSON_SEED fixes it.
+func retryBackoff(n int) time.Duration {
+	d := time.Duration(math.Min(30, math.Pow(2, float64(n)))) * time.Second
+	return d + time.Duration(random(int64(d/4)))
+}
+
 func round100(f float64) float64 {
 	return math.Round(f*100) / 100
 }
+
+var (
+	// resume continues progress from where the previous attempt left
+	// off after a retry instead of starting over at zero
+	resume = os.Getenv("RESUME") == "1"
+
+	// latched is the highest progress reported so far. A retry passes
+	// it to the next attempt in PROGRESS_LATCH
+	latched, _ = strconv.Atoi(os.Getenv("PROGRESS_LATCH"))
+
+	// reset is true until the first status after a retry that
+	// didn't resume, so consumers know why progress went down
+	reset = latched > 0 && !resume
+)
+
+func init() {
+	if !resume {
+		latched = 0
+	}
+}
+
+// progress returns the job's progress percent, clamped to [0, 100]
+// and never less than a previously reported value
 func progress(current State) (perc int) {
-	perc = int(current.Progress(targetDur, targetFrames) * 100)
+	f := current.Progress(targetDur, targetFrames)
+	if seg, ok := segmentProgress(os.Args); ok {
+		f = seg
+	}
+	perc = int(passProgress(f) * 100)
 	if perc < 0 {
-		return 0
+		perc = 0
 	}
+	if perc > 100 {
+		perc = 100
+	}
+	if perc < latched {
+		perc = latched
+	}
+	latched = perc
 	return
 }
-func stringDur(s string) time.Duration {
-	dur, _ := time.ParseDuration(fmt.Sprintf("%ss", s))
+
+// progressReset returns true once after a retry that started over
+func progressReset() any {
+	if !reset {
+		return nil
+	}
+	reset = false
+	return true
+}
+
+var timestamp = regexp.MustCompile(`^\d+:\d{1,2}:\d{1,2}(\.\d+)?$`)
+
+// stringDur parses plain seconds (5400.5), a go duration (1h30m),
+// or an ffmpeg timestamp (01:30:00.5). The empty string is zero.
+func stringDur(s string) (time.Duration, error) {
+	s = trim(s)
+	if s == "" {
+		return 0, nil
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		return floatDur(f), nil
+	}
+	if dur, err := time.ParseDuration(s); err == nil {
+		return dur, nil
+	}
+	if timestamp.MatchString(s) {
+		return Time(s).Duration(), nil
+	}
+	return 0, fmt.Errorf("want seconds, a duration like 90m, or HH:MM:SS: %q", s)
+}
+
+// envDur returns the duration in the named env var, warning if it's invalid
+func envDur(name string) time.Duration {
+	dur, err := stringDur(os.Getenv(name))
+	if err != nil {
+		log.Warn.Add("topic", "env", "action", "badarg", "var", name, "err", err).Printf("ignoring invalid %s", name)
+	}
 	return dur
 }
 func floatDur(f float64) time.Duration {
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/as/log"
+)
+
+// restore puts each variable back to its current value when t finishes
+func restore[T any](t *testing.T, vars ...*T) {
+	t.Helper()
+	for _, v := range vars {
+		v, old := v, *v
+		t.Cleanup(func() { *v = old })
+	}
+}
+
+func TestStringDur(t *testing.T) {
+	for _, tt := range []struct {
+		in   string
+		want time.Duration
+		ok   bool
+	}{
+		{"", 0, true},
+		{"5400.5", 5400*time.Second + 500*time.Millisecond, true},
+		{" 3 ", 3 * time.Second, true},
+		{"1h30m", 90 * time.Minute, true},
+		{"250ms", 250 * time.Millisecond, true},
+		{"01:30:00.5", 90*time.Minute + 500*time.Millisecond, true},
+		{"0:00:07", 7 * time.Second, true},
+		{"90 minutes", 0, false},
+		{"1:2", 0, false},
+		{"00:00:00:05", 0, false},
+	} {
+		got, err := stringDur(tt.in)
+		if got != tt.want || (err == nil) != tt.ok {
+			t.Errorf("stringDur(%q) = %v, %v, want %v, ok=%v", tt.in, got, err, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestEnvDur(t *testing.T) {
+	buf := new(bytes.Buffer)
+	defer log.SetOutput(log.SetOutput(buf))
+	t.Setenv("TEST_DUR", "2m")
+	if d := envDur("TEST_DUR"); d != 2*time.Minute || buf.Len() != 0 {
+		t.Errorf("envDur = %v, logged %q, want 2m and nothing", d, buf)
+	}
+	t.Setenv("TEST_DUR", "soon")
+	if d := envDur("TEST_DUR"); d != 0 || !strings.Contains(buf.String(), `"var":"TEST_DUR"`) {
+		t.Errorf("envDur = %v, logged %q, want 0 and a warning", d, buf)
+	}
+}
+
+func TestProgress(t *testing.T) {
+	defer func(d time.Duration, f, l int) { targetDur, targetFrames, latched = d, f, l }(targetDur, targetFrames, latched)
+	targetDur, targetFrames, latched = 10*time.Second, 0, 0
+	for _, tt := range []struct {
+		time Time
+		want int
+	}{
+		{"00:00:00.00", 0},
+		{"-00:00:00.08", 0},
+		{"00:00:01.00", 10},
+		{"00:00:05.50", 55},
+		{"00:00:03.00", 55}, // a retry starting over
+		{"N/A", 55},
+		{"00:00:12.00", 100},
+		{"00:00:09.00", 100},
+	} {
+		if got := progress(State{Time: tt.time}); got != tt.want {
+			t.Errorf("progress at %s = %d, want %d", tt.time, got, tt.want)
+		}
+	}
+
+	// a retry resumes from PROGRESS_LATCH
+	targetDur, latched = 0, 40
+	targetFrames = 100
+	if got := progress(State{Frame: 10}); got != 40 {
+		t.Errorf("progress after a retry = %d, want the latched 40", got)
+	}
+}
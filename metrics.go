@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/as/log"
+)
+
+var (
+	// metricsAddr, if set, serves prometheus metrics on this
+	// address at /metrics, i.e. :9090
+	metricsAddr = os.Getenv("METRICS_ADDR")
+
+	// jobID labels the metrics (and anything else that wants it)
+	jobID = os.Getenv("JOB_ID")
+)
+
+// Metrics holds the values exported on /metrics. They are updated
+// alongside the status log so both always agree.
+type Metrics struct {
+	sync.Mutex
+	state    State
+	progress int
+	nstall   int
+	det      *Detected
+}
+
+// serveMetrics starts the metrics server, or returns nil when
+// METRICS_ADDR is unset. The server shuts down at exit.
+func serveMetrics(det *Detected) *Metrics {
+	if metricsAddr == "" {
+		return nil
+	}
+	m := &Metrics{det: det}
+	ln, err := net.Listen("tcp", metricsAddr)
+	if err != nil {
+		log.Warn.Add("topic", "metrics", "action", "listen", "addr", metricsAddr, "err", err).Printf("metrics disabled")
+		return nil
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", m)
+	srv := &http.Server{Handler: mux}
+	go srv.Serve(ln)
+	atExit(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		srv.Shutdown(ctx)
+	})
+	log.Info.Add("topic", "metrics", "action", "listen", "addr", ln.Addr().String()).Printf("serving metrics")
+	return m
+}
+
+// Set updates the exported values. It's a no-op on a nil Metrics.
+func (m *Metrics) Set(s State, progress, nstall int) {
+	if m == nil {
+		return
+	}
+	m.Lock()
+	m.state, m.progress, m.nstall = s, progress, nstall
+	m.Unlock()
+}
+
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.Lock()
+	s, progress, nstall := m.state, m.progress, m.nstall
+	m.Unlock()
+
+	label := ""
+	if jobID != "" {
+		label = fmt.Sprintf(`job_id=%q`, jobID)
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	gauge := func(name string, v any) {
+		fmt.Fprintf(w, "# TYPE ffmpegjson_%s gauge\nffmpegjson_%s{%s} %v\n", name, name, label, v)
+	}
+	gauge("frame", s.Frame)
+	gauge("fps", s.FPS)
+	gauge("speed", s.Speed)
+	if s.Bitrate >= 0 {
+		// left out rather than -1 while it's N/A
+		gauge("bitrate_bps", s.Bitrate)
+	}
+	gauge("dup", s.Dup)
+	gauge("drop", s.Drop)
+	gauge("progress", progress)
+	gauge("nstall", nstall)
+	gauge("retry", retry)
+
+	counts := m.det.Counts()
+	category := make([]string, 0, len(counts))
+	for k := range counts {
+		category = append(category, k)
+	}
+	sort.Strings(category)
+	fmt.Fprintf(w, "# TYPE ffmpegjson_errors_total counter\n")
+	for _, c := range category {
+		sep := ""
+		if label != "" {
+			sep = ","
+		}
+		fmt.Fprintf(w, "ffmpegjson_errors_total{category=%q%s%s} %d\n", c, sep, label, counts[c])
+	}
+}
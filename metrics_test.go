@@ -0,0 +1,46 @@
+package main
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/as/log"
+)
+
+func TestMetrics(t *testing.T) {
+	defer func(id string) { jobID = id }(jobID)
+	jobID = "j1"
+	defer log.SetOutput(log.SetOutput(new(bytes.Buffer)))
+	det := &Detected{}
+	det.Scan("[h264 @ 0x1] error while decoding MB 1 2")
+	m := &Metrics{det: det}
+
+	scrape := func() string {
+		w := httptest.NewRecorder()
+		m.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+		return w.Body.String()
+	}
+	m.Set(State{Frame: 250, FPS: 25, Speed: 1, Bitrate: 800000}, 40, 1)
+	body := scrape()
+	for _, want := range []string{
+		"ffmpegjson_frame{job_id=\"j1\"} 250\n",
+		"ffmpegjson_bitrate_bps{job_id=\"j1\"} 800000\n",
+		"ffmpegjson_progress{job_id=\"j1\"} 40\n",
+		"ffmpegjson_nstall{job_id=\"j1\"} 1\n",
+		"ffmpegjson_retry{job_id=\"j1\"} 0\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics missing %q:\n%s", want, body)
+		}
+	}
+	if !strings.Contains(body, `ffmpegjson_errors_total{category=`) {
+		t.Errorf("metrics have no error counts:\n%s", body)
+	}
+
+	m.Set(State{Frame: 260, FPS: 25, Speed: 1, Bitrate: -1}, 41, 1)
+	if body := scrape(); strings.Contains(body, "bitrate_bps") || !strings.Contains(body, "ffmpegjson_frame{job_id=\"j1\"} 260\n") {
+		t.Errorf("N/A bitrate: metrics\n%s\nwant frame 260 and no bitrate_bps", body)
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/as/ffmpeg-json/ffmpegjson"
+)
+
+// Mux is ffmpeg's final muxing summary, in bytes:
+//
+//	video:10240kB audio:1200kB subtitle:0kB other streams:0kB global headers:0kB muxing overhead: 0.412%
+type Mux struct {
+	Video, Audio, Subtitle, Other, Headers int64
+	Overhead                               float64 // percent, -1 when unknown
+}
+
+var muxRE = regexp.MustCompile(`video:\s*([\d.]+)\s*(\w+)\s+audio:\s*([\d.]+)\s*(\w+)\s+subtitle:\s*([\d.]+)\s*(\w+)\s+other streams:\s*([\d.]+)\s*(\w+)\s+global headers:\s*([\d.]+)\s*(\w+)\s+muxing overhead:\s*(\S+)`)
+
+var mux struct {
+	sync.Mutex
+	*Mux
+}
+
+func parseMux(line string) (m Mux, ok bool) {
+	v := muxRE.FindStringSubmatch(line)
+	if v == nil {
+		return m, false
+	}
+	size := func(i int) int64 {
+		n, _ := strconv.ParseFloat(v[i], 64)
+		return ffmpegjson.UnitBytes(n, v[i+1])
+	}
+	m.Video, m.Audio, m.Subtitle, m.Other, m.Headers = size(1), size(3), size(5), size(7), size(9)
+	m.Overhead = -1
+	if f, err := strconv.ParseFloat(strings.TrimSuffix(v[11], "%"), 64); err == nil {
+		m.Overhead = f
+	}
+	return m, true
+}
+
+func noteMux(line string) {
+	if m, ok := parseMux(line); ok {
+		mux.Lock()
+		mux.Mux = &m
+		mux.Unlock()
+	}
+}
+
+// muxFields returns the mux summary fields, or nil if ffmpeg didn't print
+// one (usually because it was killed)
+func muxFields() []any {
+	mux.Lock()
+	defer mux.Unlock()
+	m := mux.Mux
+	if m == nil {
+		return nil
+	}
+	return []any{
+		"video_bytes", m.Video,
+		"audio_bytes", m.Audio,
+		"subtitle_bytes", m.Subtitle,
+		"other_bytes", m.Other,
+		"header_bytes", m.Headers,
+		"mux_overhead", avail(m.Overhead >= 0, m.Overhead),
+	}
+}
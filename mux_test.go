@@ -0,0 +1,34 @@
+package main
+
+import "testing"
+
+func TestParseMux(t *testing.T) {
+	for _, tt := range []struct {
+		line string
+		want Mux
+		ok   bool
+	}{
+		{
+			line: "video:10240kB audio:1200kB subtitle:0kB other streams:0kB global headers:0kB muxing overhead: 0.412%",
+			want: Mux{Video: 10240 << 10, Audio: 1200 << 10, Overhead: 0.412},
+			ok:   true,
+		},
+		{
+			line: "[out#0/mp4 @ 0x1] video:2MiB audio:512KiB subtitle:1KiB other streams:0KiB global headers:3KiB muxing overhead: 1.5%",
+			want: Mux{Video: 2 << 20, Audio: 512 << 10, Subtitle: 1 << 10, Headers: 3 << 10, Overhead: 1.5},
+			ok:   true,
+		},
+		{
+			line: "video:0kB audio:0kB subtitle:0kB other streams:0kB global headers:0kB muxing overhead: unknown",
+			want: Mux{Overhead: -1},
+			ok:   true,
+		},
+		{line: "frame=1 fps=0 size=1kB time=00:00:00.04 bitrate=1.0kbits/s speed=1x"},
+		{line: "video:10240kB audio:1200kB"},
+	} {
+		got, ok := parseMux(tt.line)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("parseMux(%q) = %+v, %v, want %+v, %v", tt.line, got, ok, tt.want, tt.ok)
+		}
+	}
+}
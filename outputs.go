@@ -0,0 +1,74 @@
+package main
+
+import (
+	"os"
+	"regexp"
+	"strconv"
+	"sync"
+)
+
+// Output is an output file as announced in the ffmpeg banner
+type Output struct {
+	Index  int    `json:"index"`
+	Format string `json:"format"`
+	Path   string `json:"path"`
+	Size   int64  `json:"size,omitempty"`
+}
+
+var outputRE = regexp.MustCompile(`^Output #(\d+), ([^,]+), to '(.*)':`)
+
+// outputs are the outputs of the current pass
+var outputs struct {
+	sync.Mutex
+	list []Output
+}
+
+// noteOutput records the "Output #n, fmt, to 'path':" banner lines
+func noteOutput(line string) {
+	m := outputRE.FindStringSubmatch(line)
+	if m == nil {
+		return
+	}
+	o := Output{Format: m[2], Path: m[3]}
+	o.Index, _ = strconv.Atoi(m[1])
+	outputs.Lock()
+	outputs.list = append(outputs.list, o)
+	outputs.Unlock()
+}
+
+// resetOutputs forgets the outputs at the start of the next pass, which
+// announces its own
+func resetOutputs() {
+	outputs.Lock()
+	outputs.list = nil
+	outputs.Unlock()
+}
+
+// outputCount is the fps/speed multiplier: OUTPUTS if set, or the number
+// of outputs the current pass announced, or counted on the command line
+func outputCount() int {
+	outputs.Lock()
+	defer outputs.Unlock()
+	if n := len(outputs.list); n > 0 && os.Getenv("OUTPUTS") == "" {
+		return n
+	}
+	return targetOutputs
+}
+
+// outputStatus returns the outputs with their current size on disk where
+// the output is a local file, or nil when no outputs were announced
+func outputStatus() any {
+	outputs.Lock()
+	defer outputs.Unlock()
+	if len(outputs.list) == 0 {
+		return nil
+	}
+	list := append([]Output{}, outputs.list...)
+	for i := range list {
+		if fi, err := os.Stat(list[i].Path); err == nil && fi.Mode().IsRegular() {
+			list[i].Size = fi.Size()
+		}
+		list[i].Path = redact(list[i].Path)
+	}
+	return list
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestOutputsPerPass(t *testing.T) {
+	defer resetOutputs()
+	resetOutputs()
+	status := "frame=10 fps=30 size=1kB time=00:00:01.00 bitrate=8.0kbits/s speed=1x\r"
+	watchLines("Output #0, null, to '/dev/null':\n" + status + "\n" + passMarker + "\nOutput #0, mp4, to 'out.mp4':\n" + status + "\n")
+
+	if n := outputCount(); n != 1 {
+		t.Fatalf("two passes of one output: outputCount = %d, want 1", n)
+	}
+	list, _ := outputStatus().([]Output)
+	if len(list) != 1 || list[0].Path != "out.mp4" {
+		t.Fatalf("outputStatus = %+v, want the second pass's out.mp4", list)
+	}
+	for i, kv := 0, stateFields(State{FPS: 30}); i < len(kv); i += 2 {
+		if kv[i] == "fps_total" {
+			t.Fatalf("fps_total = %v for a single output", kv[i+1])
+		}
+	}
+}
+
+func TestOutputCount(t *testing.T) {
+	defer func(n int) { targetOutputs = n; resetOutputs() }(targetOutputs)
+	targetOutputs = 3 // from the command line
+	resetOutputs()
+	if n := outputCount(); n != 3 {
+		t.Errorf("before the banner: outputCount = %d, want 3", n)
+	}
+	noteOutput("Output #0, mp4, to 'a.mp4':")
+	noteOutput("Output #1, mp4, to 'b.mp4':")
+	if n := outputCount(); n != 2 {
+		t.Errorf("banner: outputCount = %d, want 2", n)
+	}
+	t.Setenv("OUTPUTS", "3")
+	if n := outputCount(); n != 3 {
+		t.Errorf("OUTPUTS=3: outputCount = %d, want 3", n)
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/as/log"
+)
+
+var (
+	// watchOutput kills ffmpeg when the output files stop growing while
+	// the frame count still advances, i.e. a mount that went read-only
+	watchOutput = os.Getenv("WATCH_OUTPUT") == "1"
+
+	// outputStall is how long the outputs may go without growing.
+	// default=60s
+	outputStall = envDur("OUTPUT_STALL")
+)
+
+// templateRE matches the printf and strftime patterns in segment names
+var templateRE = regexp.MustCompile(`%[0-9]*[a-zA-Z]`)
+
+// OutputWatch tracks the size of the output files. A segment template
+// is watched through the newest file in its directory that matches it.
+type OutputWatch struct {
+	paths []string
+	last  map[string]string // path -> name:size seen last
+	grew  time.Time
+	frame int // at the last tick
+}
+
+// NewOutputWatch returns nil when WATCH_OUTPUT is unset or none of the
+// outputs are local files
+func NewOutputWatch(args []string) *OutputWatch {
+	if !watchOutput {
+		return nil
+	}
+	if outputStall <= 0 {
+		outputStall = time.Minute
+	}
+	w := &OutputWatch{last: map[string]string{}, grew: time.Now()}
+	w.paths = localOutputs(args)
+	if len(w.paths) == 0 {
+		log.Warn.Add("topic", "watchdog", "action", "watch_output", "reason", "no local outputs").Printf("output watchdog disabled")
+		return nil
+	}
+	return w
+}
+
+// stat returns the name and size of path, or of the newest file
+// matching it if it's a template
+func stat(path string) (string, int64) {
+	if !templateRE.MatchString(path) {
+		fi, err := os.Stat(path)
+		if err != nil {
+			return path, -1
+		}
+		return path, fi.Size()
+	}
+	match, _ := filepath.Glob(templateRE.ReplaceAllString(strings.ReplaceAll(path, "%%", "%"), "*"))
+	name, size, mod := "", int64(-1), time.Time{}
+	for _, m := range match {
+		if fi, err := os.Stat(m); err == nil && fi.ModTime().After(mod) {
+			name, size, mod = m, fi.Size(), fi.ModTime()
+		}
+	}
+	return name, size
+}
+
+// Stalled returns true if none of the outputs grew for OUTPUT_STALL
+// while the frame count kept advancing. Ticks where the frame count
+// doesn't move are left to the regular stall detection and restart the
+// window. It's a no-op on a nil OutputWatch.
+func (w *OutputWatch) Stalled(s State) bool {
+	if w == nil {
+		return false
+	}
+	grew := false
+	for _, p := range w.paths {
+		name, size := stat(p)
+		key := name + ":" + strconv.FormatInt(size, 10)
+		if size >= 0 && w.last[p] != key {
+			grew = true
+		}
+		w.last[p] = key
+	}
+	advanced := s.Frame > w.frame
+	w.frame = s.Frame
+	if grew || !advanced {
+		w.grew = time.Now()
+		return false
+	}
+	return time.Since(w.grew) >= outputStall
+}
+
+// Fields returns the watched outputs and their last known sizes
+func (w *OutputWatch) Fields() []any {
+	if w == nil {
+		return nil
+	}
+	last := make([]string, 0, len(w.paths))
+	for _, p := range w.paths {
+		last = append(last, w.last[p])
+	}
+	return []any{"outputs_watched", last, "output_stall", outputStall.Seconds()}
+}
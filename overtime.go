@@ -0,0 +1,83 @@
+package main
+
+import (
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	// maxRuntime kills ffmpeg when it's still running this long after
+	// the first attempt started. Retries don't restart the clock.
+	maxRuntime = envDur("MAXRUNTIME")
+
+	// maxRuntimeFactor kills ffmpeg when it's been encoding for longer
+	// than this many times the target duration, i.e. 4 for a job that
+	// should never be slower than a quarter of realtime. The target is
+	// DUR or AUTOPROBE's, or the first input's duration from the banner.
+	// The smaller of the two caps wins when MAXRUNTIME is also set
+	maxRuntimeFactor, _ = strconv.ParseFloat(os.Getenv("MAXRUNTIME_FACTOR"), 64)
+)
+
+// bannerDur is the first input's duration from the banner
+var bannerDur struct {
+	sync.Mutex
+	dur time.Duration
+}
+
+func noteInputDuration(d time.Duration) {
+	bannerDur.Lock()
+	if bannerDur.dur == 0 {
+		bannerDur.dur = d
+	}
+	bannerDur.Unlock()
+}
+
+// Overtime enforces MAXRUNTIME and MAXRUNTIME_FACTOR
+type Overtime struct {
+	start time.Time // when the first attempt started, for MAXRUNTIME
+	armed time.Time // when encoding started, for the factor
+}
+
+// NewOvertime measures MAXRUNTIME from start, which is firstStart
+// outside of tests
+func NewOvertime(start time.Time) *Overtime {
+	return &Overtime{start: start}
+}
+
+// Arm starts the factor's clock the first time s shows encoding
+func (o *Overtime) Arm(s State, now time.Time) {
+	if o.armed.IsZero() && started(s) {
+		o.armed = now
+	}
+}
+
+// Check returns the cap that's been exceeded, how long it's been running
+// against it and which setting it came from. The factor's cap is worked
+// out on every call, the target duration may only be known once ffmpeg
+// prints its banner.
+func (o *Overtime) Check(now time.Time) (limit, elapsed time.Duration, basis string, over bool) {
+	deadline := time.Time{}
+	if maxRuntime > 0 {
+		limit, elapsed, basis = maxRuntime, now.Sub(o.start), "maxruntime"
+		deadline = o.start.Add(maxRuntime)
+	}
+	if dur := o.target(); maxRuntimeFactor > 0 && dur > 0 && !o.armed.IsZero() {
+		budget := time.Duration(maxRuntimeFactor * float64(dur))
+		if deadline.IsZero() || o.armed.Add(budget).Before(deadline) {
+			limit, elapsed, basis = budget, now.Sub(o.armed), "factor"
+			deadline = o.armed.Add(budget)
+		}
+	}
+	return limit, elapsed, basis, !deadline.IsZero() && now.After(deadline)
+}
+
+func (o *Overtime) target() time.Duration {
+	if targetDur > 0 {
+		return targetDur
+	}
+	bannerDur.Lock()
+	defer bannerDur.Unlock()
+	return bannerDur.dur
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestOvertime(t *testing.T) {
+	defer func(d time.Duration, f float64, dur time.Duration) {
+		maxRuntime, maxRuntimeFactor, targetDur = d, f, dur
+	}(maxRuntime, maxRuntimeFactor, targetDur)
+
+	start := time.Unix(1000, 0)
+	at := func(d time.Duration) time.Time { return start.Add(d) }
+	for _, tt := range []struct {
+		name    string
+		max     time.Duration
+		factor  float64
+		dur     time.Duration
+		armed   time.Duration // since start, <0 never
+		now     time.Duration
+		over    bool
+		basis   string
+		elapsed time.Duration
+	}{
+		{"off", 0, 0, 0, 0, time.Hour, false, "", 0},
+		{"under", time.Minute, 0, 0, 0, 30 * time.Second, false, "maxruntime", 30 * time.Second},
+		{"over", time.Minute, 0, 0, 0, 61 * time.Second, true, "maxruntime", 61 * time.Second},
+		// a retry launched at 50s still counts from the first start
+		{"retried", time.Minute, 0, 0, 55 * time.Second, 61 * time.Second, true, "maxruntime", 61 * time.Second},
+		{"factor not armed", 0, 2, 10 * time.Second, -1, time.Hour, false, "", 0},
+		{"factor", 0, 2, 10 * time.Second, 5 * time.Second, 26 * time.Second, true, "factor", 21 * time.Second},
+		{"factor under", 0, 2, 10 * time.Second, 5 * time.Second, 24 * time.Second, false, "factor", 19 * time.Second},
+		{"smaller cap wins", time.Minute, 2, 10 * time.Second, 5 * time.Second, 26 * time.Second, true, "factor", 21 * time.Second},
+		{"maxruntime sooner", 20 * time.Second, 2, 10 * time.Second, 5 * time.Second, 21 * time.Second, true, "maxruntime", 21 * time.Second},
+	} {
+		maxRuntime, maxRuntimeFactor, targetDur = tt.max, tt.factor, tt.dur
+		o := NewOvertime(start)
+		if tt.armed >= 0 {
+			o.Arm(State{}, at(tt.armed)) // nothing encoded yet
+			o.Arm(State{Frame: 1}, at(tt.armed))
+			o.Arm(State{Frame: 2}, at(tt.armed+time.Second))
+		}
+		_, elapsed, basis, over := o.Check(at(tt.now))
+		if over != tt.over || basis != tt.basis || elapsed != tt.elapsed {
+			t.Errorf("%s: Check = %s, %q, %v, want %s, %q, %v", tt.name, elapsed, basis, over, tt.elapsed, tt.basis, tt.over)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/as/ffmpeg-json/ffmpegjson"
+	"github.com/as/log"
+)
+
+var (
+	// strictParse aborts the job when ffmpeg's status lines can't be
+	// parsed at all, instead of running with zeroed metrics
+	strictParse = os.Getenv("STRICT_PARSE") == "1"
+
+	// parseLines is how many status lines to see before deciding
+	// the parser doesn't understand them. default=10
+	parseLines, _ = strconv.Atoi(os.Getenv("STRICT_PARSE_LINES"))
+)
+
+// parsed counts the status lines seen versus successfully decoded,
+// keeping a few of the failures as samples
+var parsed struct {
+	sync.Mutex
+	seen, ok int
+	bad      int // values that couldn't be decoded
+	samples  []string
+	warned   bool
+	first    time.Time // when the first status line was seen
+	video    bool      // the status lines count frames
+}
+
+func noteParse(line string, s State, bad int) {
+	if !ffmpegjson.IsStatusLine(line) {
+		return
+	}
+	parsed.Lock()
+	defer parsed.Unlock()
+	parsed.bad += bad
+	if parsed.seen == 0 {
+		parsed.first = time.Now()
+	}
+	parsed.video = parsed.video || strings.HasPrefix(line, "frame=")
+	parsed.seen++
+	if s != (State{}) {
+		parsed.ok++
+	} else if len(parsed.samples) < 3 {
+		parsed.samples = append(parsed.samples, line)
+	}
+}
+
+// checkParse is called periodically. It returns false when the parser
+// has failed on every status line seen so far in strict mode.
+func checkParse() bool {
+	parsed.Lock()
+	defer parsed.Unlock()
+	n := parseLines
+	if n == 0 {
+		n = 10
+	}
+	if parsed.seen < n || parsed.ok > 0 {
+		return true
+	}
+	if strictParse {
+		return false
+	}
+	if !parsed.warned {
+		parsed.warned = true
+		log.Warn.Add("topic", "status", "action", "degraded", "error_class", "parse_failure", "samples", parsed.samples).Printf("status lines not understood, monitoring is degraded")
+	}
+	return true
+}
+
+func parseFields() []any {
+	parsed.Lock()
+	defer parsed.Unlock()
+	return []any{"lines_seen", parsed.seen, "lines_parsed", parsed.ok, "parse_errors", parsed.bad}
+}
+
+func parseSamples() []string {
+	parsed.Lock()
+	defer parsed.Unlock()
+	return append([]string{}, parsed.samples...)
+}
+
+// firstStatus returns when the first status line was seen and whether
+// the status lines count frames
+func firstStatus() (first time.Time, video bool) {
+	parsed.Lock()
+	defer parsed.Unlock()
+	return parsed.first, parsed.video
+}
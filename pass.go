@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// passSplit is the share of overall progress given to the first pass
+// of a two-pass encode. default=0.5
+var passSplit, _ = strconv.ParseFloat(os.Getenv("PASS_SPLIT"), 64)
+
+// curpass is the pass ffmpeg is running, or zero for single-pass encodes
+var curpass int64
+
+// passMarker is written into the status stream between sequential passes
+// so watchState starts over with the next pass's counters
+const passMarker = "ffmpeg-json: next pass"
+
+// passTimes records how long each pass took. runPasses appends to it
+// while the main loop may log a summary.
+var passTimes struct {
+	sync.Mutex
+	d []time.Duration
+}
+
+// splitPasses splits the command line on "--" into one command per pass.
+// The global options we inject are copied into every pass.
+func splitPasses(args []string) (passes [][]string) {
+	cmd := []string{}
+	for _, a := range args {
+		if a == "--" {
+			passes = append(passes, cmd)
+			cmd = []string{}
+			continue
+		}
+		cmd = append(cmd, a)
+	}
+	passes = append(passes, cmd)
+	for i := 1; i < len(passes); i++ {
+		if hasFlag(passes[0], "-nostdin") && !hasFlag(passes[i], "-nostdin") {
+			passes[i] = append([]string{"-nostdin"}, passes[i]...)
+		}
+		if v := flagValue(passes[0], "-stats_period"); v != "" && !hasFlag(passes[i], "-stats_period") {
+			passes[i] = append([]string{"-stats_period", v}, passes[i]...)
+		}
+		if len(prefixArgs) > 0 && !hasPrefixArgs(passes[i]) {
+			passes[i] = append(append([]string{}, prefixArgs...), passes[i]...)
+		}
+	}
+	return passes
+}
+
+// runPasses runs each pass to completion in order, stopping at the first
+// error. A single command with -pass 1 or -pass 2 is reported as that pass.
+func runPasses(ctx context.Context, stderr, status io.Writer, secrets Secrets, args []string) error {
+	passes := splitPasses(args)
+	if len(passes) == 1 {
+		n, _ := strconv.Atoi(flagValue(args, "-pass"))
+		atomic.StoreInt64(&curpass, int64(n))
+		return ffmpeg(ctx, stderr, secrets, args...)
+	}
+	for i, cmd := range passes {
+		if i > 0 {
+			fmt.Fprintln(status, passMarker)
+		}
+		atomic.StoreInt64(&curpass, int64(i+1))
+		start := time.Now()
+		err := ffmpeg(ctx, stderr, secrets, cmd...)
+		passTimes.Lock()
+		passTimes.d = append(passTimes.d, time.Since(start))
+		passTimes.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// passProgress maps the progress of the current pass onto the whole job
+func passProgress(f float64) float64 {
+	split := passSplit
+	if split <= 0 || split >= 1 {
+		split = 0.5
+	}
+	switch atomic.LoadInt64(&curpass) {
+	case 1:
+		return f * split
+	case 2:
+		return split + f*(1-split)
+	}
+	return f
+}
+
+func passFields() (kv []any) {
+	passTimes.Lock()
+	defer passTimes.Unlock()
+	for i, d := range passTimes.d {
+		kv = append(kv, fmt.Sprintf("pass%d_s", i+1), d.Seconds())
+	}
+	return kv
+}
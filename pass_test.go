@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/as/log"
+)
+
+// TestPassTimes runs three passes of a fake ffmpeg while the summary
+// fields are read, the way a fatal exit in the main loop reads them
+func TestPassTimes(t *testing.T) {
+	if runtime.GOOS == "windows" || runtime.GOOS == "plan9" {
+		t.Skip("needs /bin/sh")
+	}
+	bin := filepath.Join(t.TempDir(), "ffmpeg")
+	if err := os.WriteFile(bin, []byte("#!/bin/sh\nsleep 0.01\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	defer func(p string) { ffmpegPath = p }(ffmpegPath)
+	ffmpegPath = bin
+	defer func() { passTimes.d = nil }()
+	defer log.SetOutput(log.SetOutput(new(bytes.Buffer)))
+
+	done := make(chan error)
+	go func() {
+		done <- runPasses(context.Background(), io.Discard, io.Discard, Secrets{}, []string{"-i", "a", "x", "--", "-i", "b", "y", "--", "-i", "c", "z"})
+	}()
+	for {
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatal(err)
+			}
+			if kv := passFields(); len(kv) != 6 || kv[4] != "pass3_s" {
+				t.Fatalf("passFields = %v, want 3 passes", kv)
+			}
+			return
+		default:
+			passFields()
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"runtime"
+
+	"github.com/as/log"
+)
+
+// Caps lists the monitoring capabilities available on this platform. Samplers
+// check their flag before reading anything so unsupported platforms stay quiet.
+type Caps struct {
+	Proc   bool   // /proc/<pid>/stat: cpu time, thread count
+	Status bool   // /proc/<pid>/status: rss
+	IO     bool   // /proc/<pid>/io: read/write byte counters
+	NVIDIA bool   // nvidia-smi on PATH
+	AMD    string // rocm-smi or amd-smi, whichever is on PATH
+}
+
+var caps = probeCaps()
+
+func probeCaps() (c Caps) {
+	exists := func(path string) bool {
+		_, err := os.Stat(path)
+		return err == nil
+	}
+	c.Proc = exists("/proc/self/stat")
+	c.Status = exists("/proc/self/status")
+	c.IO = exists("/proc/self/io")
+	c.NVIDIA = lookPath("nvidia-smi")
+	for _, tool := range []string{"amd-smi", "rocm-smi"} {
+		if lookPath(tool) {
+			c.AMD = tool
+			break
+		}
+	}
+	return c
+}
+
+func lookPath(file string) bool {
+	_, err := exec.LookPath(file)
+	return err == nil
+}
+
+func (c Caps) Fields() []any {
+	return []any{
+		"os", runtime.GOOS,
+		"cap_proc", c.Proc,
+		"cap_rss", c.Status,
+		"cap_io", c.IO,
+		"cap_nvidia", c.NVIDIA,
+		"cap_amd", c.AMD,
+	}
+}
+
+func logCaps() {
+	log.Info.Add("topic", "env", "action", "probe").Add(caps.Fields()...).Printf("monitoring capabilities")
+}
+
+// avail returns v when ok is true and nil otherwise. The logger omits nil
+// fields, so unavailable metrics are left out instead of reported as zero.
+func avail(ok bool, v any) any {
+	if !ok {
+		return nil
+	}
+	return v
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/as/log"
+)
+
+// precheck fails the job before ffmpeg starts when the build doesn't
+// have an encoder or filter the command asks for. PRECHECK=0 turns it off
+var precheck = os.Getenv("PRECHECK") != "0"
+
+// Features are the encoders and filters an ffmpeg build has. Encoders also
+// has the codecs that can be encoded, -c:v h264 picks their default
+// encoder.
+type Features struct {
+	Encoders map[string]bool
+	Filters  map[string]bool
+}
+
+// codecFlags are the output options whose value is an encoder
+var codecFlags = []string{"-c", "-codec", "-vcodec", "-acodec", "-scodec"}
+
+// graphFlags are the options whose value is a filter graph
+var graphFlags = map[string]bool{"-vf": true, "-af": true, "-filter_complex": true, "-lavfi": true}
+
+// isCodecFlag reports whether flag is one of codecFlags with an optional
+// stream specifier, i.e. -c:v:0
+func isCodecFlag(flag string) bool {
+	for _, f := range codecFlags {
+		if flag == f || strings.HasPrefix(flag, f+":") {
+			return true
+		}
+	}
+	return false
+}
+
+// requested returns the encoders and filters args, one ffmpeg command,
+// asks for. Codec options before an -i pick a decoder and are skipped.
+func requested(args []string) (encoders, filters []string) {
+	var pending []string
+	for i := 1; i < len(args); i++ {
+		flag, v := args[i-1], args[i]
+		switch {
+		case flag == "-i":
+			pending = nil
+		case isCodecFlag(flag) && v != "copy":
+			pending = append(pending, v)
+		case graphFlags[flag] || strings.HasPrefix(flag, "-filter:"):
+			filters = append(filters, filterNames(v)...)
+		case len(v) > 0 && v[0] != '-' && (len(flag) < 2 || flag[0] != '-' || boolFlags[flag]):
+			// an output
+			encoders = append(encoders, pending...)
+			pending = nil
+		}
+	}
+	return encoders, filters
+}
+
+// filterNames returns the names of the filters in graph. Separators in
+// quotes or escaped don't split it, and parts that don't look like a
+// plain filter (expressions, unbalanced quotes) are skipped.
+func filterNames(graph string) (names []string) {
+	for _, p := range splitGraph(graph) {
+		if m := filterRE.FindStringSubmatch(p); m != nil && !hastext(p, "'", "\\") {
+			names = append(names, m[2])
+		}
+	}
+	return names
+}
+
+func splitGraph(graph string) (parts []string) {
+	quoted, start := false, 0
+	for i := 0; i < len(graph); i++ {
+		switch c := graph[i]; {
+		case c == '\\':
+			i++
+		case c == '\'':
+			quoted = !quoted
+		case (c == ',' || c == ';') && !quoted:
+			parts = append(parts, graph[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, graph[start:])
+}
+
+// queryFeatures lists the encoders and filters of the ffmpeg binary. The
+// result is cached in the temp dir by the binary's path and mtime, so
+// the extra runs of ffmpeg only happen once per build.
+func queryFeatures() (c Features, err error) {
+	bin, err := exec.LookPath(ffmpegPath)
+	if err != nil {
+		return c, err
+	}
+	fi, err := os.Stat(bin)
+	if err != nil {
+		return c, err
+	}
+	key := sha256.Sum256([]byte(fmt.Sprintf("%s %d", bin, fi.ModTime().UnixNano())))
+	cache := filepath.Join(os.TempDir(), fmt.Sprintf("ffmpeg-json-features2-%x.json", key[:8]))
+	if data, err := os.ReadFile(cache); err == nil && json.Unmarshal(data, &c) == nil {
+		return c, nil
+	}
+	enc, err := ffmpegList(bin, "-encoders")
+	if err != nil {
+		return c, err
+	}
+	codecs, err := ffmpegList(bin, "-codecs")
+	if err != nil {
+		return c, err
+	}
+	flt, err := ffmpegList(bin, "-filters")
+	if err != nil {
+		return c, err
+	}
+	c = Features{Encoders: parseList(enc, "-encoders"), Filters: parseList(flt, "-filters")}
+	for name := range parseList(codecs, "-codecs") {
+		c.Encoders[name] = true
+	}
+	if data, err := json.Marshal(c); err == nil {
+		os.WriteFile(cache, data, 0644)
+	}
+	return c, nil
+}
+
+func ffmpegList(bin, flag string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, bin, "-hide_banner", flag).Output()
+	return string(out), err
+}
+
+// parseList parses the output of ffmpeg's list flag, -encoders, -codecs
+// or -filters. The encoders and codecs follow a ------ line. An encoder's
+// name is always its second column, but only codecs flagged E in theirs
+// (DEV.LS) can be encoded. The filters have no separator, but their third
+// column is the pads, i.e. V->V, which the legend lines don't have.
+func parseList(out string, flag string) map[string]bool {
+	names := map[string]bool{}
+	filters := flag == "-filters"
+	listed := filters
+	for _, line := range strings.Split(out, "\n") {
+		f := strings.Fields(line)
+		switch {
+		case !filters && len(f) > 0 && strings.HasPrefix(f[0], "---"):
+			listed = true
+		case !listed || len(f) < 2:
+		case filters:
+			if len(f) >= 3 && strings.Contains(f[2], "->") {
+				names[f[1]] = true
+			}
+		case flag == "-codecs" && (len(f[0]) != 6 || f[0][1] != 'E'):
+			// a codec that can only be decoded
+		default:
+			names[f[1]] = true
+		}
+	}
+	return names
+}
+
+// missing returns what args asks for that c doesn't have
+func (c Features) missing(args []string) (encoders, filters []string) {
+	for _, pass := range splitPasses(args) {
+		enc, flt := requested(pass)
+		for _, e := range enc {
+			if !c.Encoders[e] && !hasFlag(encoders, e) {
+				encoders = append(encoders, e)
+			}
+		}
+		for _, f := range flt {
+			if !c.Filters[f] && !hasFlag(filters, f) {
+				filters = append(filters, f)
+			}
+		}
+	}
+	return encoders, filters
+}
+
+// checkFeatures fails with error_class=unsupported and exitBadArg if the
+// ffmpeg build lacks an encoder or filter args needs. It's skipped when
+// ffmpeg can't list them.
+func checkFeatures(args []string) {
+	if !precheck {
+		return
+	}
+	c, err := queryFeatures()
+	if err != nil || len(c.Encoders) == 0 || len(c.Filters) == 0 {
+		if debugOn() {
+			log.Debug.Add("topic", "transcode", "action", "precheck", "err", err).Printf("cant list encoders and filters, skipping precheck")
+		}
+		return
+	}
+	enc, flt := c.missing(args)
+	if len(enc) == 0 && len(flt) == 0 {
+		return
+	}
+	exitStatus, errorClass = exitBadArg, "unsupported"
+	fatal(log.Fatal.Add("topic", "transcode", "action", "precheck", "error_class", "unsupported", "encoders", avail(len(enc) > 0, enc), "filters", avail(len(flt) > 0, flt), "ffmpeg_version", ffversion.Raw), "ffmpeg was built without %s", strings.Join(append(enc, flt...), ", "))
+}
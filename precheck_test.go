@@ -0,0 +1,103 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"testing"
+)
+
+// These are trimmed transcripts of ffmpeg 6.1 -hide_banner -encoders,
+// -codecs and -filters.
+const (
+	encodersOut = `Encoders:
+ V..... = Video
+ A..... = Audio
+ S..... = Subtitle
+ .F.... = Frame-level multithreading
+ ..S... = Slice-level multithreading
+ ...X.. = Codec is experimental
+ ....B. = Supports draw_horiz_band
+ .....D = Supports direct rendering method 1
+ ------
+ V....D a64multi             Multicolor charset for Commodore 64 (codec a64_multi)
+ V....D libx264              libx264 H.264 / AVC / MPEG-4 AVC / MPEG-4 part 10 (codec h264)
+ V....D hevc_nvenc           NVIDIA NVENC hevc encoder (codec hevc)
+ VFS..D mpeg2video           MPEG-2 video
+ A....D aac                  AAC (Advanced Audio Coding)
+ A....D libopus              libopus Opus (codec opus)
+ S..... mov_text             3GPP Timed Text subtitle
+`
+	codecsOut = `Codecs:
+ D..... = Decoding supported
+ .E.... = Encoding supported
+ ..V... = Video codec
+ ..A... = Audio codec
+ ..S... = Subtitle codec
+ ..D... = Data codec
+ ..T... = Attachment codec
+ ...I.. = Intra frame-only codec
+ ....L. = Lossy compression
+ .....S = Lossless compression
+ -------
+ DEV.LS h264                 H.264 / AVC / MPEG-4 AVC / MPEG-4 part 10 (decoders: h264 h264_v4l2m2m ) (encoders: libx264 libx264rgb h264_nvenc )
+ D.V.L. vp6                  On2 VP6
+ DEA.L. aac                  AAC (Advanced Audio Coding) (decoders: aac aac_fixed )
+ ..D... klv                  SMPTE 336M Key-Length-Value (KLV) metadata
+`
+	filtersOut = `Filters:
+  T.. = Timeline support
+  .S. = Slice threading
+  ..C = Command support
+  A = Audio input/output
+  V = Video input/output
+  N = Dynamic number and/or type of input/output
+  | = Source or sink filter
+ ... abench            A->A       Benchmark part of a filtergraph.
+ .SC scale             V->V       Scale the input video size and/or convert the image format.
+ ... amix              N->A       Audio mixing.
+ ... testsrc           |->V       Generate test source.
+`
+)
+
+func TestParseList(t *testing.T) {
+	for _, tt := range []struct {
+		flag, out string
+		want      string
+	}{
+		{"-encoders", encodersOut, "a64multi aac hevc_nvenc libopus libx264 mov_text mpeg2video"},
+		{"-codecs", codecsOut, "aac h264"},
+		{"-filters", filtersOut, "abench amix scale testsrc"},
+		{"-encoders", "", ""},
+	} {
+		var got []string
+		for name := range parseList(tt.out, tt.flag) {
+			got = append(got, name)
+		}
+		sort.Strings(got)
+		if s := strings.Join(got, " "); s != tt.want {
+			t.Errorf("parseList(%s) = %q, want %q", tt.flag, s, tt.want)
+		}
+	}
+}
+
+func TestMissing(t *testing.T) {
+	c := Features{Encoders: parseList(encodersOut, "-encoders"), Filters: parseList(filtersOut, "-filters")}
+	for name := range parseList(codecsOut, "-codecs") {
+		c.Encoders[name] = true
+	}
+	for _, tt := range []struct {
+		args     string
+		enc, flt string
+	}{
+		{"-i in.mp4 -c:v libx264 -c:a aac out.mp4", "", ""},
+		{"-i in.mp4 -c:v hevc_nvenc -vf scale=1280:720 out.mp4", "", ""},
+		{"-i in.mp4 -c:v h264 out.mp4", "", ""},
+		{"-c:v vp6 -i in.flv -c:v libx264 out.mp4", "", ""},
+		{"-i in.mp4 -c:v vp6 -vf scale=640:360,unsharp out.mp4", "vp6", "unsharp"},
+	} {
+		enc, flt := c.missing(strings.Fields("ffmpeg " + tt.args))
+		if strings.Join(enc, " ") != tt.enc || strings.Join(flt, " ") != tt.flt {
+			t.Errorf("missing(%s) = %q %q, want %q %q", tt.args, enc, flt, tt.enc, tt.flt)
+		}
+	}
+}
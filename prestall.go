@@ -0,0 +1,22 @@
+package main
+
+import "time"
+
+// preStall fails the job when ffmpeg prints status lines for this long
+// without encoding its first frame. MAXSTALL only arms after frame zero,
+// and STARTTIMEOUT counts from launch and is satisfied by any output,
+// so a source that opens but never decodes waits out neither quickly.
+// default=0 (disabled)
+var preStall = envDur("PRESTALL")
+
+// preStalled returns how long ffmpeg has been at frame zero since its
+// first status line, and whether that's over PRESTALL. Audio only jobs
+// never count frames and are exempt.
+func preStalled(s State, now time.Time) (time.Duration, bool) {
+	first, video := firstStatus()
+	if preStall <= 0 || first.IsZero() || !video || s.Frame > 0 {
+		return 0, false
+	}
+	d := now.Sub(first)
+	return d, d > preStall
+}
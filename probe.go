@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/as/log"
+)
+
+// probeCmd runs a probe command and returns its standard output. It
+// gives up after 30s, or sooner if ctx is done. Tests replace it to
+// avoid needing minfo or ffprobe installed.
+var probeCmd = func(ctx context.Context, name string, args ...string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	return exec.CommandContext(ctx, name, args...).Output()
+}
+
+// resolveDuration returns the duration of the media file at path in
+// seconds. It prefers minfo when installed and falls back to ffprobe,
+// which ships with ffmpeg.
+func resolveDuration(path string) (float64, error) {
+	name, args := ffprobePath(), []string{"-v", "error", "-show_entries", "format=duration", "-of", "csv=p=0", path}
+	if lookPath("minfo") {
+		name, args = "minfo", []string{"-d", path}
+	}
+	out, err := probeCmd(context.Background(), name, args...)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %s: %w", name, path, err)
+	}
+	dur, err := strconv.ParseFloat(strings.TrimSpace(string(out)), 64)
+	if err != nil || dur <= 0 || math.IsInf(dur, 0) || math.IsNaN(dur) {
+		return 0, fmt.Errorf("%s: %s: bad duration %q", name, path, strings.TrimSpace(string(out)))
+	}
+	return dur, nil
+}
+
+// Media is the subset of ffprobe output used to derive progress targets
+type Media struct {
+	Duration float64
+	Frames   int
+	FPS      float64
+}
+
+// probeMedia runs ffprobe on input and returns the container duration and
+// the first video stream's frame count, estimated from the frame rate when
+// the container doesn't record it
+func probeMedia(ctx context.Context, input string) (m Media, err error) {
+	out, err := probeCmd(ctx, ffprobePath(), "-v", "error", "-select_streams", "v:0",
+		"-show_entries", "format=duration:stream=nb_frames,avg_frame_rate", "-of", "json", input)
+	if err != nil {
+		return m, fmt.Errorf("ffprobe: %s: %w", input, err)
+	}
+	var v struct {
+		Format struct {
+			Duration string `json:"duration"`
+		} `json:"format"`
+		Streams []struct {
+			Frames string `json:"nb_frames"`
+			Rate   string `json:"avg_frame_rate"`
+		} `json:"streams"`
+	}
+	if err = json.Unmarshal(out, &v); err != nil {
+		return m, fmt.Errorf("ffprobe: %s: %w", input, err)
+	}
+	m.Duration, _ = strconv.ParseFloat(v.Format.Duration, 64)
+	if len(v.Streams) > 0 {
+		st := v.Streams[0]
+		m.Frames, _ = strconv.Atoi(st.Frames)
+		var num, den float64
+		if n, _ := fmt.Sscanf(st.Rate, "%f/%f", &num, &den); n == 2 && den > 0 {
+			m.FPS = num / den
+		}
+		if m.Frames == 0 {
+			m.Frames = int(m.Duration * m.FPS)
+		}
+	}
+	return m, nil
+}
+
+var (
+	// autoprobe derives DUR and FRAMES from the first input with
+	// ffprobe when they aren't set explicitly
+	autoprobe = os.Getenv("AUTOPROBE") == "1"
+
+	// probeTimeout bounds the time spent probing. default=10s
+	probeTimeout = envDur("PROBE_TIMEOUT")
+)
+
+func firstInput(args []string) string {
+	for i := 1; i < len(args); i++ {
+		if args[i-1] == "-i" {
+			return args[i]
+		}
+	}
+	return ""
+}
+
+// trimDur returns how many seconds of the first input the command
+// encodes, or zero for all of it. -t is a duration and wins. -to is an
+// end position, so an -ss on the same side of the -i is subtracted from
+// it. An output -to after an input -ss is already relative to the seek,
+// since the timestamps start over at zero.
+func trimDur(args []string) float64 {
+	var t, to, ss string
+	var toIn, ssIn bool
+	input := true // before the first -i
+	for i := 1; i < len(args); i++ {
+		switch v := args[i]; args[i-1] {
+		case "-i":
+			input = false
+		case "-t":
+			if t == "" {
+				t = v
+			}
+		case "-to":
+			if to == "" {
+				to, toIn = v, input
+			}
+		case "-ss":
+			if ss == "" {
+				ss, ssIn = v, input
+			}
+		}
+	}
+	if t != "" {
+		dur, _ := stringDur(t)
+		return dur.Seconds()
+	}
+	if to == "" {
+		return 0
+	}
+	end, _ := stringDur(to)
+	if ss != "" && ssIn == toIn {
+		start, _ := stringDur(ss)
+		end -= start
+	}
+	return math.Max(0, end.Seconds())
+}
+
+// autoProbe sets targetDur and targetFrames from the first input, limited
+// by any -t or -to on the command line
+func autoProbe(args []string) {
+	if list := concatList(args); list != "" {
+		autoConcat(args, list)
+		return
+	}
+	input := firstInput(args)
+	ln := log.Info.Add("topic", "probe", "action", "autoprobe", "input", redact(input))
+	if input == "" || input == "-" || strings.HasPrefix(input, "pipe:") {
+		ln.Printf("input can't be probed, skipping")
+		return
+	}
+	timeout := probeTimeout
+	if timeout == 0 {
+		timeout = 10 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	m, err := probeMedia(ctx, input)
+	if err != nil {
+		ln.Warn().Add("err", err).Printf("probe failed, progress targets unchanged")
+		return
+	}
+
+	dur, frames := m.Duration, m.Frames
+	trim := trimDur(args)
+	if trim > 0 && (dur == 0 || trim < dur) {
+		dur = trim
+		if m.FPS > 0 {
+			frames = int(trim * m.FPS)
+		}
+	}
+	if os.Getenv("DUR") == "" && dur > 0 {
+		targetDur = floatDur(dur)
+	}
+	if os.Getenv("FRAMES") == "" && frames > 0 {
+		targetFrames = frames
+	}
+	ln.Add(
+		"probe_duration", m.Duration, "probe_frames", m.Frames, "probe_fps", m.FPS, "trim", trim,
+		"target_duration", targetDur.Seconds(), "target_frames", targetFrames,
+	).Printf("derived progress targets")
+}
+
+// autoConcat sets targetDur from the entries of a concat list
+func autoConcat(args []string, list string) {
+	ln := log.Info.Add("topic", "probe", "action", "autoprobe", "input", redact(list), "format", "concat")
+	dur, ok := concatDuration(list)
+	ln = ln.Add(concatFields()...)
+	if !ok {
+		ln.Warn().Printf("concat list not fully probed, progress targets unchanged")
+		return
+	}
+	if trim := trimDur(args); trim > 0 && trim < dur {
+		dur = trim
+	}
+	if os.Getenv("DUR") == "" {
+		targetDur = floatDur(dur)
+	}
+	ln.Add("probe_duration", dur, "target_duration", targetDur.Seconds()).Printf("derived progress targets")
+}
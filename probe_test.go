@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// fakeProbe replaces probeCmd with one that prints out, or fails with
+// err, and records the command it ran. minfo is on PATH when minfo is set.
+func fakeProbe(t *testing.T, minfo bool, out string, err error) (ran *string) {
+	t.Helper()
+	if runtime.GOOS == "windows" || runtime.GOOS == "plan9" {
+		t.Skip("needs an executable minfo on PATH")
+	}
+	dir := t.TempDir()
+	if minfo {
+		os.WriteFile(filepath.Join(dir, "minfo"), []byte("#!/bin/sh\n"), 0755)
+	}
+	t.Setenv("PATH", dir)
+	ran = new(string)
+	restore(t, &probeCmd)
+	probeCmd = func(ctx context.Context, name string, args ...string) ([]byte, error) {
+		*ran = name
+		return []byte(out), err
+	}
+	return ran
+}
+
+func TestResolveDuration(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		minfo bool
+		out   string
+		err   error
+		want  float64
+		ran   string
+		ok    bool
+	}{
+		{"minfo", true, "12.5\n", nil, 12.5, "minfo", true},
+		{"ffprobe fallback", false, "12.500000\n", nil, 12.5, "ffprobe", true},
+		{"probe failed", false, "", errors.New("exit status 1"), 0, "ffprobe", false},
+		{"N/A", false, "N/A\n", nil, 0, "ffprobe", false},
+		{"zero", true, "0\n", nil, 0, "minfo", false},
+		{"inf", false, "inf\n", nil, 0, "ffprobe", false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			ran := fakeProbe(t, tt.minfo, tt.out, tt.err)
+			dur, err := resolveDuration("in.mp4")
+			if dur != tt.want || (err == nil) != tt.ok || *ran != tt.ran {
+				t.Errorf("resolveDuration = %v, %v via %s, want %v, ok=%v via %s", dur, err, *ran, tt.want, tt.ok, tt.ran)
+			}
+		})
+	}
+}
+
+func TestProbeMedia(t *testing.T) {
+	defer func(p string) { ffmpegPath = p }(ffmpegPath)
+	ffmpegPath = "/opt/ffmpeg-6.1/bin/ffmpeg"
+	for _, tt := range []struct {
+		name, out string
+		want      Media
+	}{
+		{"frames", `{"format": {"duration": "10.000000"}, "streams": [{"nb_frames": "250", "avg_frame_rate": "25/1"}]}`, Media{10, 250, 25}},
+		{"estimated", `{"format": {"duration": "10.000000"}, "streams": [{"nb_frames": "N/A", "avg_frame_rate": "30000/1001"}]}`, Media{10, 299, 30000.0 / 1001}},
+		{"audio only", `{"format": {"duration": "3.5"}}`, Media{Duration: 3.5}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			ran := fakeProbe(t, false, tt.out, nil)
+			m, err := probeMedia(context.Background(), "in.mp4")
+			if err != nil || m != tt.want || *ran != "/opt/ffmpeg-6.1/bin/ffprobe" {
+				t.Errorf("probeMedia = %+v, %v via %s, want %+v via the ffprobe next to FFMPEG_PATH", m, err, *ran, tt.want)
+			}
+		})
+	}
+	fakeProbe(t, false, "", errors.New("exit status 1"))
+	if _, err := probeMedia(context.Background(), "in.mp4"); err == nil {
+		t.Errorf("probeMedia ignored the failed probe")
+	}
+}
+
+func TestTrimDur(t *testing.T) {
+	for _, tt := range []struct {
+		args string
+		want float64
+	}{
+		{"-i in.mp4 out.mp4", 0},
+		{"-i in.mp4 -t 5 out.mp4", 5},
+		{"-t 00:00:05 -i in.mp4 out.mp4", 5},
+		{"-i in.mp4 -to 20 out.mp4", 20},
+		{"-ss 5 -to 20 -i in.mp4 out.mp4", 15},
+		{"-i in.mp4 -ss 5 -to 20 out.mp4", 15},
+		{"-ss 5 -i in.mp4 -to 20 out.mp4", 20},
+		{"-i in.mp4 -ss 00:01:00 -to 00:01:30 out.mp4", 30},
+		{"-i in.mp4 -ss 5 -to 20 -t 3 out.mp4", 3},
+		{"-i in.mp4 -ss 30 -to 20 out.mp4", 0},
+	} {
+		if got := trimDur(strings.Fields(tt.args)); got != tt.want {
+			t.Errorf("trimDur(%s) = %v, want %v", tt.args, got, tt.want)
+		}
+	}
+}
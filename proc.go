@@ -0,0 +1,189 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// killGrace is how long the kill paths wait after SIGTERM before
+// sending SIGKILL to ffmpeg's process group. default=5s
+var killGrace = envDur("KILL_GRACE")
+
+func init() {
+	if killGrace <= 0 {
+		killGrace = 5 * time.Second
+	}
+}
+
+// childpid is the pid of the running ffmpeg process, or zero
+var childpid int64
+
+// killed is set once a kill path has sent SIGKILL, so the summary
+// doesn't blame the oom killer for it
+var killed int32
+
+func setChild(pid int) { atomic.StoreInt64(&childpid, int64(pid)) }
+func child() int       { return int(atomic.LoadInt64(&childpid)) }
+
+// interrupt stops ffmpeg the way ^C would, so it finalizes the output
+func interrupt() error {
+	pid := child()
+	if pid == 0 {
+		return errors.New("ffmpeg not running")
+	}
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return err
+	}
+	return interruptProcess(p)
+}
+
+// clktck is USER_HZ, which is 100 on every linux we run on
+const clktck = 100
+
+// ProcSample is a point-in-time reading of the child's /proc counters
+type ProcSample struct {
+	At      time.Time
+	CPU     time.Duration // utime+stime
+	BlkIO   time.Duration // delayacct_blkio_ticks
+	Threads int
+	Rchar   int64
+	Wchar   int64
+}
+
+// sampleProc reads /proc/<pid>/stat and /proc/<pid>/io. The process
+// may exit at any moment, so a failed read just returns ok=false.
+func sampleProc(pid int) (s ProcSample, ok bool) {
+	if pid == 0 || !caps.Proc {
+		return s, false
+	}
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return s, false
+	}
+	// the comm field can contain spaces, so start after its closing paren
+	stat := string(data)
+	if i := strings.LastIndexByte(stat, ')'); i >= 0 {
+		stat = stat[i+1:]
+	}
+	f := strings.Fields(stat)
+	if len(f) < 40 {
+		return s, false
+	}
+	var utime, stime, blkio int64
+	fmt.Sscan(f[11], &utime)
+	fmt.Sscan(f[12], &stime)
+	fmt.Sscan(f[17], &s.Threads)
+	fmt.Sscan(f[39], &blkio)
+	s.CPU = ticks(utime + stime)
+	s.BlkIO = ticks(blkio)
+	s.At = time.Now()
+
+	if caps.IO {
+		data, err = os.ReadFile(fmt.Sprintf("/proc/%d/io", pid))
+		if err == nil {
+			for _, line := range split(string(data), "\n") {
+				fmt.Sscanf(line, "rchar: %d", &s.Rchar)
+				fmt.Sscanf(line, "wchar: %d", &s.Wchar)
+			}
+		}
+	}
+	return s, true
+}
+
+func ticks(n int64) time.Duration {
+	return time.Duration(n) * time.Second / clktck
+}
+
+// CPUUsage tracks the child's cpu percentage between samples. 100 is
+// one core, so a busy encoder can report several hundred.
+type CPUUsage struct {
+	pct     float64
+	threads int
+	ok      bool
+
+	sum  float64
+	n    int
+	peak float64
+}
+
+// Add records the usage between s0 and s1. Either may be missing
+// when the process started or exited between ticks.
+func (c *CPUUsage) Add(s0, s1 ProcSample) {
+	wall := s1.At.Sub(s0.At).Seconds()
+	c.ok = !s0.At.IsZero() && !s1.At.IsZero() && wall > 0
+	if !c.ok {
+		return
+	}
+	c.pct = math.Max(0, round100((s1.CPU-s0.CPU).Seconds()/wall*100))
+	c.threads = s1.Threads
+	c.sum += c.pct
+	c.n++
+	c.peak = math.Max(c.peak, c.pct)
+}
+
+func (c *CPUUsage) Fields() []any {
+	return []any{"cpu_pct", avail(c.ok, c.pct), "threads", avail(c.ok, c.threads)}
+}
+
+// Summary returns the average and peak over the run
+func (c *CPUUsage) Summary() []any {
+	if c.n == 0 {
+		return nil
+	}
+	return []any{"cpu_avg", round100(c.sum / float64(c.n)), "cpu_peak", c.peak}
+}
+
+// bound classifies what a slow encode is waiting on between two samples.
+// The byte counters tell the sides apart, iowait alone can't: a process
+// blocked reading its input waits on io just like one blocked writing.
+//
+//	cpu: the encoder is using (nearly) every core it's allowed
+//	output: reading without writing, the writes are blocked
+//	input: nothing is being read
+//	io: both sides move but ffmpeg mostly waits on the disk
+//	gpu: low cpu on a gpu job with io flowing
+func bound(s0, s1 ProcSample, gpu bool) string {
+	wall := s1.At.Sub(s0.At).Seconds()
+	if s0.At.IsZero() || s1.At.IsZero() || wall <= 0 {
+		return ""
+	}
+	cpu := (s1.CPU - s0.CPU).Seconds() / wall
+	iowait := (s1.BlkIO - s0.BlkIO).Seconds() / wall
+	read, wrote := s1.Rchar-s0.Rchar, s1.Wchar-s0.Wchar
+	switch {
+	case cpu >= 0.8*float64(allowedCPUs()):
+		return "cpu"
+	case read > 0 && wrote == 0:
+		return "output"
+	case read == 0:
+		return "input"
+	case iowait > 0.5:
+		return "io"
+	case gpu:
+		return "gpu"
+	}
+	return "input"
+}
+
+// numCPU is the machine's cpu count. Tests replace it.
+var numCPU = runtime.NumCPU
+
+// allowedCPUs is how many cpus ffmpeg may run on, fewer than the
+// machine has when CPUSET pins it
+func allowedCPUs() int {
+	n := numCPU()
+	if cpuset == "" || runtime.GOOS != "linux" {
+		return n
+	}
+	if cpus, err := parseCPUs(cpuset); err == nil && len(cpus) > 0 && len(cpus) < n {
+		return len(cpus)
+	}
+	return n
+}
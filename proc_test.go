@@ -0,0 +1,61 @@
+package main
+
+import (
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestBound(t *testing.T) {
+	defer func(v string) { cpuset = v }(cpuset)
+	cpuset = ""
+	ncpu := time.Duration(runtime.NumCPU())
+	t0 := time.Now()
+	s0 := ProcSample{At: t0, Rchar: 1000, Wchar: 1000}
+	at := func(cpu, blkio time.Duration, read, wrote int64) ProcSample {
+		return ProcSample{At: t0.Add(time.Second), CPU: cpu, BlkIO: blkio, Rchar: 1000 + read, Wchar: 1000 + wrote}
+	}
+	for _, tt := range []struct {
+		name string
+		s1   ProcSample
+		gpu  bool
+		want string
+	}{
+		{"every core", at(ncpu*time.Second, 0, 1e6, 1e5), false, "cpu"},
+		{"reading, writes blocked", at(0, 900*time.Millisecond, 1e6, 0), false, "output"},
+		{"reading, not writing", at(100*time.Millisecond, 0, 1e6, 0), false, "output"},
+		{"input blocked in iowait", at(0, 900*time.Millisecond, 0, 1e5), false, "input"},
+		{"nothing read", at(100*time.Millisecond, 0, 0, 0), false, "input"},
+		{"disk busy both ways", at(100*time.Millisecond, 900*time.Millisecond, 1e6, 1e5), false, "io"},
+		{"gpu job", at(100*time.Millisecond, 0, 1e6, 1e5), true, "gpu"},
+		{"cpu job, io flowing", at(100*time.Millisecond, 0, 1e6, 1e5), false, "input"},
+		{"no second sample", ProcSample{}, false, ""},
+	} {
+		if got := bound(s0, tt.s1, tt.gpu); got != tt.want {
+			t.Errorf("%s: bound = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+// TestBoundCPUSET is an encode pinned to two cores using both of them
+func TestBoundCPUSET(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("CPUSET is linux only")
+	}
+	defer func(v string, n func() int) { cpuset, numCPU = v, n }(cpuset, numCPU)
+	numCPU = func() int { return 8 }
+	t0 := time.Now()
+	s0 := ProcSample{At: t0}
+	s1 := ProcSample{At: t0.Add(time.Second), CPU: 2 * time.Second, Rchar: 1e6, Wchar: 1e5}
+	for _, tt := range []struct{ cpuset, want string }{
+		{"", "input"},
+		{"0-1", "cpu"},
+		{"0,2", "cpu"},
+		{"0-3", "input"},
+	} {
+		cpuset = tt.cpuset
+		if got := bound(s0, s1, false); got != tt.want {
+			t.Errorf("CPUSET=%s: bound = %q, want %q", tt.cpuset, got, tt.want)
+		}
+	}
+}
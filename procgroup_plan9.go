@@ -0,0 +1,24 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"sync/atomic"
+)
+
+// there are no process groups here, ffmpeg is killed on its own
+
+func setProcGroup(cmd *exec.Cmd) {}
+
+func stopGroup(pid int) {
+	if p, err := os.FindProcess(pid); err == nil && pid != 0 {
+		atomic.StoreInt32(&killed, 1)
+		p.Kill()
+	}
+}
+
+func interruptProcess(p *os.Process) error {
+	return p.Signal(os.Interrupt)
+}
+
+func forwardSignals() (stop func()) { return func() {} }
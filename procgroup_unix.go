@@ -0,0 +1,91 @@
+//go:build !windows && !plan9
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// setProcGroup starts ffmpeg in its own process group, so protocol
+// helpers and anything a wrapping sh -c starts can be stopped with it
+func setProcGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	setDeathSig(cmd.SysProcAttr)
+}
+
+// signalGroup sends sig to every process in pid's group
+func signalGroup(pid int, sig syscall.Signal) error {
+	return syscall.Kill(-pid, sig)
+}
+
+// groupAlive reports whether any process in pid's group is running
+func groupAlive(pid int) bool {
+	return syscall.Kill(-pid, 0) == nil
+}
+
+// waitGroup polls until pid's group is gone or timeout passes
+func waitGroup(pid int, timeout time.Duration) bool {
+	for deadline := time.Now().Add(timeout); time.Now().Before(deadline); {
+		if !groupAlive(pid) {
+			return true
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return !groupAlive(pid)
+}
+
+// stopGroup sends SIGTERM to pid's group and SIGKILL to whatever is
+// left after killGrace
+func stopGroup(pid int) {
+	if pid == 0 || signalGroup(pid, syscall.SIGTERM) != nil {
+		return
+	}
+	if !waitGroup(pid, killGrace) {
+		atomic.StoreInt32(&killed, 1)
+		signalGroup(pid, syscall.SIGKILL)
+	}
+}
+
+func interruptProcess(p *os.Process) error {
+	return p.Signal(os.Interrupt)
+}
+
+// forwardSignals passes the signals a terminal or supervisor sends us on
+// to ffmpeg, which no longer shares our process group. SIGINT is ffmpeg's
+// graceful stop and only goes to ffmpeg, SIGTERM stops the whole group.
+// The returned func stops forwarding.
+func forwardSignals() (stop func()) {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	done := make(chan bool)
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case sig := <-c:
+				pid := child()
+				if pid == 0 {
+					// nothing to forward to, die the way we would have
+					signal.Stop(c)
+					syscall.Kill(os.Getpid(), sig.(syscall.Signal))
+					return
+				}
+				if sig == os.Interrupt {
+					interrupt()
+				} else {
+					go stopGroup(pid)
+				}
+			}
+		}
+	}()
+	return func() {
+		signal.Stop(c)
+		close(done)
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"strconv"
+	"sync/atomic"
+)
+
+// NOTE(as): windows has no process groups we can signal. taskkill /T
+// walks the process tree instead, and /F is TerminateProcess. ffmpeg
+// stays in our console so ^C still reaches it directly.
+
+func setProcGroup(cmd *exec.Cmd) {}
+
+// stopGroup kills ffmpeg and everything it started. Console programs
+// ignore a polite taskkill, so this is forceful right away.
+func stopGroup(pid int) {
+	if pid == 0 {
+		return
+	}
+	atomic.StoreInt32(&killed, 1)
+	if exec.Command("taskkill", "/T", "/F", "/PID", strconv.Itoa(pid)).Run() == nil {
+		return
+	}
+	if p, err := os.FindProcess(pid); err == nil {
+		p.Kill()
+	}
+}
+
+// interruptProcess can't deliver ^C to a single process, see stopGroup
+func interruptProcess(p *os.Process) error {
+	go stopGroup(p.Pid)
+	return nil
+}
+
+func forwardSignals() (stop func()) { return func() {} }
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/as/log"
+)
+
+var (
+	// progressFile, if set, is rewritten with the json status on every
+	// LOGFREQ tick, for sidecars that don't want to parse the log
+	progressFile = os.Getenv("PROGRESS_FILE")
+
+	// progressCleanup removes PROGRESS_FILE at exit instead of leaving
+	// the final status with its outcome
+	progressCleanup = os.Getenv("PROGRESS_FILE_CLEANUP") == "1"
+)
+
+// ProgressFile writes the status to PROGRESS_FILE. Writes go to a
+// temporary file that's renamed over the old one, so readers never see
+// a partial document.
+type ProgressFile struct {
+	path string
+	doc  map[string]any
+}
+
+// openProgressFile returns nil when PROGRESS_FILE is unset. The final
+// status is written at exit.
+func openProgressFile() *ProgressFile {
+	if progressFile == "" {
+		return nil
+	}
+	f := &ProgressFile{path: progressFile}
+	atExit(func() {
+		if progressCleanup {
+			os.Remove(f.path)
+			return
+		}
+		if f.doc == nil {
+			f.doc = statusDoc(State{}, 0)
+		}
+		f.doc["outcome"] = outcome
+		f.write()
+	})
+	return f
+}
+
+// Update writes s to the file. It's a no-op on a nil ProgressFile.
+func (f *ProgressFile) Update(s State, progress int) {
+	if f == nil {
+		return
+	}
+	f.doc = statusDoc(s, progress)
+	f.write()
+}
+
+func (f *ProgressFile) write() {
+	f.doc["pid"] = os.Getpid()
+	f.doc["start"] = procstart.UTC().Format(time.RFC3339Nano)
+	f.doc["updated"] = time.Now().UTC().Format(time.RFC3339Nano)
+	err := writeAtomic(f.path, f.doc)
+	if err != nil {
+		log.Warn.Add("topic", "progressfile", "action", "write", "file", f.path, "err", err).Printf("cant write progress file")
+	}
+}
+
+// writeAtomic writes v as json to a temp file next to path and renames
+// it over path
+func writeAtomic(path string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(append(data, '\n'))
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func readProgress(t *testing.T, path string) (doc map[string]any) {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatalf("partial or invalid document %q: %v", data, err)
+	}
+	return doc
+}
+
+func TestProgressFile(t *testing.T) {
+	defer func(f string, c bool, o string) { progressFile, progressCleanup, outcome = f, c, o }(progressFile, progressCleanup, outcome)
+	dir := t.TempDir()
+	progressFile, progressCleanup = filepath.Join(dir, "progress.json"), false
+
+	f := openProgressFile()
+	f.Update(State{Frame: 250, Speed: 2}, 40)
+	doc := readProgress(t, progressFile)
+	if doc["frame"] != 250.0 || doc["progress"] != 40.0 || doc["outcome"] != "running" || doc["pid"] != float64(os.Getpid()) {
+		t.Errorf("progress file %v", doc)
+	}
+
+	outcome = "done"
+	runExitHooks()
+	if doc := readProgress(t, progressFile); doc["outcome"] != "done" || doc["frame"] != 250.0 {
+		t.Errorf("final progress file %v, want outcome done at frame 250", doc)
+	}
+	if ents, _ := os.ReadDir(dir); len(ents) != 1 {
+		t.Errorf("left temp files behind: %v", ents)
+	}
+
+	progressCleanup = true
+	openProgressFile().Update(State{Frame: 1}, 1)
+	runExitHooks()
+	if _, err := os.Stat(progressFile); !os.IsNotExist(err) {
+		t.Errorf("PROGRESS_FILE_CLEANUP=1 left the file: %v", err)
+	}
+
+	var nilf *ProgressFile
+	nilf.Update(State{}, 0)
+}
+
+// TestProgressFileAtomic reads the file while it's rewritten. Every read
+// must see a whole document.
+func TestProgressFileAtomic(t *testing.T) {
+	defer func(f string) { progressFile = f }(progressFile)
+	progressFile = filepath.Join(t.TempDir(), "progress.json")
+	f := &ProgressFile{path: progressFile}
+	f.Update(State{Frame: 0}, 0)
+
+	var wg sync.WaitGroup
+	stop := make(chan bool)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 1; i <= 200; i++ {
+			f.Update(State{Frame: i, SizeRaw: string(make([]byte, i*50))}, i/2)
+		}
+		close(stop)
+	}()
+	for {
+		select {
+		case <-stop:
+			wg.Wait()
+			return
+		default:
+			readProgress(t, progressFile)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	crand "crypto/rand"
+	"encoding/binary"
+	"math/rand"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/as/log"
+)
+
+// seed is the source of all randomness in the process. FFJSON_SEED makes
+// runs reproducible; otherwise it comes from crypto/rand. Every feature
+// that needs randomness must use random() so a fixed seed covers it,
+// except for names that mustn't be guessable, like temp files. Those use
+// os.CreateTemp, since the seed is logged with every job.
+var seed = newSeed()
+
+var rng = struct {
+	sync.Mutex
+	*rand.Rand
+}{Rand: rand.New(rand.NewSource(seed))}
+
+func newSeed() int64 {
+	if s := os.Getenv("FFJSON_SEED"); s != "" {
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err == nil {
+			return n
+		}
+		log.Warn.Add("topic", "env", "action", "badarg", "var", "FFJSON_SEED", "err", err).Printf("ignoring invalid FFJSON_SEED")
+	}
+	var b [8]byte
+	crand.Read(b[:])
+	return int64(binary.LittleEndian.Uint64(b[:]))
+}
+
+// random returns a non-negative pseudo-random number in [0, n)
+func random(n int64) int64 {
+	rng.Lock()
+	defer rng.Unlock()
+	return rng.Int63n(n)
+}
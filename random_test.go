@@ -0,0 +1,44 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func reseed(n int64) {
+	rng.Lock()
+	defer rng.Unlock()
+	seed, rng.Rand = n, rand.New(rand.NewSource(n))
+}
+
+// backoffs returns the backoff before each of the first n retries
+func backoffs(n int) (d []time.Duration) {
+	for i := 0; i < n; i++ {
+		d = append(d, retryBackoff(i))
+	}
+	return d
+}
+
+func TestRetryBackoff(t *testing.T) {
+	defer func(s int64) { reseed(s) }(seed)
+
+	reseed(42)
+	first := backoffs(8)
+	for i, d := range first {
+		base := time.Duration(math.Min(30, math.Pow(2, float64(i)))) * time.Second
+		if d < base || d >= base+base/4 {
+			t.Errorf("retry %d: backoff %s, want %s plus under 25%% jitter", i, d, base)
+		}
+	}
+	reseed(42)
+	if second := backoffs(8); !reflect.DeepEqual(first, second) {
+		t.Fatalf("backoffs differ with the same seed:\n%v\n%v", first, second)
+	}
+	reseed(43)
+	if other := backoffs(8); reflect.DeepEqual(first, other) {
+		t.Fatalf("a different seed gave the same backoffs %v", other)
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/as/ffmpeg-json/ffmpegjson"
+	"github.com/as/log"
+)
+
+// redactPatterns, if set, is a comma separated list of regular
+// expressions. Whatever they match in a logged argument or URL is
+// replaced with [redacted], or only the first group if they have one.
+var redactPatterns = compileRedact(os.Getenv("REDACT_PATTERNS"))
+
+// keepRawStderr=1 writes ffmpeg's output to the stderr file as is. The
+// lines the wrapper logs are redacted regardless.
+var keepRawStderr = os.Getenv("KEEP_RAW_STDERR") == "1"
+
+// redactFlags are the ffmpeg options whose values are always secret
+var redactFlags = map[string]bool{
+	"-headers": true, "-decryption_key": true, "-encryption_key": true,
+	"-cenc_decryption_key": true, "-cenc_encryption_key": true,
+	"-key": true, "-password": true, "-passphrase": true,
+}
+
+// redactParams are the query parameters of signed urls, lowercase
+var redactParams = map[string]bool{
+	"x-amz-signature": true, "x-amz-credential": true, "x-amz-security-token": true,
+	"signature": true, "policy": true, "key-pair-id": true, "sig": true,
+	"token": true, "access_token": true, "key": true, "password": true, "secret": true,
+	"passphrase": true, // srt
+}
+
+// urlRE matches a url anywhere in an argument, i.e. inside a filter or
+// after a protocol prefix like concat: or crypto+. Filter graph
+// separators end it.
+var urlRE = regexp.MustCompile(`[a-zA-Z][a-zA-Z0-9+.-]*://[^\s'"|;\[\]]+`)
+
+func compileRedact(v string) (re []*regexp.Regexp) {
+	for _, p := range strings.Split(v, ",") {
+		if p = strings.TrimSpace(p); p == "" {
+			continue
+		}
+		r, err := regexp.Compile(p)
+		if err != nil {
+			log.Warn.Add("topic", "env", "action", "badarg", "var", "REDACT_PATTERNS", "pattern", p, "err", err).Printf("ignoring invalid redact pattern")
+			continue
+		}
+		re = append(re, r)
+	}
+	return re
+}
+
+// redactArgs returns a copy of args that's safe to log. ffmpeg must
+// still be run with args.
+func redactArgs(args []string) []string {
+	out := make([]string, len(args))
+	for i, a := range args {
+		if i > 0 && redactFlags[args[i-1]] {
+			out[i] = redacted
+			continue
+		}
+		out[i] = redact(a)
+	}
+	return out
+}
+
+// redact removes the credentials and signatures from the urls in s and
+// applies REDACT_PATTERNS
+func redact(s string) string {
+	if len(redactPatterns) == 0 && !strings.Contains(s, "://") {
+		return s
+	}
+	s = urlRE.ReplaceAllStringFunc(s, redactURL)
+	for _, re := range redactPatterns {
+		s = re.ReplaceAllStringFunc(s, func(m string) string {
+			sub := re.FindStringSubmatchIndex(m)
+			if len(sub) < 4 || sub[2] < 0 {
+				return redacted
+			}
+			return m[:sub[2]] + redacted + m[sub[3]:]
+		})
+	}
+	return s
+}
+
+// redactURL strips the userinfo from u and redacts the values of the
+// signing parameters in its query. The rest is left as it was, not
+// re-encoded, so it still reads like the argument it came from.
+func redactURL(u string) string {
+	scheme := strings.Index(u, "://") + 3
+	rest := u[scheme:]
+	host := strings.IndexAny(rest, "/?#")
+	if host < 0 {
+		host = len(rest)
+	}
+	if at := strings.LastIndex(rest[:host], "@"); at >= 0 {
+		rest = redacted + rest[at:]
+	}
+	q := strings.Index(rest, "?")
+	if q < 0 {
+		return u[:scheme] + rest
+	}
+	query, frag := rest[q+1:], ""
+	if f := strings.Index(query, "#"); f >= 0 {
+		query, frag = query[:f], query[f:]
+	}
+	params := strings.Split(query, "&")
+	for i, p := range params {
+		k, _, ok := strings.Cut(p, "=")
+		if ok && redactParams[strings.ToLower(k)] {
+			params[i] = k + "=" + redacted
+		}
+	}
+	return u[:scheme] + rest[:q+1] + strings.Join(params, "&") + frag
+}
+
+// Redactor redacts what's written through it a line at a time, so a url
+// split across two writes is still caught. A line is ended by \r or \n.
+type Redactor struct {
+	w   io.Writer
+	buf []byte
+}
+
+func NewRedactor(w io.Writer) *Redactor {
+	return &Redactor{w: w}
+}
+
+func (r *Redactor) Write(p []byte) (int, error) {
+	r.buf = append(r.buf, p...)
+	n := bytes.LastIndexAny(r.buf, "\r\n") + 1
+	if n == 0 && len(r.buf) < ffmpegjson.MaxLine {
+		return len(p), nil
+	}
+	if n == 0 {
+		n = len(r.buf)
+	}
+	_, err := io.WriteString(r.w, redact(string(r.buf[:n])))
+	r.buf = append(r.buf[:0], r.buf[n:]...)
+	return len(p), err
+}
+
+// Flush writes the unterminated line left over from the last write. It's
+// a no-op on a nil Redactor.
+func (r *Redactor) Flush() error {
+	if r == nil || len(r.buf) == 0 {
+		return nil
+	}
+	_, err := io.WriteString(r.w, redact(string(r.buf)))
+	r.buf = r.buf[:0]
+	return err
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/as/log"
+)
+
+// Rule rewrites the value of every occurrence of Flag. Either Match is
+// replaced with Replace, or the value is passed through the named Transform.
+// Rules with a non-empty When only run when that condition is detected.
+//
+//	{"flag":"-vf","match":"scale_npp=","replace":"scale_cuda=","when":"filterbug"}
+//	{"flag":"-t","transform":"duration_of_file"}
+type Rule struct {
+	Flag      string `json:"flag"`
+	Match     string `json:"match,omitempty"`
+	Replace   string `json:"replace,omitempty"`
+	Transform string `json:"transform,omitempty"`
+	When      string `json:"when,omitempty"`
+}
+
+var (
+	// argrewrite, if set, is a json file containing a list of
+	// rules applied after the default rules
+	argrewrite = os.Getenv("ARGREWRITE")
+
+	// durflags lists additional flags whose file name values are replaced
+	// with that file's duration, i.e. DURFLAGS=-ss,-to. -t is always included
+	durflags = os.Getenv("DURFLAGS")
+)
+
+var defaultRules = []Rule{
+	{Flag: "-t", Transform: "duration_of_file"},
+	{Flag: "-extra_hw_frames", Transform: "grow", When: "hwframes"},
+}
+
+var transforms = map[string]func(string) (string, error){
+	"duration_of_file": durationOfFile,
+	"increment": func(v string) (string, error) {
+		n, err := strconv.Atoi(v)
+		return fmt.Sprint(n + 1), err
+	},
+	"grow": func(v string) (string, error) {
+		n, err := strconv.Atoi(v)
+		return fmt.Sprint(growFrames(n)), err
+	},
+}
+
+var conditions = map[string]bool{"": true, "filterbug": true, "hwframes": true}
+
+// rules are loaded at startup by main
+var rules []Rule
+
+func loadRules() []Rule {
+	rules := append([]Rule{}, defaultRules...)
+	for _, flag := range strings.FieldsFunc(durflags, func(r rune) bool { return r == ',' || r == ' ' }) {
+		if flag != "-t" {
+			rules = append(rules, Rule{Flag: flag, Transform: "duration_of_file"})
+		}
+	}
+	if argrewrite == "" {
+		return rules
+	}
+	data, err := os.ReadFile(argrewrite)
+	if err != nil {
+		fatal(log.Fatal.Add("topic", "transcode", "action", "badarg", "file", argrewrite, "err", err), "cant read rewrite rules")
+	}
+	var extra []Rule
+	if err = json.Unmarshal(data, &extra); err != nil {
+		fatal(log.Fatal.Add("topic", "transcode", "action", "badarg", "file", argrewrite, "err", err), "cant parse rewrite rules")
+	}
+	for i, r := range extra {
+		if err := r.check(); err != nil {
+			fatal(log.Fatal.Add("topic", "transcode", "action", "badarg", "file", argrewrite, "rule", i, "err", err), "invalid rewrite rule")
+		}
+	}
+	return append(rules, extra...)
+}
+
+func (r Rule) check() error {
+	switch {
+	case !strings.HasPrefix(r.Flag, "-"):
+		return fmt.Errorf("flag %q must start with -", r.Flag)
+	case (r.Match == "") == (r.Transform == ""):
+		return fmt.Errorf("rule needs exactly one of match or transform")
+	case r.Transform != "" && transforms[r.Transform] == nil:
+		return fmt.Errorf("unknown transform %q", r.Transform)
+	case !conditions[r.When]:
+		return fmt.Errorf("unknown condition %q", r.When)
+	}
+	return nil
+}
+
+// rewrite applies every rule for the condition when to args in place
+// and reports whether any value changed
+func rewrite(args []string, when string) (changed bool) {
+	for _, r := range rules {
+		if r.When != when {
+			continue
+		}
+		for i := 1; i < len(args); i++ {
+			if args[i-1] != r.Flag {
+				continue
+			}
+			before, after := args[i], args[i]
+			if r.Transform != "" {
+				v, err := transforms[r.Transform](before)
+				if errors.As(err, new(badArg)) {
+					exitStatus = exitBadArg
+					fatal(log.Fatal.Add("topic", "transcode", "action", "badarg", "flag", r.Flag, "value", before, "err", err), "cant rewrite argument")
+				}
+				if err != nil {
+					log.Warn.Add("topic", "transcode", "action", "rewrite", "flag", r.Flag, "transform", r.Transform, "value", before, "err", err).Printf("rewrite failed, leaving value unchanged")
+					continue
+				}
+				after = v
+			} else {
+				after = strings.ReplaceAll(before, r.Match, r.Replace)
+			}
+			if after == before {
+				continue
+			}
+			args[i] = after
+			changed = true
+			log.Info.Add("topic", "transcode", "action", "rewrite", "flag", r.Flag, "when", r.When, "before", before, "after", after).Printf("applied rewrite rule")
+		}
+	}
+	return changed
+}
+
+// badArg is returned by a transform when the value is unusable and
+// ffmpeg shouldn't be started at all
+type badArg struct{ error }
+
+var durExpr = regexp.MustCompile(`^(.*\pL.*?)([-+*])([0-9]+(?:\.[0-9]+)?)$`)
+
+// durationOfFile replaces a file name with its duration in seconds. Values
+// that aren't existing files, like 00:00:05 or 5ms, are returned as-is.
+//
+// The file name may be followed by arithmetic, so file.mp4-10 is ten
+// seconds less than the duration of file.mp4, and file.mp4*0.5 is half.
+func durationOfFile(v string) (string, error) {
+	if strings.IndexFunc(v, unicode.IsLetter) < 0 {
+		return v, nil
+	}
+	if isFile(v) {
+		dur, err := resolveDuration(v)
+		if err != nil {
+			return v, err
+		}
+		return fmt.Sprintf("%f", dur), nil
+	}
+	m := durExpr.FindStringSubmatch(v)
+	if m == nil {
+		return v, nil
+	}
+	path, op := m[1], m[2]
+	n, err := strconv.ParseFloat(m[3], 64)
+	if err != nil {
+		return v, badArg{fmt.Errorf("bad number in %q: %w", v, err)}
+	}
+	if !isFile(path) {
+		return v, badArg{fmt.Errorf("%q: no such file %q", v, path)}
+	}
+	dur, err := resolveDuration(path)
+	if err != nil {
+		return v, badArg{err}
+	}
+	switch op {
+	case "+":
+		dur += n
+	case "-":
+		dur -= n
+	case "*":
+		dur *= n
+	}
+	if dur <= 0 {
+		return v, badArg{fmt.Errorf("%q: duration is %f", v, dur)}
+	}
+	return fmt.Sprintf("%f", dur), nil
+}
+
+func isFile(path string) bool {
+	fi, err := os.Stat(path)
+	return err == nil && !fi.IsDir()
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDurationOfFile(t *testing.T) {
+	fakeProbe(t, false, "100\n", nil)
+	file := filepath.Join(t.TempDir(), "in.mp4")
+	os.WriteFile(file, nil, 0644)
+	for _, tt := range []struct {
+		v    string
+		want string
+		bad  bool
+	}{
+		{"5", "5", false},
+		{"00:00:05", "00:00:05", false},
+		{"5ms", "5ms", false}, // not a file, passed through
+		{file, "100.000000", false},
+		{file + "-10", "90.000000", false},
+		{file + "+2.5", "102.500000", false},
+		{file + "*0.5", "50.000000", false},
+		{file + "-100", file + "-100", true},
+		{file + "x-10", file + "x-10", true},
+	} {
+		got, err := durationOfFile(tt.v)
+		if got != tt.want || errors.As(err, new(badArg)) != tt.bad {
+			t.Errorf("durationOfFile(%q) = %q, %v, want %q, bad=%v", tt.v, got, err, tt.want, tt.bad)
+		}
+	}
+}
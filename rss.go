@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/as/log"
+)
+
+// maxRSS stops ffmpeg gracefully when its resident memory exceeds this
+// many bytes, before the kernel's oom killer takes the whole pod down
+// with it. Sizes may end in K, M, G or T. A warning is logged at 90%
+var maxRSS = envBytes("MAXRSS")
+
+// procStatus reads /proc/<pid>/status. Tests can replace it to fake
+// a growing process.
+var procStatus = func(pid int) ([]byte, error) {
+	return os.ReadFile(fmt.Sprintf("/proc/%d/status", pid))
+}
+
+// readRSS returns the resident set size of pid in bytes. The process
+// may exit at any moment, so a failed read just returns ok=false.
+func readRSS(pid int) (rss int64, ok bool) {
+	if pid == 0 || !caps.Status {
+		return 0, false
+	}
+	data, err := procStatus(pid)
+	if err != nil {
+		return 0, false
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		var kb int64
+		if _, err := fmt.Sscanf(line, "VmRSS: %d kB", &kb); err == nil {
+			return kb * 1024, true
+		}
+	}
+	return 0, false
+}
+
+// RSSGuard samples the child's memory every tick and enforces maxRSS
+type RSSGuard struct {
+	limit  uint64
+	rss    int64
+	ok     bool
+	warned bool
+}
+
+func NewRSSGuard(limit uint64) *RSSGuard {
+	return &RSSGuard{limit: limit}
+}
+
+// Check samples pid and reports whether it's over the limit
+func (g *RSSGuard) Check(pid int) (over bool) {
+	g.rss, g.ok = readRSS(pid)
+	if !g.ok || g.limit == 0 {
+		return false
+	}
+	if !g.warned && float64(g.rss) >= 0.9*float64(g.limit) {
+		g.warned = true
+		log.Warn.Add("topic", "host", "action", "alert", "subject", "rss", "details", "ffmpeg memory near MAXRSS", "rss", g.rss, "limit", g.limit).Printf("ffmpeg is using %d%% of MAXRSS", g.rss*100/int64(g.limit))
+	}
+	return uint64(g.rss) > g.limit
+}
+
+func (g *RSSGuard) Fields() []any {
+	return []any{"rss", avail(g.ok, g.rss)}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/as/log"
+)
+
+// fakeProc replaces procStatus with a process whose VmRSS is rss[i] kB
+// on the i'th read. Reads past the end fail, as if it had exited.
+func fakeProc(t *testing.T, rss ...int64) {
+	restore(t, &procStatus)
+	restore(t, &caps)
+	caps.Status = true
+	i := 0
+	procStatus = func(pid int) ([]byte, error) {
+		if i >= len(rss) {
+			return nil, errors.New("no such process")
+		}
+		i++
+		return []byte(fmt.Sprintf("Name:\tffmpeg\nVmPeak:\t 9999999 kB\nVmRSS:\t%8d kB\nThreads:\t9\n", rss[i-1])), nil
+	}
+}
+
+func TestReadRSS(t *testing.T) {
+	fakeProc(t, 2048)
+	if rss, ok := readRSS(42); rss != 2048<<10 || !ok {
+		t.Errorf("readRSS = %d, %v, want %d", rss, ok, 2048<<10)
+	}
+	if _, ok := readRSS(42); ok {
+		t.Errorf("readRSS of an exited process is ok")
+	}
+	if _, ok := readRSS(0); ok {
+		t.Errorf("readRSS without a child is ok")
+	}
+}
+
+// TestRSSGuard grows the child past MAXRSS: a warning at 90%, then over
+func TestRSSGuard(t *testing.T) {
+	buf := new(bytes.Buffer)
+	defer log.SetOutput(log.SetOutput(buf))
+	const limit = 100 << 20
+	fakeProc(t, 50<<10, 91<<10, 95<<10, 101<<10)
+	g := NewRSSGuard(limit)
+	for i, want := range []bool{false, false, false, true, false} {
+		if over := g.Check(42); over != want {
+			t.Errorf("check %d: over=%v, want %v", i, over, want)
+		}
+	}
+	if n := strings.Count(buf.String(), `"subject":"rss"`); n != 1 {
+		t.Errorf("warned %d times, want once:\n%s", n, buf)
+	}
+	if kv := g.Fields(); kv[1] != nil {
+		t.Errorf("Fields after the process exited = %v, want rss omitted", kv)
+	}
+
+	fakeProc(t, 500<<10)
+	if NewRSSGuard(0).Check(42) {
+		t.Errorf("over without MAXRSS")
+	}
+}
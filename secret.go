@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const redacted = "[redacted]"
+
+var (
+	// secretHeaders, if set, names a file of http headers (one per line)
+	// sent with every http(s) input via -headers
+	secretHeaders = os.Getenv("SECRET_HEADERS_FILE")
+
+	// secretArgs, if set, names a file of ffmpeg arguments (one per line)
+	// inserted before the first input, i.e. -decryption_key <hex>
+	secretArgs = os.Getenv("SECRET_ARGS_FILE")
+
+	// secretArgv=1 always passes the secrets to ffmpeg on its command
+	// line, where any user on the host can read them. By default ffmpeg
+	// 7 and newer read them from files instead, see Secrets.argv.
+	secretArgv = os.Getenv("SECRET_ARGV") == "1"
+)
+
+// Secrets are the contents of the secret files. They're read once, in
+// main, and only ever logged redacted.
+type Secrets struct {
+	Args    []string // from SECRET_ARGS_FILE
+	Headers []string // from SECRET_HEADERS_FILE
+}
+
+// loadSecrets reads the secret files. It must run in main, where a
+// missing file can fail the job through fatal.
+func loadSecrets() (s Secrets, err error) {
+	if secretArgs != "" {
+		if s.Args, err = readLines(secretArgs); err != nil {
+			return s, err
+		}
+	}
+	if secretHeaders != "" {
+		if s.Headers, err = readLines(secretHeaders); err != nil {
+			return s, err
+		}
+	}
+	return s, nil
+}
+
+func readLines(file string) (lines []string, err error) {
+	fd, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+	sc := bufio.NewScanner(fd)
+	for sc.Scan() {
+		if line := strings.TrimRight(sc.Text(), "\r"); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines, sc.Err()
+}
+
+func (s Secrets) empty() bool {
+	return len(s.Args) == 0 && len(s.Headers) == 0
+}
+
+// viaFiles reports whether ffmpeg can read the secrets from files with
+// its -/option syntax, which keeps them off the command line
+func (s Secrets) viaFiles() bool {
+	return !secretArgv && ffversion.Major >= 7
+}
+
+// merge inserts the secrets into args: the extra args before the first
+// input and -headers before every http(s) input. Each secret option
+// goes through opt, which returns the flag and value to use instead. A
+// value without a flag has an empty flag.
+func (s Secrets) merge(args []string, opt func(flag, v string) (string, string, error)) (out []string, err error) {
+	if s.empty() {
+		return args, nil
+	}
+	var extra []string
+	for i := 0; i < len(s.Args); i++ {
+		flag, v := s.Args[i], ""
+		switch {
+		case !strings.HasPrefix(flag, "-"):
+			flag, v = "", flag
+		case i+1 < len(s.Args) && !strings.HasPrefix(s.Args[i+1], "-"):
+			i++
+			v = s.Args[i]
+		default:
+			extra = append(extra, flag) // a switch, nothing secret
+			continue
+		}
+		if flag, v, err = opt(flag, v); err != nil {
+			return nil, err
+		}
+		if flag != "" {
+			extra = append(extra, flag)
+		}
+		extra = append(extra, v)
+	}
+	hflag, hdr := "", ""
+	if len(s.Headers) > 0 {
+		hflag, hdr, err = opt("-headers", strings.Join(s.Headers, "\r\n")+"\r\n")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	out = make([]string, 0, len(args)+len(extra)+4)
+	for i := 0; i < len(args); i++ {
+		if args[i] == "-i" && i+1 < len(args) {
+			out = append(out, extra...)
+			extra = nil
+			if hdr != "" && isHTTP(args[i+1]) {
+				out = append(out, hflag, hdr)
+			}
+		}
+		out = append(out, args[i])
+	}
+	return out, nil
+}
+
+// redacted returns args with the secrets merged in and every secret
+// value replaced with a placeholder, so the result is safe to log
+func (s Secrets) redacted(args []string) []string {
+	out, _ := s.merge(args, func(flag, v string) (string, string, error) {
+		return flag, redacted, nil
+	})
+	return redactArgs(out)
+}
+
+// argv returns the command line to run ffmpeg with. When ffmpeg can
+// read them from files, every secret value is written to its own file in
+// a private temp dir and passed as -/flag <file>; done removes the dir
+// once ffmpeg has exited.
+func (s Secrets) argv(args []string) (argv []string, done func(), err error) {
+	done = func() {}
+	if s.empty() || !s.viaFiles() {
+		argv, err = s.merge(args, func(flag, v string) (string, string, error) {
+			return flag, v, nil
+		})
+		return argv, done, err
+	}
+	dir, err := os.MkdirTemp("", "ffmpeg-json-secrets")
+	if err != nil {
+		return nil, done, err
+	}
+	done = func() { os.RemoveAll(dir) }
+	n := 0
+	argv, err = s.merge(args, func(flag, v string) (string, string, error) {
+		if flag == "" {
+			return flag, v, nil
+		}
+		n++
+		file := filepath.Join(dir, fmt.Sprint(n))
+		if err := os.WriteFile(file, []byte(v), 0600); err != nil {
+			return "", "", err
+		}
+		return "-/" + flag[1:], file, nil
+	})
+	if err != nil {
+		done()
+		return nil, func() {}, err
+	}
+	return argv, done, nil
+}
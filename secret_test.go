@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/as/log"
+)
+
+const (
+	testKey    = "00112233445566778899aabbccddeeff"
+	testHeader = "Authorization: Bearer s3cr3t-t0ken"
+)
+
+func writeSecrets(t *testing.T) Secrets {
+	t.Helper()
+	dir := t.TempDir()
+	args, headers := filepath.Join(dir, "args"), filepath.Join(dir, "headers")
+	os.WriteFile(args, []byte("-decryption_key\n"+testKey+"\n-nostdin\n"), 0600)
+	os.WriteFile(headers, []byte(testHeader+"\r\n"), 0600)
+	defer func(a, h string) { secretArgs, secretHeaders = a, h }(secretArgs, secretHeaders)
+	secretArgs, secretHeaders = args, headers
+	s, err := loadSecrets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func leaks(s string) bool {
+	return strings.Contains(s, testKey) || strings.Contains(s, "s3cr3t")
+}
+
+func TestLoadSecretsMissing(t *testing.T) {
+	defer func(a string) { secretArgs = a }(secretArgs)
+	secretArgs = filepath.Join(t.TempDir(), "nonexistent")
+	if _, err := loadSecrets(); err == nil {
+		t.Fatal("loadSecrets: missing file: no error")
+	}
+}
+
+func TestSecretsRedacted(t *testing.T) {
+	s := writeSecrets(t)
+	for _, args := range [][]string{
+		{"-i", "in.mp4", "out.mp4"},
+		{"-i", "https://cdn.example.com/in.mp4", "out.mp4"},
+		{"-y", "-i", "in.mp4", "-i", "http://example.com/a.aac", "out.mp4"},
+	} {
+		out := s.redacted(args)
+		if leaks(strings.Join(out, " ")) {
+			t.Errorf("redacted(%q) = %q, has a secret", args, out)
+		}
+		if !hasFlag(out, "-decryption_key") || !hasFlag(out, "-nostdin") {
+			t.Errorf("redacted(%q) = %q, want the secret flags", args, out)
+		}
+	}
+}
+
+func TestSecretsArgv(t *testing.T) {
+	s := writeSecrets(t)
+	defer func(v Version) { ffversion = v }(ffversion)
+	args := []string{"-i", "https://cdn.example.com/in.mp4", "out.mp4"}
+
+	ffversion = Version{Raw: "6.1", Major: 6, Minor: 1}
+	argv, done, err := s.argv(args)
+	done()
+	if err != nil || !leaks(strings.Join(argv, " ")) {
+		t.Fatalf("ffmpeg 6: argv = %q, %v, want the secrets inline", argv, err)
+	}
+
+	ffversion = Version{Raw: "7.0", Major: 7}
+	argv, done, err = s.argv(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if leaks(strings.Join(argv, " ")) {
+		t.Fatalf("ffmpeg 7: argv = %q, has a secret", argv)
+	}
+	var files []string
+	for i := 1; i < len(argv); i++ {
+		if strings.HasPrefix(argv[i-1], "-/") {
+			files = append(files, argv[i])
+		}
+	}
+	if len(files) != 2 {
+		t.Fatalf("ffmpeg 7: argv = %q, want -/decryption_key and -/headers", argv)
+	}
+	key, _ := os.ReadFile(files[0])
+	if string(key) != testKey {
+		t.Errorf("key file has %q, want %q", key, testKey)
+	}
+	done()
+	if _, err := os.Stat(files[0]); !os.IsNotExist(err) {
+		t.Errorf("secret file %s left behind after done", files[0])
+	}
+}
+
+// TestSecretsNotLogged runs a stand-in ffmpeg with the secrets and checks
+// no secret byte reaches the log
+func TestSecretsNotLogged(t *testing.T) {
+	s := writeSecrets(t)
+	defer func(p string, v Version) { ffmpegPath, ffversion = p, v }(ffmpegPath, ffversion)
+	ffmpegPath = "true"
+	buf := new(bytes.Buffer)
+	defer log.SetOutput(log.SetOutput(buf))
+
+	for _, v := range []Version{{Raw: "6.1", Major: 6}, {Raw: "7.1", Major: 7}} {
+		ffversion = v
+		args := []string{"-i", "https://user:pw@cdn.example.com/in.mp4?X-Amz-Signature=abc", "out.mp4"}
+		if err := ffmpeg(context.Background(), io.Discard, s, args...); err != nil {
+			t.Fatalf("ffmpeg %s: %v", v.Raw, err)
+		}
+	}
+	if buf.Len() == 0 {
+		t.Fatal("nothing logged")
+	}
+	if leaks(buf.String()) {
+		t.Fatalf("secret logged:\n%s", buf)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"math"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+var openingRE = regexp.MustCompile(`Opening '(.+)' for writing`)
+
+// segWindow is how many recent segment names are remembered to spot a
+// segment opened twice, i.e. as name.tmp and then name. A live job
+// writes segments for days, so older names are forgotten.
+const segWindow = 16
+
+// segments counts the media segments written by the hls, dash, and
+// segment muxers. Playlists are rewritten constantly and init segments
+// aren't media, so neither is counted.
+var segments struct {
+	sync.Mutex
+	recent []string // the last segWindow segment names
+	n      int
+	last   string
+	opened map[string]bool // files opened for writing when CLEAN_ON_FAIL is set, see cleanup.go
+}
+
+func noteSegment(line string) {
+	m := openingRE.FindStringSubmatch(line)
+	if m == nil {
+		return
+	}
+	segments.Lock()
+	if segments.opened != nil {
+		segments.opened[m[1]] = true
+	}
+	segments.Unlock()
+	name := strings.TrimSuffix(m[1], ".tmp")
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".m3u8", ".mpd":
+		return
+	}
+	if strings.HasPrefix(filepath.Base(name), "init") {
+		return
+	}
+	segments.Lock()
+	defer segments.Unlock()
+	if hasFlag(segments.recent, name) {
+		return
+	}
+	if len(segments.recent) == segWindow {
+		segments.recent = append(segments.recent[:0], segments.recent[1:]...)
+	}
+	segments.recent = append(segments.recent, name)
+	segments.n++
+	segments.last = name
+}
+
+// segmentTime returns the -hls_time or -segment_time value in seconds
+func segmentTime(args []string) float64 {
+	for _, flag := range []string{"-hls_time", "-segment_time", "-seg_duration"} {
+		if v := flagValue(args, flag); v != "" {
+			dur, _ := stringDur(v)
+			return dur.Seconds()
+		}
+	}
+	return 0
+}
+
+// segmentProgress returns the fraction of expected segments completed.
+// The most recently opened segment is still being written.
+func segmentProgress(args []string) (f float64, ok bool) {
+	segtime := segmentTime(args)
+	if targetDur == 0 || segtime == 0 {
+		return 0, false
+	}
+	segments.Lock()
+	n := segments.n
+	segments.Unlock()
+	if n == 0 {
+		return 0, false
+	}
+	expect := math.Ceil(targetDur.Seconds() / segtime)
+	return float64(n-1) / expect, true
+}
+
+func segmentFields() []any {
+	segments.Lock()
+	defer segments.Unlock()
+	if segments.n == 0 {
+		return nil
+	}
+	return []any{"segment", segments.n, "segment_file", segments.last}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func resetSegments() {
+	segments.Lock()
+	defer segments.Unlock()
+	segments.recent, segments.n, segments.last, segments.opened = nil, 0, "", nil
+}
+
+func TestNoteSegment(t *testing.T) {
+	defer resetSegments()
+	for _, tt := range []struct {
+		lines []string
+		n     int
+		last  string
+	}{
+		{[]string{"[hls @ 0x1] Opening 'out0.ts' for writing", "[hls @ 0x1] Opening 'out.m3u8.tmp' for writing", "[hls @ 0x1] Opening 'out1.ts' for writing"}, 2, "out1.ts"},
+		{[]string{"Opening 'seg0.ts.tmp' for writing", "Opening 'seg0.ts' for writing"}, 1, "seg0.ts"},
+		{[]string{"Opening 'init-0.mp4' for writing", "Opening 'x.mpd' for writing", "Opening 'chunk-1.m4s' for writing"}, 1, "chunk-1.m4s"},
+		{[]string{"Stream mapping:"}, 0, ""},
+	} {
+		resetSegments()
+		for _, line := range tt.lines {
+			noteSegment(line)
+		}
+		if segments.n != tt.n || segments.last != tt.last {
+			t.Errorf("%q: %d segments, last %q, want %d, %q", tt.lines, segments.n, segments.last, tt.n, tt.last)
+		}
+	}
+}
+
+// TestNoteSegmentBounded is a long live job, which must not remember
+// every segment name it's written
+func TestNoteSegmentBounded(t *testing.T) {
+	defer resetSegments()
+	resetSegments()
+	const n = 2000
+	for i := 0; i < n; i++ {
+		noteSegment(fmt.Sprintf("Opening 'seg%d.ts.tmp' for writing", i))
+		noteSegment(fmt.Sprintf("Opening 'seg%d.ts' for writing", i))
+	}
+	if segments.n != n || len(segments.recent) > segWindow {
+		t.Fatalf("%d segments, %d remembered, want %d and at most %d", segments.n, len(segments.recent), n, segWindow)
+	}
+}
+
+// TestNoteSegmentOpened checks the files opened for writing are only
+// kept when CLEAN_ON_FAIL needs them, once each
+func TestNoteSegmentOpened(t *testing.T) {
+	defer resetSegments()
+	resetSegments()
+	lines := []string{"Opening 'seg0.ts.tmp' for writing", "Opening 'seg0.ts' for writing", "Opening 'out.m3u8.tmp' for writing", "Opening 'out.m3u8.tmp' for writing"}
+	for _, line := range lines {
+		noteSegment(line)
+	}
+	if segments.opened != nil {
+		t.Fatalf("cleanup off: opened %v, want nothing kept", segments.opened)
+	}
+	segments.opened = map[string]bool{}
+	for _, line := range lines {
+		noteSegment(line)
+	}
+	if len(segments.opened) != 3 || !segments.opened["seg0.ts.tmp"] || !segments.opened["out.m3u8.tmp"] {
+		t.Errorf("cleanup on: opened %v, want the 3 files", segments.opened)
+	}
+}
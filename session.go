@@ -0,0 +1,63 @@
+package main
+
+import (
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/as/log"
+)
+
+var (
+	// sessionFree is the free gpu memory in MiB above which an nvenc
+	// "out of memory" is taken to be the session limit. default=1024
+	sessionFree, _ = strconv.Atoi(os.Getenv("SESSION_FREE_MIB"))
+
+	// sessionWait is the longest to wait for an nvenc session to free
+	// up before retrying anyway. default=5m
+	sessionWait = envDur("SESSION_WAIT")
+)
+
+// sessionLimit reports whether line is nvenc refusing a session because
+// the driver's concurrent session limit was reached. A session "out of
+// memory" with plenty of free memory is the limit in disguise.
+func sessionLimit(line string) bool {
+	if hastext(line, "incompatible client key", "No capable devices found") {
+		return true
+	}
+	if !hastext(line, "OpenEncodeSessionEx failed") || !hastext(line, "out of memory") {
+		return false
+	}
+	if sessionFree == 0 {
+		sessionFree = 1024
+	}
+	g, ok := findGPU(queryGPU(), gpuDevice(os.Args[1:]))
+	return ok && g.Total-g.Used > sessionFree
+}
+
+// gpuSessions returns the encoder session count on the device in use,
+// or -1 if it's unknown
+func gpuSessions() int {
+	if g, ok := findGPU(queryGPU(), gpuDevice(os.Args[1:])); ok {
+		return g.Sessions
+	}
+	return -1
+}
+
+// waitSession polls the encoder session count until it drops below what
+// it was when the wait started or SESSION_WAIT elapses. It returns how
+// long it waited and whether a session freed up.
+func waitSession() (time.Duration, bool) {
+	if sessionWait <= 0 {
+		sessionWait = 5 * time.Minute
+	}
+	start, n := time.Now(), gpuSessions()
+	for time.Since(start) < sessionWait {
+		time.Sleep(5 * time.Second)
+		if m := gpuSessions(); n >= 0 && m >= 0 && m < n {
+			return time.Since(start), true
+		}
+	}
+	log.Warn.Add("topic", "gpu", "action", "session_wait", "sessions", n, "waited", time.Since(start).Seconds()).Printf("no nvenc session freed up, retrying anyway")
+	return time.Since(start), false
+}
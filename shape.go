@@ -0,0 +1,98 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/as/log"
+)
+
+var (
+	// niceness, if set, is the nice value of the ffmpeg process group,
+	// i.e. 10 for a background job
+	niceness = os.Getenv("NICE")
+
+	// ioniceClass is the io scheduling class of the ffmpeg process group:
+	// idle, best-effort or realtime, or 3, 2 and 1. linux only
+	ioniceClass = os.Getenv("IONICE_CLASS")
+
+	// ioniceLevel is the priority within IONICE_CLASS, 0 (highest) to
+	// 7. default=4
+	ioniceLevel = os.Getenv("IONICE_LEVEL")
+
+	// cpuset pins ffmpeg to these cpus, i.e. 0-3,8. linux only
+	cpuset = os.Getenv("CPUSET")
+)
+
+var errUnsupported = errors.New("not supported on this platform")
+
+var ioniceClasses = map[string]int{"realtime": 1, "best-effort": 2, "idle": 3, "1": 1, "2": 2, "3": 3}
+
+// shapeChild applies NICE, IONICE_CLASS and CPUSET to ffmpeg, which
+// leads process group pid. It runs after every start, retries included.
+// A limit that can't be applied is a warning, ffmpeg runs either way.
+func shapeChild(pid int) {
+	if niceness != "" {
+		n, err := strconv.Atoi(niceness)
+		if err == nil {
+			err = setNice(pid, n)
+		}
+		shaped(pid, "nice", niceness, err)
+	}
+	if ioniceClass != "" {
+		class, ok := ioniceClasses[strings.ToLower(ioniceClass)]
+		level, err := 4, error(nil)
+		if ioniceLevel != "" {
+			level, err = strconv.Atoi(ioniceLevel)
+		}
+		switch {
+		case !ok:
+			err = fmt.Errorf("unknown class %q", ioniceClass)
+		case err == nil && (level < 0 || level > 7):
+			err = fmt.Errorf("level %d isn't 0-7", level)
+		case err == nil:
+			err = setIONice(pid, class, level)
+		}
+		shaped(pid, "ionice", fmt.Sprintf("%s/%d", ioniceClass, level), err)
+	}
+	if cpuset != "" {
+		cpus, err := parseCPUs(cpuset)
+		if err == nil {
+			err = setAffinity(pid, cpus)
+		}
+		shaped(pid, "cpuset", cpuset, err)
+	}
+}
+
+func shaped(pid int, what, value string, err error) {
+	ln := log.Info.Add("topic", "transcode", "action", "shape", "pid", pid)
+	if err != nil {
+		ln.Warn().Add(what, value, "err", err).Printf("cant apply %s to ffmpeg", what)
+		return
+	}
+	ln.Add(what, value).Printf("applied %s to ffmpeg", what)
+}
+
+// parseCPUs parses a cpu list like 0-3,8
+func parseCPUs(v string) (cpus []int, err error) {
+	for _, r := range strings.Split(v, ",") {
+		lo, hi, span := strings.Cut(strings.TrimSpace(r), "-")
+		a, err := strconv.Atoi(lo)
+		if err != nil || a < 0 {
+			return nil, fmt.Errorf("bad cpu list %q", v)
+		}
+		b := a
+		if span {
+			if b, err = strconv.Atoi(hi); err != nil || b < a {
+				return nil, fmt.Errorf("bad cpu list %q", v)
+			}
+		}
+		for c := a; c <= b; c++ {
+			cpus = append(cpus, c)
+		}
+	}
+	return cpus, nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// setNice sets the nice value of process group pgid
+func setNice(pgid, n int) error {
+	return syscall.Setpriority(syscall.PRIO_PGRP, pgid, n)
+}
+
+// setIONice sets the io class and level of process group pgid, see
+// ioprio_set(2)
+func setIONice(pgid, class, level int) error {
+	const whoPgrp, classShift = 2, 13
+	_, _, e := syscall.Syscall(syscall.SYS_IOPRIO_SET, whoPgrp, uintptr(pgid), uintptr(class<<classShift|level))
+	if e != 0 {
+		return e
+	}
+	return nil
+}
+
+// setAffinity pins every thread of every process in group pgid to cpus.
+// Affinity is per thread, and ffmpeg has started some by now, as may the
+// helpers it runs. Threads and processes started later inherit it.
+func setAffinity(pgid int, cpus []int) error {
+	var mask [16]uint64
+	for _, c := range cpus {
+		if c >= len(mask)*64 {
+			return syscall.EINVAL
+		}
+		mask[c/64] |= 1 << (c % 64)
+	}
+	pids := groupPids("/proc", pgid)
+	if len(pids) == 0 {
+		pids = []int{pgid}
+	}
+	for _, pid := range pids {
+		for _, tid := range tasks("/proc", pid) {
+			_, _, e := syscall.RawSyscall(syscall.SYS_SCHED_SETAFFINITY, uintptr(tid), unsafe.Sizeof(mask), uintptr(unsafe.Pointer(&mask)))
+			if e != 0 && e != syscall.ESRCH {
+				return e
+			}
+		}
+	}
+	return nil
+}
+
+// groupPids returns the processes in group pgid under the proc root
+func groupPids(root string, pgid int) (pids []int) {
+	ents, _ := os.ReadDir(root)
+	for _, e := range ents {
+		pid, err := strconv.Atoi(e.Name())
+		if err != nil {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(root, e.Name(), "stat"))
+		if err != nil {
+			continue // exited
+		}
+		// the comm field can contain spaces, so start after its closing paren
+		stat := string(data)
+		if i := strings.LastIndexByte(stat, ')'); i >= 0 {
+			stat = stat[i+1:]
+		}
+		if f := strings.Fields(stat); len(f) > 2 && f[2] == strconv.Itoa(pgid) {
+			pids = append(pids, pid)
+		}
+	}
+	return pids
+}
+
+// tasks returns the threads of pid under the proc root, or just pid
+func tasks(root string, pid int) []int {
+	tids := []int{pid}
+	if ents, err := os.ReadDir(filepath.Join(root, strconv.Itoa(pid), "task")); err == nil {
+		tids = tids[:0]
+		for _, e := range ents {
+			if tid, err := strconv.Atoi(e.Name()); err == nil {
+				tids = append(tids, tid)
+			}
+		}
+	}
+	return tids
+}
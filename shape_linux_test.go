@@ -0,0 +1,76 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestGroupPids(t *testing.T) {
+	root := t.TempDir()
+	for pid, stat := range map[string]string{
+		"100":  "100 (ffmpeg) S 1 100 100 0 -1",
+		"101":  "101 (sh -c (x)) S 100 100 100 0 -1",
+		"102":  "102 (other) S 1 102 102 0 -1",
+		"self": "7 (test) S 1 7 7 0 -1",
+	} {
+		os.MkdirAll(filepath.Join(root, pid, "task", pid), 0755)
+		os.WriteFile(filepath.Join(root, pid, "stat"), []byte(stat), 0644)
+	}
+	os.Mkdir(filepath.Join(root, "103"), 0755) // exited
+	os.Mkdir(filepath.Join(root, "100", "task", "104"), 0755)
+
+	if got := groupPids(root, 100); !reflect.DeepEqual(got, []int{100, 101}) {
+		t.Errorf("groupPids = %v, want [100 101]", got)
+	}
+	if got := tasks(root, 100); !reflect.DeepEqual(got, []int{100, 104}) {
+		t.Errorf("tasks = %v, want [100 104]", got)
+	}
+	if got := tasks(root, 103); !reflect.DeepEqual(got, []int{103}) {
+		t.Errorf("tasks of a pid without task dir = %v, want [103]", got)
+	}
+}
+
+// TestSetAffinityGroup pins a group whose leader has already started a
+// child, the way ffmpeg runs helpers
+func TestSetAffinityGroup(t *testing.T) {
+	cmd := exec.Command("sh", "-c", "sleep 5 & wait")
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if err := cmd.Start(); err != nil {
+		t.Skip(err)
+	}
+	defer func() {
+		syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		cmd.Wait()
+	}()
+	pgid := cmd.Process.Pid
+	var pids []int
+	for i := 0; i < 100 && len(pids) < 2; i++ {
+		time.Sleep(10 * time.Millisecond)
+		pids = groupPids("/proc", pgid)
+	}
+	if len(pids) < 2 {
+		t.Skipf("group %d has %v, want the shell and its child", pgid, pids)
+	}
+	if err := setAffinity(pgid, []int{0}); err != nil {
+		t.Fatal(err)
+	}
+	for _, pid := range pids {
+		data, err := os.ReadFile("/proc/" + strconv.Itoa(pid) + "/status")
+		if err != nil {
+			continue
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			v := strings.TrimSpace(strings.TrimPrefix(line, "Cpus_allowed_list:"))
+			if strings.HasPrefix(line, "Cpus_allowed_list:") && v != "0" {
+				t.Errorf("pid %d allowed cpus %s, want 0", pid, v)
+			}
+		}
+	}
+}
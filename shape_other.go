@@ -0,0 +1,9 @@
+//go:build windows || plan9
+
+package main
+
+func setNice(pgid, n int) error { return errUnsupported }
+
+func setIONice(pgid, class, level int) error { return errUnsupported }
+
+func setAffinity(pgid int, cpus []int) error { return errUnsupported }
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseCPUs(t *testing.T) {
+	for _, tt := range []struct {
+		in   string
+		want []int
+		ok   bool
+	}{
+		{"0", []int{0}, true},
+		{"0-3,8", []int{0, 1, 2, 3, 8}, true},
+		{" 2 , 4-5", []int{2, 4, 5}, true},
+		{"3-3", []int{3}, true},
+		{"", nil, false},
+		{"3-1", nil, false},
+		{"-1", nil, false},
+		{"a-b", nil, false},
+		{"0,,1", nil, false},
+	} {
+		got, err := parseCPUs(tt.in)
+		if !reflect.DeepEqual(got, tt.want) || (err == nil) != tt.ok {
+			t.Errorf("parseCPUs(%q) = %v, %v, want %v, ok=%v", tt.in, got, err, tt.want, tt.ok)
+		}
+	}
+}
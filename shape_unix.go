@@ -0,0 +1,13 @@
+//go:build !linux && !windows && !plan9
+
+package main
+
+import "syscall"
+
+func setNice(pgid, n int) error {
+	return syscall.Setpriority(syscall.PRIO_PGRP, pgid, n)
+}
+
+func setIONice(pgid, class, level int) error { return errUnsupported }
+
+func setAffinity(pgid int, cpus []int) error { return errUnsupported }
@@ -0,0 +1,8 @@
+//go:build windows || plan9
+
+package main
+
+import "os"
+
+// debugSignals is empty, there's no SIGUSR2 here
+var debugSignals []os.Signal
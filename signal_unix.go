@@ -0,0 +1,11 @@
+//go:build !windows && !plan9
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// debugSignals toggle debug logging, see setLogLevel
+var debugSignals = []os.Signal{syscall.SIGUSR2}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/as/log"
+)
+
+// statusSocket, if set, is a unix socket path that streams newline
+// delimited json status updates to every connected client
+var statusSocket = os.Getenv("STATUS_SOCKET")
+
+// StatusSocket streams status updates to local clients. A client that
+// can't keep up is dropped rather than holding up the status loop.
+type StatusSocket struct {
+	mu      sync.Mutex
+	last    []byte
+	doc     map[string]any
+	clients map[chan []byte]bool
+	serving sync.WaitGroup
+}
+
+// listenStatus returns nil when STATUS_SOCKET is unset. At exit the
+// clients get a final update with the outcome, then the socket is
+// removed.
+func listenStatus() *StatusSocket {
+	if statusSocket == "" {
+		return nil
+	}
+	os.Remove(statusSocket)
+	ln, err := net.Listen("unix", statusSocket)
+	if err != nil {
+		log.Warn.Add("topic", "socket", "action", "listen", "addr", statusSocket, "err", err).Printf("status socket disabled")
+		return nil
+	}
+	s := &StatusSocket{clients: map[chan []byte]bool{}}
+	s.Update(State{}, 0)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			s.serving.Add(1)
+			go s.serve(conn)
+		}
+	}()
+	atExit(func() {
+		ln.Close()
+		s.finish()
+		os.Remove(statusSocket)
+	})
+	log.Info.Add("topic", "socket", "action", "listen", "addr", statusSocket).Printf("serving status")
+	return s
+}
+
+func (s *StatusSocket) serve(conn net.Conn) {
+	defer s.serving.Done()
+	defer conn.Close()
+	c := make(chan []byte, 4)
+	s.mu.Lock()
+	c <- s.last
+	s.clients[c] = true
+	s.mu.Unlock()
+	for line := range c {
+		conn.SetWriteDeadline(time.Now().Add(time.Second))
+		if _, err := conn.Write(line); err != nil {
+			s.drop(c)
+			return
+		}
+	}
+}
+
+func (s *StatusSocket) drop(c chan []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.clients[c] {
+		delete(s.clients, c)
+		close(c)
+	}
+}
+
+// Update sends s to every client. It's a no-op on a nil StatusSocket.
+func (s *StatusSocket) Update(st State, progress int) {
+	if s == nil {
+		return
+	}
+	doc := statusDoc(st, progress)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.doc = doc
+	s.send(doc)
+}
+
+// finish sends the last update again with how the job ended and
+// disconnects the clients once they have it, waiting at most 2s
+func (s *StatusSocket) finish() {
+	s.mu.Lock()
+	doc := s.doc
+	if doc == nil {
+		doc = statusDoc(State{}, 0)
+	}
+	doc["outcome"] = outcome
+	if errorClass != "" {
+		doc["error_class"] = errorClass
+	}
+	doc["uptime"] = time.Since(procstart).Seconds()
+	s.send(doc)
+	for c := range s.clients {
+		delete(s.clients, c)
+		close(c)
+	}
+	s.mu.Unlock()
+
+	done := make(chan bool)
+	go func() {
+		s.serving.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+	}
+}
+
+// send queues doc for every client. s.mu must be held.
+func (s *StatusSocket) send(doc map[string]any) {
+	line, err := json.Marshal(doc)
+	if err != nil {
+		return
+	}
+	line = append(line, '\n')
+	s.last = line
+	for c := range s.clients {
+		select {
+		case c <- line:
+		default:
+			delete(s.clients, c)
+			close(c)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/as/log"
+)
+
+// TestStatusSocketFinal checks a client gets the updates and then a
+// final one with how the job ended
+func TestStatusSocketFinal(t *testing.T) {
+	if runtime.GOOS == "windows" || runtime.GOOS == "plan9" {
+		t.Skip("needs unix sockets")
+	}
+	dir, err := os.MkdirTemp("", "ffjson")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(p, o, c string) { statusSocket, outcome, errorClass = p, o, c }(statusSocket, outcome, errorClass)
+	defer log.SetOutput(log.SetOutput(new(bytes.Buffer)))
+	statusSocket = filepath.Join(dir, "status.sock")
+
+	s := listenStatus()
+	if s == nil {
+		t.Fatal("no status socket")
+	}
+	conn, err := net.Dial("unix", statusSocket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	r := bufio.NewScanner(conn)
+	next := func() (doc map[string]any) {
+		t.Helper()
+		if !r.Scan() {
+			t.Fatalf("socket closed early: %v", r.Err())
+		}
+		if err := json.Unmarshal(r.Bytes(), &doc); err != nil {
+			t.Fatal(err)
+		}
+		return doc
+	}
+	if doc := next(); doc["outcome"] != "running" {
+		t.Fatalf("first update %v, want running", doc)
+	}
+	s.Update(State{Frame: 250}, 40)
+	if doc := next(); doc["frame"] != 250.0 || doc["outcome"] != "running" {
+		t.Fatalf("update %v, want frame 250 running", doc)
+	}
+
+	outcome, errorClass = "failed", "disk_full"
+	runExitHooks()
+	doc := next()
+	if doc["outcome"] != "failed" || doc["error_class"] != "disk_full" || doc["frame"] != 250.0 || doc["progress"] != 40.0 {
+		t.Errorf("final update %v, want the last state, failed and disk_full", doc)
+	}
+	if r.Scan() {
+		t.Errorf("socket still open after the final update, read %s", r.Bytes())
+	}
+	if _, err := os.Stat(statusSocket); !os.IsNotExist(err) {
+		t.Errorf("socket not removed at exit: %v", err)
+	}
+}
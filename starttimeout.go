@@ -0,0 +1,64 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"time"
+)
+
+// startTimeout fails the job when ffmpeg hasn't encoded a frame or
+// written any output this long after it started. A connect that hangs
+// looks like that: the banner and then nothing, and the stall counter
+// never arms at frame zero. Negative disables it. default=5m
+var startTimeout = envDur("STARTTIMEOUT")
+
+func init() {
+	if startTimeout == 0 {
+		startTimeout = 5 * time.Minute
+	}
+}
+
+// started reports whether s shows ffmpeg doing real work
+func started(s State) bool {
+	return s.Frame > 0 || s.Size > 0
+}
+
+// networkInput reports whether any input is read over the network,
+// which makes a start timeout worth retrying
+func networkInput(args []string) bool {
+	for _, pass := range splitPasses(args) {
+		for _, in := range parseArgv(pass).Inputs {
+			if protoRE.MatchString(in) && !strings.HasPrefix(in, "file:") && !strings.HasPrefix(in, "pipe:") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// tailFile returns up to the last n lines of f without moving its offset
+func tailFile(f *os.File, n int) []string {
+	fi, err := f.Stat()
+	if err != nil || fi.Size() == 0 {
+		return nil
+	}
+	off := fi.Size() - 4096
+	if off < 0 {
+		off = 0
+	}
+	buf := make([]byte, fi.Size()-off)
+	if _, err := f.ReadAt(buf, off); err != nil {
+		return nil
+	}
+	lines := strings.Split(strings.TrimRight(string(buf), "\n"), "\n")
+	if off > 0 {
+		lines = lines[1:] // partial
+	}
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	for i := range lines {
+		lines[i] = redact(lines[i])
+	}
+	return lines
+}
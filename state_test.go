@@ -0,0 +1,39 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/as/log"
+)
+
+// TestWatchStateStalled is a consumer that stops reading: stderr must
+// keep draining, so ffmpeg never blocks writing it, and the States it
+// missed are counted in the one it gets
+func TestWatchStateStalled(t *testing.T) {
+	defer resetParsed()
+	defer log.SetOutput(log.SetOutput(new(bytes.Buffer)))
+	const n = 5000
+	var b strings.Builder
+	for i := 1; i <= n; i++ {
+		fmt.Fprintf(&b, "frame=%d fps=25 q=28.0 size=%dkB time=00:00:01.00 bitrate=800.0kbits/s speed=1x\r", i, i)
+	}
+	statc := make(chan State, 1)
+	finished := make(chan bool)
+	go func() {
+		watchState(strings.NewReader(b.String()), statc, &Detected{})
+		close(finished)
+	}()
+	select {
+	case <-finished:
+	case <-time.After(10 * time.Second):
+		t.Fatal("watchState blocked on a consumer that isn't reading")
+	}
+	s, ok := <-statc
+	if !ok || s.Frame != n || s.N != n {
+		t.Fatalf("got %+v, want frame %d with N=%d", s, n, n)
+	}
+}
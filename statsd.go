@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/as/log"
+)
+
+var (
+	// statsdAddr, if set, is a statsd/dogstatsd udp address that
+	// receives progress gauges on every LOGFREQ tick
+	statsdAddr = os.Getenv("STATSD_ADDR")
+
+	// statsdTags are dogstatsd tags added to every metric. Environment
+	// variables are expanded, i.e. job_id:${JOB_ID},host:${HOSTNAME}
+	statsdTags = os.ExpandEnv(os.Getenv("STATSD_TAGS"))
+)
+
+// StatsD sends metrics over udp. Sends are fire and forget: a slow
+// or missing agent never affects the transcode.
+type StatsD struct {
+	conn net.Conn
+}
+
+// dialStatsD returns nil when STATSD_ADDR is unset or can't be resolved.
+// It sends the total duration and outcome when the process exits.
+func dialStatsD() *StatsD {
+	if statsdAddr == "" {
+		return nil
+	}
+	conn, err := net.Dial("udp", statsdAddr)
+	if err != nil {
+		log.Warn.Add("topic", "statsd", "action", "dial", "addr", statsdAddr, "err", err).Printf("statsd disabled")
+		return nil
+	}
+	c := &StatsD{conn: conn}
+	atExit(func() {
+		c.send(
+			fmt.Sprintf("ffmpegjson.duration:%d|ms", time.Since(procstart).Milliseconds()),
+			c.tag("ffmpegjson.outcome:1|c", "outcome:"+outcome),
+		)
+		c.conn.Close()
+	})
+	return c
+}
+
+// tag appends the STATSD_TAGS and any extra tags to metric
+func (c *StatsD) tag(metric string, extra ...string) string {
+	tags := extra
+	if statsdTags != "" {
+		tags = append([]string{statsdTags}, extra...)
+	}
+	if len(tags) == 0 {
+		return metric
+	}
+	return metric + "|#" + strings.Join(tags, ",")
+}
+
+func (c *StatsD) send(metric ...string) {
+	if c == nil {
+		return
+	}
+	for i := range metric {
+		if !strings.Contains(metric[i], "|#") {
+			metric[i] = c.tag(metric[i])
+		}
+	}
+	c.conn.SetWriteDeadline(time.Now().Add(100 * time.Millisecond))
+	c.conn.Write([]byte(strings.Join(metric, "\n")))
+}
+
+// Gauges sends the current state. It's a no-op on a nil StatsD.
+func (c *StatsD) Gauges(s State, progress int) {
+	c.send(
+		fmt.Sprintf("ffmpegjson.frame:%d|g", s.Frame),
+		fmt.Sprintf("ffmpegjson.fps:%d|g", s.FPS),
+		fmt.Sprintf("ffmpegjson.speed:%g|g", s.Speed),
+		fmt.Sprintf("ffmpegjson.progress:%d|g", progress),
+		fmt.Sprintf("ffmpegjson.dup:%d|g", s.Dup),
+		fmt.Sprintf("ffmpegjson.drop:%d|g", s.Drop),
+	)
+}
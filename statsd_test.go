@@ -0,0 +1,63 @@
+package main
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStatsD(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip(err)
+	}
+	defer pc.Close()
+	defer func(addr, tags, o string) { statsdAddr, statsdTags, outcome = addr, tags, o }(statsdAddr, statsdTags, outcome)
+	statsdAddr, statsdTags = pc.LocalAddr().String(), "job:7,host:a"
+
+	read := func() []string {
+		t.Helper()
+		buf := make([]byte, 4096)
+		pc.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, _, err := pc.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return strings.Split(string(buf[:n]), "\n")
+	}
+
+	c := dialStatsD()
+	if c == nil {
+		t.Fatal("dialStatsD = nil")
+	}
+	c.Gauges(State{Frame: 250, FPS: 25, Speed: 1.5, Dup: 2, Drop: 1}, 40)
+	want := []string{
+		"ffmpegjson.frame:250|g|#job:7,host:a",
+		"ffmpegjson.fps:25|g|#job:7,host:a",
+		"ffmpegjson.speed:1.5|g|#job:7,host:a",
+		"ffmpegjson.progress:40|g|#job:7,host:a",
+		"ffmpegjson.dup:2|g|#job:7,host:a",
+		"ffmpegjson.drop:1|g|#job:7,host:a",
+	}
+	if got := read(); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("gauges:\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	outcome = "done"
+	runExitHooks()
+	got := read()
+	if len(got) != 2 || !strings.HasPrefix(got[0], "ffmpegjson.duration:") || got[1] != "ffmpegjson.outcome:1|c|#job:7,host:a,outcome:done" {
+		t.Errorf("exit metrics %q", got)
+	}
+}
+
+func TestStatsDOff(t *testing.T) {
+	defer func(addr string) { statsdAddr = addr }(statsdAddr)
+	statsdAddr = ""
+	c := dialStatsD()
+	if c != nil {
+		t.Fatal("dialStatsD without STATSD_ADDR isn't nil")
+	}
+	c.Gauges(State{Frame: 1}, 1) // nil-safe
+}
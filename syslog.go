@@ -0,0 +1,10 @@
+//go:build !windows && !plan9
+
+package main
+
+import "log/syslog"
+
+// dialSyslog connects to the local syslog daemon (journald on most hosts)
+func dialSyslog(tag string) (syslogger, error) {
+	return syslog.New(syslog.LOG_INFO|syslog.LOG_USER, tag)
+}
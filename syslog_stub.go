@@ -0,0 +1,9 @@
+//go:build windows || plan9
+
+package main
+
+import "errors"
+
+func dialSyslog(tag string) (syslogger, error) {
+	return nil, errors.New("syslog not supported on this platform")
+}
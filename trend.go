@@ -0,0 +1,115 @@
+package main
+
+import (
+	"os"
+	"strconv"
+	"time"
+)
+
+var (
+	// degradeWindow is the span the speed trend is fit over. default=10m
+	degradeWindow = envDur("DEGRADE_WINDOW")
+
+	// degradeDrop is how much the speed must fall over the window, as a
+	// fraction of its mean, to count as degrading. default=0.25
+	degradeDrop, _ = strconv.ParseFloat(os.Getenv("DEGRADE_DROP"), 64)
+
+	// degradeCount is how many consecutive degrading fits it takes to
+	// warn. default=3
+	degradeCount, _ = strconv.Atoi(os.Getenv("DEGRADE_COUNT"))
+
+	// degradeAction=kill stops the job when it's degrading instead of
+	// only warning
+	degradeAction = os.Getenv("DEGRADE_ACTION")
+)
+
+func init() {
+	if degradeWindow <= 0 {
+		degradeWindow = 10 * time.Minute
+	}
+	if degradeDrop <= 0 {
+		degradeDrop = 0.25
+	}
+	if degradeCount <= 0 {
+		degradeCount = 3
+	}
+}
+
+// trendBuckets is how many averages the slope is fit to. Averaging
+// first smooths out the sawtooth around keyframes.
+const trendBuckets = 10
+
+// Trend fits a line to the speed over the last degradeWindow and
+// reports when it keeps sloping down
+type Trend struct {
+	bucket time.Duration
+	start  time.Time
+	sum    float64
+	n      int
+	means  []float64
+	slope  float64 // per bucket
+	bad    int
+}
+
+func NewTrend() *Trend {
+	return &Trend{bucket: degradeWindow / trendBuckets}
+}
+
+// Add records s at now and returns true after degradeCount consecutive
+// degrading fits, and every degradeCount after that while it lasts
+func (t *Trend) Add(now time.Time, s State) bool {
+	if s.Frame == 0 && s.Size == 0 {
+		return false
+	}
+	if t.start.IsZero() {
+		t.start = now
+	}
+	t.sum += s.Speed
+	t.n++
+	if now.Sub(t.start) < t.bucket {
+		return false
+	}
+	t.means = append(t.means, t.sum/float64(t.n))
+	t.start, t.sum, t.n = now, 0, 0
+	if len(t.means) > trendBuckets {
+		t.means = t.means[1:]
+	}
+	if len(t.means) < trendBuckets {
+		return false
+	}
+	var mean float64
+	t.slope, mean = fit(t.means)
+	if mean > 0 && -t.slope*float64(len(t.means)-1)/mean > degradeDrop {
+		t.bad++
+	} else {
+		t.bad = 0
+	}
+	return t.bad > 0 && t.bad%degradeCount == 0
+}
+
+// fit returns the least squares slope and the mean of v over its indices
+func fit(v []float64) (slope, mean float64) {
+	n := float64(len(v))
+	var sx, sy, sxy, sxx float64
+	for i, y := range v {
+		x := float64(i)
+		sx, sy, sxy, sxx = sx+x, sy+y, sxy+x*y, sxx+x*x
+	}
+	if d := n*sxx - sx*sx; d != 0 {
+		slope = (n*sxy - sx*sy) / d
+	}
+	return slope, sy / n
+}
+
+func (t *Trend) Fields() []any {
+	means := make([]float64, len(t.means))
+	for i, m := range t.means {
+		means[i] = round100(m)
+	}
+	return []any{
+		"window", degradeWindow.Seconds(),
+		"speed_slope", round100(t.slope / t.bucket.Minutes()), // per minute
+		"samples", means,
+		"fits", t.bad,
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/as/log"
+)
+
+// validate checks the command before ffmpeg starts. VALIDATE=0 turns
+// it off for commands the checker gets wrong.
+var validate = os.Getenv("VALIDATE") != "0"
+
+// exitBadArg is the exit status when the command is rejected before
+// ffmpeg starts
+const exitBadArg = 2
+
+var mapRefRE = regexp.MustCompile(`^-?(\d+)`)
+
+// checkArgs returns the offending argument and the reason if args, one
+// ffmpeg command, can't possibly work
+func checkArgs(args []string) (arg string, err error) {
+	a := parseArgv(args)
+	if a.Missing != "" {
+		return a.Missing, fmt.Errorf("option %s has no value", a.Missing)
+	}
+	for i := 1; i < len(args); i++ {
+		switch args[i-1] {
+		case "-i":
+			if len(args[i]) > 1 && args[i][0] == '-' {
+				return args[i-1], fmt.Errorf("-i is followed by option %s, not an input", args[i])
+			}
+		case "-map":
+			m := mapRefRE.FindStringSubmatch(args[i])
+			if m == nil {
+				continue
+			}
+			if n, _ := strconv.Atoi(m[1]); n >= len(a.Inputs) {
+				return args[i], fmt.Errorf("-map %s references input %d, but there are %d inputs", args[i], n, len(a.Inputs))
+			}
+		}
+	}
+	for _, in := range a.Inputs {
+		if !isLocal(in, a.Formats[in]) || strings.Contains(in, "%") {
+			continue
+		}
+		if _, err := os.Stat(in); err != nil {
+			return in, fmt.Errorf("input: %w", err)
+		}
+	}
+	seen := map[string]bool{}
+	for _, out := range a.Outputs {
+		if !isLocal(out, a.Formats[out]) {
+			continue
+		}
+		abs, _ := filepath.Abs(out)
+		if seen[abs] {
+			return out, fmt.Errorf("output %s is given more than once", out)
+		}
+		seen[abs] = true
+		if err := writable(filepath.Dir(out)); err != nil {
+			return out, fmt.Errorf("output directory: %w", err)
+		}
+	}
+	return "", nil
+}
+
+// writable returns an error if a file can't be created in dir
+func writable(dir string) error {
+	f, err := os.CreateTemp(dir, ".ffmpeg-json-*")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// validateArgs checks each pass of the command and fails with
+// action=badarg and exitBadArg if one is malformed
+func validateArgs(args []string) {
+	if !validate {
+		return
+	}
+	for _, pass := range splitPasses(args) {
+		if arg, err := checkArgs(pass); err != nil {
+			exitStatus = exitBadArg
+			fatal(log.Fatal.Add("topic", "transcode", "action", "badarg", "arg", arg, "err", err), "invalid command")
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/as/log"
+)
+
+var (
+	// verifyOutput probes every output file with ffprobe after a
+	// successful run and fails the job if one is unusable
+	verifyOutput = os.Getenv("VERIFY_OUTPUT") == "1"
+
+	// verifyTolerance is how far an output's duration may be from DUR,
+	// as a fraction (0.02) or a percentage (2%). default=2%
+	verifyTolerance = envFraction("VERIFY_TOLERANCE", 0.02)
+)
+
+// envFraction parses a fraction or percentage from the environment
+func envFraction(name string, def float64) float64 {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	f, err := strconv.ParseFloat(strings.TrimSuffix(v, "%"), 64)
+	if err != nil || f < 0 {
+		log.Warn.Add("topic", "env", "action", "parse", "env", name, "value", v).Printf("invalid fraction, using %g", def)
+		return def
+	}
+	if strings.HasSuffix(v, "%") {
+		f /= 100
+	}
+	return f
+}
+
+// Probe is the result of checking one output
+type Probe struct {
+	Output   string   `json:"output"`
+	Duration float64  `json:"duration,omitempty"`
+	Codecs   []string `json:"codecs,omitempty"` // type:codec per stream
+	Skipped  string   `json:"skipped,omitempty"`
+	Err      string   `json:"err,omitempty"`
+}
+
+// probeOutput checks that the output at path opens, has a stream, and
+// is within verifyTolerance of DUR when it's set
+func probeOutput(path string) (p Probe) {
+	p.Output = path
+	out, err := probeCmd(context.Background(), ffprobePath(), "-v", "error", "-show_entries", "format=duration:stream=codec_type,codec_name", "-of", "json", path)
+	if err != nil {
+		p.Err = fmt.Sprintf("ffprobe: %v", err)
+		return p
+	}
+	var v struct {
+		Format struct {
+			Duration string `json:"duration"`
+		} `json:"format"`
+		Streams []struct {
+			Type  string `json:"codec_type"`
+			Codec string `json:"codec_name"`
+		} `json:"streams"`
+	}
+	if err := json.Unmarshal(out, &v); err != nil {
+		p.Err = fmt.Sprintf("ffprobe: %v", err)
+		return p
+	}
+	p.Duration, _ = strconv.ParseFloat(v.Format.Duration, 64)
+	for _, st := range v.Streams {
+		p.Codecs = append(p.Codecs, st.Type+":"+st.Codec)
+	}
+	switch want := targetDur.Seconds(); {
+	case len(p.Codecs) == 0:
+		p.Err = "no streams"
+	case want > 0 && math.Abs(p.Duration-want) > want*verifyTolerance:
+		p.Err = fmt.Sprintf("duration %.3fs is not within %g%% of %.3fs", p.Duration, verifyTolerance*100, want)
+	}
+	return p
+}
+
+// verifyOutputs probes the outputs of the final pass. It returns the
+// results and an error if any output failed.
+func verifyOutputs(args []string) (probes []Probe, err error) {
+	passes := splitPasses(args)
+	a := parseArgv(passes[len(passes)-1])
+	for _, out := range a.Outputs {
+		switch {
+		case !isLocal(out, a.Formats[out]):
+			probes = append(probes, Probe{Output: out, Skipped: "not a seekable file"})
+		case templateRE.MatchString(out):
+			probes = append(probes, Probe{Output: out, Skipped: "segment template"})
+		default:
+			p := probeOutput(out)
+			if p.Err != "" && err == nil {
+				err = fmt.Errorf("verify %s: %s", out, p.Err)
+			}
+			probes = append(probes, p)
+		}
+	}
+	return probes, err
+}
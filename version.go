@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"runtime/debug"
+	"strings"
+	"time"
+)
+
+// The wrapper's own build metadata, set with
+//
+//	go build -ldflags "-X main.version=v1.2.3 -X main.commit=abc123 -X main.buildDate=2024-01-02"
+//
+// Unset values are filled in from the module build info where possible.
+var (
+	version   = ""
+	commit    = ""
+	buildDate = ""
+)
+
+// ffversion is the version of the ffmpeg binary being run
+var ffversion Version
+
+func init() {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return
+	}
+	if version == "" && info.Main.Version != "(devel)" {
+		version = info.Main.Version
+	}
+	for _, s := range info.Settings {
+		switch {
+		case s.Key == "vcs.revision" && commit == "":
+			commit = s.Value
+		case s.Key == "vcs.time" && buildDate == "":
+			buildDate = s.Value
+		}
+	}
+	if version == "" {
+		version = "devel"
+	}
+}
+
+// printVersion handles ffmpeg-json --version. It's only ours when it's
+// the sole argument; otherwise it goes to ffmpeg like everything else.
+func printVersion(args []string) bool {
+	if len(args) != 2 || args[1] != "--version" {
+		return false
+	}
+	fmt.Printf("ffmpeg-json %s commit %s built %s\n", version, commit, buildDate)
+	return true
+}
+
+// Version is the parsed output of ffmpeg -version
+type Version struct {
+	Raw                 string // as printed, i.e. n5.1.2-9-gabc123
+	Major, Minor, Patch int
+	Config              []string          // the ./configure flags it was built with
+	Libs                map[string]string // library versions, i.e. libavcodec: 59.37.100
+}
+
+var (
+	versionRE    = regexp.MustCompile(`ffmpeg version (\S+)`)
+	versionNumRE = regexp.MustCompile(`^\D?(\d+)\.(\d+)(?:\.(\d+))?`)
+	configRE     = regexp.MustCompile(`(?m)^\s*configuration:(.*)$`)
+	libRE        = regexp.MustCompile(`(?m)^\s*(lib\w+)\s+(\d+)\.\s*(\d+)\.\s*(\d+)`)
+)
+
+// parseVersion extracts the version, configuration and library versions
+// from the ffmpeg -version banner. Git builds without a release number
+// (N-12345-gabc) leave Major at zero.
+func parseVersion(banner string) (v Version) {
+	m := versionRE.FindStringSubmatch(banner)
+	if m == nil {
+		return v
+	}
+	v.Raw = m[1]
+	if m = versionNumRE.FindStringSubmatch(v.Raw); m != nil {
+		fmt.Sscan(m[1], &v.Major)
+		fmt.Sscan(m[2], &v.Minor)
+		fmt.Sscan(m[3], &v.Patch)
+	}
+	if m = configRE.FindStringSubmatch(banner); m != nil {
+		v.Config = strings.Fields(m[1])
+	}
+	for _, m := range libRE.FindAllStringSubmatch(banner, -1) {
+		if v.Libs == nil {
+			v.Libs = map[string]string{}
+		}
+		v.Libs[m[1]] = m[2] + "." + m[3] + "." + m[4]
+	}
+	return v
+}
+
+// Fields returns the version and the versions of the libraries whose
+// behavior we most often depend on
+func (v Version) Fields() []any {
+	return []any{
+		"ffmpeg_version", v.Raw,
+		"libavcodec", v.Libs["libavcodec"],
+		"libavformat", v.Libs["libavformat"],
+		"libavfilter", v.Libs["libavfilter"],
+	}
+}
+
+func queryVersion() Version {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	out, _ := exec.CommandContext(ctx, ffmpegPath, "-version").Output()
+	return parseVersion(string(out))
+}
+
+// AtLeast returns true if v is major.minor or newer. Unknown
+// versions are assumed to be recent git builds.
+func (v Version) AtLeast(major, minor int) bool {
+	if v.Major == 0 {
+		return v.Raw != ""
+	}
+	return v.Major > major || v.Major == major && v.Minor >= minor
+}
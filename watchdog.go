@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/as/log"
+)
+
+var (
+	// watchdogMode=auto derives the stall and dup thresholds from the
+	// cadence observed during calibration. MAXSTALL and MAXDUP override it.
+	watchdogMode = os.Getenv("WATCHDOG")
+
+	// calibrate is how long the auto watchdog observes the encode
+	// after the first frame before arming. default=30s
+	calibrate = envDur("WATCHDOG_CALIBRATE")
+)
+
+const (
+	stallFloor, stallCeil = 10 * time.Second, 10 * time.Minute
+	dupFloor, dupCeil     = 100, 100000
+)
+
+// Watchdog derives stall and dup thresholds from the content being encoded.
+// A 4fps timelapse and a 60fps live encode need very different limits.
+type Watchdog struct {
+	start, last time.Time
+	frame       int
+	gaps        []time.Duration
+	fps         []int
+	armed       bool
+
+	StallAfter time.Duration // stall threshold since the last frame advance
+	MaxDup     int
+	basis      string
+}
+
+// NewWatchdog returns nil unless WATCHDOG=auto
+func NewWatchdog() *Watchdog {
+	if watchdogMode != "auto" {
+		return nil
+	}
+	if calibrate == 0 {
+		calibrate = 30 * time.Second
+	}
+	return &Watchdog{}
+}
+
+// Observe records the cadence of frame updates until the calibration
+// window closes, then derives and logs the thresholds. A frame count
+// going backwards is a retry or the next pass starting over, and counts
+// as progress.
+func (w *Watchdog) Observe(s State) {
+	if w == nil || s.Frame == w.frame {
+		return
+	}
+	now := time.Now()
+	if s.Frame < w.frame {
+		w.last, w.frame = now, s.Frame
+		return
+	}
+	if w.start.IsZero() {
+		w.start = now
+	} else if !w.armed {
+		w.gaps = append(w.gaps, now.Sub(w.last))
+		w.fps = append(w.fps, s.FPS)
+	}
+	w.last, w.frame = now, s.Frame
+	if !w.armed && now.Sub(w.start) >= calibrate && len(w.gaps) > 0 {
+		w.arm()
+	}
+}
+
+func (w *Watchdog) arm() {
+	sort.Slice(w.gaps, func(i, j int) bool { return w.gaps[i] < w.gaps[j] })
+	sort.Ints(w.fps)
+	gap, fps := w.gaps[len(w.gaps)/2], w.fps[len(w.fps)/2]
+
+	w.StallAfter = clampDur(20*gap, stallFloor, stallCeil)
+	w.MaxDup = clampInt(30*fps, dupFloor, dupCeil)
+	w.basis = fmt.Sprintf("median_gap=%s median_fps=%d samples=%d", gap, fps, len(w.gaps))
+	w.armed = true
+	log.Info.Add("topic", "watchdog", "action", "calibrated", "stall_after", w.StallAfter.Seconds(), "maxdup", w.MaxDup, "basis", w.basis).Printf("derived watchdog thresholds")
+}
+
+// Basis describes the calibration the thresholds were derived from
+func (w *Watchdog) Basis() string {
+	if w == nil {
+		return ""
+	}
+	return w.basis
+}
+
+// Stalled returns true if the derived stall threshold elapsed
+// without the frame count advancing
+func (w *Watchdog) Stalled() bool {
+	return w != nil && w.armed && os.Getenv("MAXSTALL") == "" && time.Since(w.last) > w.StallAfter
+}
+
+// DupLimit returns the dup threshold in effect and whether it was derived
+func (w *Watchdog) DupLimit() (limit int, kind string) {
+	if w == nil || !w.armed || os.Getenv("MAXDUP") != "" {
+		return maxdup, "static"
+	}
+	return w.MaxDup, "derived"
+}
+
+func clampDur(d, lo, hi time.Duration) time.Duration {
+	if d < lo {
+		return lo
+	}
+	if d > hi {
+		return hi
+	}
+	return d
+}
+
+func clampInt(n, lo, hi int) int {
+	if n < lo {
+		return lo
+	}
+	if n > hi {
+		return hi
+	}
+	return n
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/as/log"
+)
+
+func TestWatchdogCounterReset(t *testing.T) {
+	defer func(d time.Duration) { calibrate = d }(calibrate)
+	calibrate = time.Millisecond
+	defer log.SetOutput(log.SetOutput(new(bytes.Buffer)))
+
+	w := &Watchdog{}
+	for _, s := range frames(100, 200, 300) {
+		w.Observe(s)
+		time.Sleep(2 * time.Millisecond)
+	}
+	if !w.armed {
+		t.Fatal("watchdog didn't arm")
+	}
+	w.last = time.Now().Add(-time.Hour)
+	if !w.Stalled() {
+		t.Fatal("an hour without frames isn't a stall")
+	}
+	w.Observe(frames(0)[0])
+	if w.Stalled() {
+		t.Errorf("stalled right after the counter dropped to 0")
+	}
+	w.last = time.Now().Add(-time.Hour)
+	w.Observe(frames(5)[0])
+	if w.Stalled() || w.frame != 5 {
+		t.Errorf("frame %d after 0, stalled %v; want frame 5 and not stalled", w.frame, w.Stalled())
+	}
+}
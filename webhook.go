@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/as/log"
+)
+
+var (
+	// progressURL, if set, receives a json POST of the status on every
+	// LOGFREQ tick and once more when the process exits
+	progressURL = os.Getenv("PROGRESS_URL")
+
+	// progressToken is sent to PROGRESS_URL as a bearer token
+	progressToken = os.Getenv("PROGRESS_TOKEN")
+
+	// progressEvery is the minimum time between POSTs, for a tight
+	// LOGFREQ. The final POST is always sent. default=0, every tick
+	progressEvery = envDur("PROGRESS_INTERVAL")
+)
+
+// fieldMap converts log fields to a map for json, dropping the
+// unavailable (nil) ones the same way the logger does
+func fieldMap(kv ...any) map[string]any {
+	m := map[string]any{}
+	for i := 0; i+1 < len(kv); i += 2 {
+		k, _ := kv[i].(string)
+		if k == "" || kv[i+1] == nil {
+			continue
+		}
+		m[k] = kv[i+1]
+	}
+	return m
+}
+
+// statusDoc is the json form of a status update
+func statusDoc(s State, progress int) map[string]any {
+	return fieldMap(append(s.Fields(),
+		"progress", progress,
+		"uptime", time.Since(procstart).Seconds(),
+		"retry", retry,
+		"outcome", "running",
+	)...)
+}
+
+// Webhook posts status updates to PROGRESS_URL from its own goroutine,
+// so a slow endpoint never holds up the status loop. Updates that arrive
+// while a POST is in flight replace each other; only the latest is sent.
+type Webhook struct {
+	client  *http.Client
+	pending chan map[string]any
+
+	mu   sync.Mutex // held while posting
+	doc  map[string]any
+	last time.Time
+	done bool
+}
+
+// startWebhook returns nil when PROGRESS_URL is unset. The final
+// update is posted synchronously at exit, including fatal exits.
+func startWebhook() *Webhook {
+	if progressURL == "" {
+		return nil
+	}
+	w := &Webhook{
+		client:  &http.Client{Timeout: 5 * time.Second},
+		pending: make(chan map[string]any, 1),
+	}
+	go func() {
+		for doc := range w.pending {
+			w.mu.Lock()
+			if !w.done {
+				w.post(doc)
+			}
+			w.mu.Unlock()
+		}
+	}()
+	atExit(func() {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		w.done = true
+		if w.doc == nil {
+			w.doc = statusDoc(State{}, 0)
+		}
+		w.doc["outcome"] = outcome
+		w.doc["uptime"] = time.Since(procstart).Seconds()
+		w.post(w.doc)
+	})
+	return w
+}
+
+// Update queues s for the endpoint, unless the last POST was less than
+// PROGRESS_INTERVAL ago. It's a no-op on a nil Webhook.
+func (w *Webhook) Update(s State, progress int) {
+	if w == nil {
+		return
+	}
+	doc := statusDoc(s, progress)
+	w.doc = doc
+	if time.Since(w.last) < progressEvery {
+		return
+	}
+	w.last = time.Now()
+	select {
+	case <-w.pending:
+	default:
+	}
+	w.pending <- doc
+}
+
+// post sends doc, retrying twice with backoff
+func (w *Webhook) post(doc map[string]any) {
+	body, err := json.Marshal(doc)
+	if err != nil {
+		log.Warn.Add("topic", "webhook", "action", "encode", "err", err).Printf("cant encode progress")
+		return
+	}
+	backoff := 250 * time.Millisecond
+	for try := 0; ; try++ {
+		if err = w.try(body); err == nil {
+			return
+		}
+		if try == 2 {
+			break
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+	log.Warn.Add("topic", "webhook", "action", "post", "outcome", doc["outcome"], "err", err).Printf("progress post failed")
+}
+
+func (w *Webhook) try(body []byte) error {
+	req, err := http.NewRequest("POST", progressURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if progressToken != "" {
+		req.Header.Set("Authorization", "Bearer "+progressToken)
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s", resp.Status)
+	}
+	return nil
+}
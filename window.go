@@ -0,0 +1,61 @@
+package main
+
+import (
+	"time"
+)
+
+// window is the span of the sliding window used for the smoothed
+// fps and speed. default=30s
+var window = envDur("WINDOW")
+
+type sample struct {
+	at time.Time
+	s  State
+}
+
+// Window computes fps and speed over the states seen in the last span,
+// which is steadier than ffmpeg's instantaneous numbers
+type Window struct {
+	span    time.Duration
+	samples []sample
+}
+
+func NewWindow(span time.Duration) *Window {
+	if span <= 0 {
+		span = 30 * time.Second
+	}
+	return &Window{span: span}
+}
+
+// Add records s at the current time and drops samples older than the span.
+// A frame count that goes backwards (a new pass) starts the window over.
+func (w *Window) Add(s State) {
+	now := time.Now()
+	if n := len(w.samples); n > 0 && s.Frame < w.samples[n-1].s.Frame {
+		w.samples = w.samples[:0]
+	}
+	w.samples = append(w.samples, sample{now, s})
+	i := 0
+	for i < len(w.samples)-2 && now.Sub(w.samples[i].at) > w.span {
+		i++
+	}
+	w.samples = append(w.samples[:0], w.samples[i:]...)
+}
+
+func (w *Window) Fields() []any {
+	n := len(w.samples)
+	if n < 2 {
+		return nil
+	}
+	first, prev, last := w.samples[0], w.samples[n-2], w.samples[n-1]
+	wall := last.at.Sub(first.at).Seconds()
+	if wall <= 0 {
+		return nil
+	}
+	media := (last.s.Time.Duration() - first.s.Time.Duration()).Seconds()
+	return []any{
+		"fps_avg", round100(float64(last.s.Frame-first.s.Frame) / wall),
+		"speed_avg", round100(media / wall),
+		"frames_per_interval", last.s.Frame - prev.s.Frame,
+	}
+}